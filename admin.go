@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"golang.org/x/net/websocket"
+)
+
+// Subcommands of "admin". The admin command itself requires permAdmin, each
+// subcommand can require more.
+var adminCommands = []command{
+	{"feature", 0, permAdmin, doAdminFeature},
+	{"rebuild", 0, permAdmin, doAdminRebuild},
+	{"challenges", 0, permManageChallenges, doAdminChallenges},
+	{"handicap", 0, permAdmin, doAdminHandicap},
+	{"guesses", 0, permAdmin, doAdminGuesses},
+	{"export", 1, permAdmin, doAdminExport},
+	{"purge-user", 1, permAdmin, doAdminPurgeUser},
+	{"outage", 1, permAdmin, doAdminOutage},
+	{"debug", 1, permAdmin, doAdminDebug},
+	{"setup", 0, permAdmin, doAdminSetup},
+	{"add-user", 1, permAdmin, doAdminAddUser},
+	{"assign-team", 2, permAdmin, doAdminAssignTeam},
+	{"reset-team", 1, permAdmin, doAdminResetTeam},
+	{"registrations", 0, permAdmin, doAdminRegistrations},
+	{"open-level", 1, permAdmin, doAdminOpenLevel},
+	{"doctor", 0, permAdmin, doAdminDoctor},
+	{"preflight", 0, permAdmin, doAdminPreflight},
+}
+
+func doAdmin(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if !runCommand(ctx, adminCommands, config, db, ws, m, args) {
+		postError(ctx, ws, m.Channel, "sorry, I didn't understand that admin command.", m.User)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// With scoreboard_aliases, the scoreboard, announcements and observer
+// updates show generated aliases ("Teal Otter") instead of team names until
+// ctf_end, so nobody can tell who is at the bottom. Each team is told its alias when it starts, and the ceremony
+// reveals them all.
+//
+// An alias is picked by an affine permutation of the team ID, so two teams
+// never get the same one (for the first len(aliasAdjectives) *
+// len(aliasAnimals) teams). scoreboard_alias_seed shuffles them.
+
+var aliasAdjectives = []string{
+	"Amber", "Azure", "Bold", "Brave", "Bright", "Calm", "Clever", "Copper",
+	"Crimson", "Daring", "Eager", "Fierce", "Gentle", "Golden", "Happy", "Ivory",
+	"Jade", "Jolly", "Keen", "Lively", "Lucky", "Mighty", "Nimble", "Olive",
+	"Proud", "Quick", "Quiet", "Rapid", "Silver", "Swift", "Teal", "Witty",
+}
+
+var aliasAnimals = []string{
+	"Badger", "Bison", "Crane", "Dingo", "Eagle", "Falcon", "Ferret", "Gecko",
+	"Heron", "Ibex", "Jackal", "Koala", "Lemur", "Llama", "Lynx", "Marmot",
+	"Moose", "Newt", "Ocelot", "Orca", "Otter", "Panda", "Puffin", "Quokka",
+	"Raven", "Salmon", "Tapir", "Toucan", "Walrus", "Wombat", "Yak", "Zebra",
+}
+
+// aliasStep is odd, hence coprime with the (power of two) number of aliases.
+const aliasStep = 733
+
+func teamAlias(config Config, teamID int) string {
+	n := len(aliasAdjectives) * len(aliasAnimals)
+	i := ((teamID*aliasStep+config.ScoreboardAliasSeed)%n + n) % n
+	return aliasAdjectives[i%len(aliasAdjectives)] + " " + aliasAnimals[i/len(aliasAdjectives)]
+}
+
+// aliasesActive is true while the scoreboard should hide team names.
+func aliasesActive(config Config, now time.Time) bool {
+	return config.ScoreboardAliases && (config.CtfEnd.IsZero() || now.Before(config.CtfEnd))
+}
+
+// publicTeamLabel is how a team is shown in public and to observers: its
+// alias while aliases are active, its teamLabel otherwise. Messages to the
+// team's own members can use its real name.
+func publicTeamLabel(config Config, teamID int, teamName string, now time.Time) string {
+	if aliasesActive(config, now) {
+		return teamAlias(config, teamID)
+	}
+	return teamLabel(config, teamID, teamName)
+}
+
+// revealAliases announces which team was behind each alias.
+func revealAliases(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, scores []teamScores) {
+	if !config.ScoreboardAliases || len(scores) == 0 {
+		return
+	}
+	lines := []string{"The aliases were:"}
+	for _, s := range scores {
+		lines = append(lines, fmt.Sprintf("%s: Team %s", teamAlias(config, s.teamID), teamLabel(config, s.teamID, s.teamName)))
+	}
+	logf(ctx, "revealAliases: %d teams", len(scores))
+	announce(config, db, ws, strings.Join(lines, "\n"))
+}
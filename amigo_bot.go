@@ -11,28 +11,33 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/alokmenghrajani/mybot/internal/store"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/nlopes/slack"
+	"github.com/slack-go/slack"
 	"golang.org/x/net/websocket"
 )
 
 type user struct {
 	username       string
 	privateChannel string
+	// From the Slack profile, e.g. "fr-FR", see i18n.go.
+	locale string
 }
 
 var userCache map[string]user
 var userCacheLock sync.Mutex
 
-func resolveUser(config Config, userToken string) (user, error) {
+func resolveUser(ctx context.Context, config Config, userToken string) (user, error) {
 	userCacheLock.Lock()
 	defer userCacheLock.Unlock()
 
@@ -41,56 +46,63 @@ func resolveUser(config Config, userToken string) (user, error) {
 		return u, nil
 	}
 
-	log.Printf("resolving user: %s", userToken)
-	api := slack.New(config.SlackApiToken)
-	userInfo, err := api.GetUserInfo(userToken)
+	logf(ctx, "resolving user: %s", userToken)
+	api := newSlackClient(config)
+	var userInfo *slack.User
+	err := traceSlack(ctx, "users.info", func() (err error) {
+		userInfo, err = api.GetUserInfoContext(ctx, userToken)
+		return
+	})
 	if err != nil {
-		log.Printf("api.GetUserInfo: %s", err)
+		logf(ctx, "api.GetUserInfo: %s", err)
 		return user{}, err
 	}
-	_, _, imChannel, err := api.OpenIMChannel(userToken)
+	var im *slack.Channel
+	err = traceSlack(ctx, "conversations.open", func() (err error) {
+		im, _, _, err = api.OpenConversationContext(ctx, &slack.OpenConversationParameters{Users: []string{userToken}})
+		return
+	})
 	if err != nil {
-		log.Printf("api.OpenIMChannel: %s", err)
-		return user{}, err
+		logf(ctx, "api.OpenConversation: %s", err)
+		// Not cached, so the next command tries again.
+		return user{username: userInfo.Name, locale: userInfo.Locale}, &DMUnavailable{Err: err}
 	}
-	newUser := user{username: userInfo.Name, privateChannel: imChannel}
+	newUser := user{username: userInfo.Name, privateChannel: im.ID, locale: userInfo.Locale}
 	userCache[userToken] = newUser
 	return newUser, nil
 }
 
-func resolveChannel(config Config) string {
+// resolveChannel finds the ID of config.PublicChannel, which can be a name or
+// an ID.
+func resolveChannel(config Config) (string, error) {
+	if isChannelID(config.PublicChannel) {
+		return config.PublicChannel, nil
+	}
 	log.Printf("resolving channel: %s", config.PublicChannel)
-	api := slack.New(config.SlackApiToken)
-	groups, err := api.GetGroups(true)
+	ids, err := channelIDs(config)
 	if err != nil {
-		log.Printf("api.GetGroups: %s", err)
-	} else {
-		for _, group := range groups {
-			if group.Name == config.PublicChannel {
-				return group.ID
-			}
-		}
+		return "", err
 	}
-
-	channels, err := api.GetChannels(true)
-	if err != nil {
-		log.Printf("api.GetChannels: %s", err)
-	} else {
-		for _, channel := range channels {
-			if channel.Name == config.PublicChannel {
-				return channel.ID
-			}
-		}
+	id, ok := ids[config.PublicChannel]
+	if !ok {
+		return "", fmt.Errorf("no channel called %s (is the bot a member?)", config.PublicChannel)
 	}
-
-	return ""
+	return id, nil
 }
 
 func isPrivate(channel string) bool {
 	return strings.HasPrefix(channel, "D")
 }
 
-func postError(ws *websocket.Conn, channel string, message string, userToken string) {
+func postError(ctx context.Context, ws *websocket.Conn, channel string, message string, userToken string) {
+	send, notice := errorLimits.check(userToken, message, time.Now())
+	if !send {
+		logf(ctx, "error (not repeated): %s", message)
+		return
+	}
+	if notice {
+		message += " (I won't repeat this reply for the next minute.)"
+	}
 	var m Message
 	m.Type = "message"
 	m.Channel = channel
@@ -99,451 +111,512 @@ func postError(ws *websocket.Conn, channel string, message string, userToken str
 	} else {
 		m.Text = fmt.Sprintf("<@%s>: %s", userToken, message)
 	}
-	log.Printf("error: %s", message)
+	logf(ctx, "error: %s", message)
 	postMessage(ws, m)
 }
 
-var publicChannel string
+// postInternalError logs err and tells the user something went wrong, with
+// the correlation ID they can quote to an admin.
+func postInternalError(ctx context.Context, ws *websocket.Conn, channel string, err error, userToken string) {
+	logf(ctx, "internal error: %s", err)
+	postError(ctx, ws, channel, fmt.Sprintf("sorry, something went wrong (ref: %s)", correlationID(ctx)), userToken)
+}
+
+// postText sends a plain message to a channel, split into several messages
+// if it's too long.
+func postText(ws *websocket.Conn, channel string, text string) {
+	for _, part := range splitMessage(text) {
+		var m Message
+		m.Type = "message"
+		m.Channel = channel
+		m.Text = part
+		postMessage(ws, m)
+	}
+}
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply the pending schema migrations and exit, see migrations.go")
+	initDb := flag.Bool("init-db", false, "same as -migrate")
+	tenants := flag.String("tenants", "", "run one bot per subdirectory of this directory, see tenants.go")
+	genFixturePath := flag.String("gen-fixture", "", "write a synthetic event log to this file and exit, see fixtures.go")
+	fixtureTeams := flag.Int("fixture-teams", 50, "number of teams in -gen-fixture")
+	fixtureSeed := flag.Int64("fixture-seed", 1, "random seed for -gen-fixture")
+	loadFixturePath := flag.String("load-fixture", "", "insert a fixture into the database and exit")
+	flag.Parse()
+	if *genFixturePath != "" {
+		err := genFixture(*genFixturePath, *fixtureTeams, *fixtureSeed)
+		if err != nil {
+			log.Panicf("Failed to write the fixture: %s", err)
+		}
+		return
+	}
+	if *tenants != "" {
+		runTenants(*tenants)
+		return
+	}
+
 	userCache = make(map[string]user)
 	userCacheLock = sync.Mutex{}
 
 	config := configRead()
+	setSlackAPIURL(config)
+	setWebsocketTimeout(config)
+	setDebugTraffic(config)
+	if !store.ValidPrefix(config.TablePrefix) {
+		log.Panicf("table_prefix can only have letters, digits and _")
+	}
+	tablePrefix = config.TablePrefix
+	if config.SolveTicker != "" && config.SolveTicker != tickerPost && config.SolveTicker != tickerPin {
+		log.Panicf("solve_ticker must be empty, %q or %q", tickerPost, tickerPin)
+	}
+	if config.RateLimit.Burst > 0 && config.RateLimit.PerMinute <= 0 {
+		log.Panicf("rate_limit needs per_minute with burst")
+	}
+	var err error
+	dialect, err = store.DialectFor(config.DatabaseDriver)
+	if err != nil {
+		log.Panicf("%s", err)
+	}
 	fmt.Print("[OK] Config\n")
 
+	err = loadChallenges(config)
+	if err != nil {
+		log.Panicf("Failed to load challenges: %s", err)
+	}
+	fmt.Printf("[OK] %d challenges\n", len(currentChallenges()))
+	err = loadCatalogs(config)
+	if err != nil {
+		log.Panicf("Failed to load catalogs: %s", err)
+	}
+
+	shutdownTracing := initTracing(config)
+	defer shutdownTracing()
+
 	// Connect to database
-	db, err := sql.Open("mysql", config.MysqlConn)
+	db, err := openDB(config, config.MysqlConn)
 	if err != nil {
 		log.Panicf("Failed to connect to database: %s", err)
 	}
+	if config.MysqlReplicaConn != "" {
+		replicaDB, err = openDB(config, config.MysqlReplicaConn)
+		if err != nil {
+			log.Panicf("Failed to connect to the read replica: %s", err)
+		}
+	}
 	fmt.Print("[OK] Database\n")
 
-	// Connect to Slack using Websocket Real Time API
-	ws, botID := slackConnect(config.SlackApiToken)
+	applied, err := migrate(context.Background(), db)
+	if err != nil {
+		log.Panicf("Failed to migrate the database: %s", err)
+	}
+	fmt.Printf("[OK] Schema (%d migrations applied)\n", applied)
+	if *migrateOnly || *initDb {
+		return
+	}
+	if *loadFixturePath != "" {
+		rows, err := readFixture(*loadFixturePath)
+		if err != nil {
+			log.Panicf("Failed to read the fixture: %s", err)
+		}
+		err = loadFixture(context.Background(), db, rows)
+		if err != nil {
+			log.Panicf("Failed to load the fixture: %s", err)
+		}
+		fmt.Printf("[OK] %d fixture rows\n", len(rows))
+		return
+	}
+
+	// Connect to Slack using Websocket Real Time API, unless Socket Mode is
+	// used, in which case ws stays nil and messages go through the Web API.
+	var ws *websocket.Conn
+	var botID string
+	if config.SlackAppToken != "" {
+		botID, err = botUserID(config)
+		if err != nil {
+			log.Panicf("Failed to identify the bot: %s", err)
+		}
+		webAPIFallbackToken = config.SlackApiToken
+	} else {
+		ws, botID, err = slackConnect(config.SlackApiToken)
+		if err != nil {
+			log.Fatal(err)
+		}
+		setRTMConn(ws)
+		slackConnectedAt = time.Now()
+	}
 	fmt.Print("[OK] Slack\n")
 
-	publicChannel = resolveChannel(config)
+	startupCtx := withCorrelationID(context.Background(), "startup")
+	err = rebuildProjections(startupCtx, db)
+	if err != nil {
+		log.Panicf("Failed to rebuild projections: %s", err)
+	}
+	err = loadOpenedLevels(startupCtx, db)
+	if err != nil {
+		log.Panicf("Failed to load opened levels: %s", err)
+	}
+	err = loadLeader(startupCtx, config, db)
+	if err != nil {
+		log.Panicf("Failed to load the leader: %s", err)
+	}
 
+	err = ensureChannels(startupCtx, config, db)
+	if err != nil {
+		log.Panicf("Failed to create channels: %s", err)
+	}
+	channel, err := resolveChannel(config)
+	if err != nil {
+		log.Panicf("Failed to resolve public_channel: %s", err)
+	}
+	setPublicChannel(channel)
+	reconcileOutbox(startupCtx, config, db, ws)
+	startScheduler(config, db, ws)
+	startDemo(config, db, ws)
+	if config.EventsAPI {
+		webAPIFallbackToken = config.SlackApiToken
+	}
+	startHTTP(config, db, ws, botID)
+
+	if config.SlackAppToken != "" {
+		runSocketMode(config, db, botID)
+		return
+	}
+	startPinging()
+	noteRead()
 	for {
 		// read each incoming message
 		m, err := getMessage(ws)
+		if isTimeout(err) {
+			log.Printf("getMessage: nothing received for %s, the connection is dead", wsTimeout)
+		}
+		if err != nil && connectionLost(err) {
+			log.Printf("getMessage failed: %s, reconnecting", err)
+			ws, botID = reconnectRTM(startupCtx, config, ws, botID)
+			noteRead()
+			continue
+		}
 		if err != nil {
 			log.Printf("getMessage failed: %s", err)
 			continue
 		}
-
-		if m.Type == "message" {
-			if m.Subtype == "" {
-				if strings.HasPrefix(m.Text, fmt.Sprintf("<@%s>", botID)) {
-					parts := strings.Fields(m.Text)
-					if len(parts) >= 2 && parts[1] == "help" {
-						go func(m Message) {
-							doHelp(config, ws, m.User, m.Channel)
-						}(m)
-					} else if len(parts) >= 3 && parts[1] == "start" {
-						go func(m Message) {
-							doStart(config, db, ws, m.User, m.Channel, strings.Join(parts[2:], " "))
-						}(m)
-					} else if len(parts) >= 4 && parts[1] == "validate" {
-						go func(m Message) {
-							doValidate(config, db, ws, m.User, m.Channel, parts[2], strings.Join(parts[3:], " "))
-						}(m)
-					} else if len(parts) >= 2 && parts[1] == "scores" {
-						go func(m Message) {
-							doTopScores(config, db, ws, m.User, m.Channel)
-						}(m)
-					} else {
-						go func(m Message) {
-							postError(ws, m.Channel, "sorry, I didn't understand that.", m.User)
-						}(m)
-					}
-				} else if strings.HasPrefix(m.Channel, "D") && m.User != botID {
-					parts := strings.Fields(m.Text)
-					if len(parts) >= 1 && parts[0] == "help" {
-						go func(m Message) {
-							doHelp(config, ws, m.User, m.Channel)
-						}(m)
-					} else if len(parts) >= 2 && parts[0] == "start" {
-						go func(m Message) {
-							doStart(config, db, ws, m.User, m.Channel, strings.Join(parts[1:], " "))
-						}(m)
-					} else if len(parts) >= 3 && parts[0] == "validate" {
-						go func(m Message) {
-							doValidate(config, db, ws, m.User, m.Channel, parts[1], strings.Join(parts[2:], " "))
-						}(m)
-					} else if len(parts) >= 1 && parts[0] == "scores" {
-						go func(m Message) {
-							doTopScores(config, db, ws, m.User, m.Channel)
-						}(m)
-					} else {
-						go func(m Message) {
-							postError(ws, m.Channel, "sorry, I didn't understand that.", m.User)
-						}(m)
-					}
-				}
-			}
-		}
+		noteRead()
+		handleMessage(config, db, ws, m, botID)
 	}
 }
 
-func doStart(config Config, db *sql.DB, ws *websocket.Conn, userToken string, channel string, teamName string) {
-	// Map userToken to user
-	u, err := resolveUser(config, userToken)
-	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
-		return
+// commandParts returns the words of a message addressed to the bot (a mention
+// or a DM), without the mention.
+func commandParts(m Message, botID string) ([]string, bool) {
+	if m.Type != "message" || (m.Subtype != "" && m.Subtype != "file_share") {
+		return nil, false
 	}
-
-	// Check user exists in users table
-	log.Printf("doStart: %s as %s", u.username, teamName)
-	var team int
-	err = db.QueryRow("SELECT team FROM users WHERE user=?", u.username).Scan(&team)
-	switch {
-	case err == sql.ErrNoRows:
-		postError(ws, channel, "sorry, I don't know which team you are on.", userToken)
-		return
-	case err != nil:
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
-		return
-	default:
+	if strings.HasPrefix(m.Text, "<@"+botID+">") {
+		return strings.Fields(m.Text)[1:], true
 	}
-
-	var aUser string
-	err = db.QueryRow("SELECT user FROM logs WHERE team_id=?", team).Scan(&aUser)
-	switch {
-	case err != nil && err != sql.ErrNoRows:
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
-		return
-	case err == nil:
-		postError(ws, channel, fmt.Sprintf("sorry, %s of your team already started the ctf!", aUser), userToken)
-		return
-	default:
+	if isPrivate(m.Channel) && m.User != botID {
+		return strings.Fields(m.Text), true
 	}
+	return nil, false
+}
 
-	// Update the team name, can only happen once.
-	_, err = db.Exec("INSERT INTO teams SET id=?, name=?", team, teamName)
+func doStart(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, userToken string, channel string, msgTs string, teamName string) {
+	// Map userToken to user. If they can't be DMed, the link is posted as an
+	// ephemeral message instead.
+	u, err := resolveUser(ctx, config, userToken)
+	var noDM *DMUnavailable
+	if errors.As(err, &noDM) && !isPrivate(channel) {
+		err = nil
+	}
 	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+		postInternalError(ctx, ws, channel, err, userToken)
 		return
 	}
 
-	// Record log event
-	_, err = db.Exec("INSERT INTO logs SET user=?, event='start'", u.username)
+	// Ignore redeliveries
+	handled, err := alreadyHandled(ctx, db, u.username, msgTs)
 	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+		postInternalError(ctx, ws, channel, err, userToken)
 		return
 	}
-
-	// Post to public channel
-	var m Message
-	m.Type = "message"
-	m.Channel = publicChannel
-	m.Text = fmt.Sprintf("Team %s has entered the competition!", teamName)
-	postMessage(ws, m)
-
-	// Return link
-	m.Type = "message"
-	m.Text = fmt.Sprintf("Here is a link to the puzzle: %s", config.PuzzleLink)
-	if isPrivate(channel) {
-		m.Channel = channel
-	} else {
-		m.Channel = u.privateChannel
+	if handled {
+		return
 	}
-	postMessage(ws, m)
-	log.Printf("doStart: done (%s)", u.username)
-}
 
-func doValidate(config Config, db *sql.DB, ws *websocket.Conn, userToken string, channel string, sLevel string, flag string) {
-	// Map userToken to user
-	u, err := resolveUser(config, userToken)
+	// Check user exists in users table
+	logf(ctx, "doStart: %s as %s", u.username, teamName)
+	team, err := queries(db).UserTeam(ctx, u.username)
+	if err == sql.ErrNoRows {
+		err = &NotOnTeam{User: u.username}
+	}
 	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+		reportError(ctx, config, ws, channel, u, err, userToken)
 		return
 	}
 
-	// Check user exists in users table
-	log.Printf("doValidate: %s solving puzzle %s: %s", u.username, sLevel, flag)
-	var team string
-	var teamID int
-	err = db.QueryRow("SELECT teams.name,teams.id FROM teams JOIN users ON teams.id = users.team WHERE users.user=?", u.username).Scan(&team, &teamID)
+	aUser, err := queries(db).TeamLogUser(ctx, team)
 	switch {
-	case err == sql.ErrNoRows:
-		postError(ws, channel, "sorry, I don't know which team you are on.", userToken)
+	case err != nil && err != sql.ErrNoRows:
+		postInternalError(ctx, ws, channel, err, userToken)
 		return
-	case err != nil:
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+	case err == nil:
+		postError(ctx, ws, channel, tr(config, u, "start.already-started", "sorry, %s of your team already started the ctf!", aUser), userToken)
 		return
 	default:
 	}
 
-	// Disallow validation on public channel
-	if channel == publicChannel {
-		postError(ws, channel, fmt.Sprintf("shush!"), userToken)
-		return
-	}
-
-	level := -1
-	level, err = strconv.Atoi(sLevel)
+	// Teams which registered (see registration.go) already have their name.
+	registered, err := queries(db).TeamName(ctx, team)
 	switch {
+	case err == sql.ErrNoRows:
+		err = validateTeamName(config, u, teamName)
+		if err != nil {
+			postError(ctx, ws, channel, err.Error(), userToken)
+			return
+		}
 	case err != nil:
-		postError(ws, channel, fmt.Sprintf("%s is not a valid puzzle number", sLevel), userToken)
-		return
-	case level < 1:
-		postError(ws, channel, fmt.Sprintf("you give us too much credit for starting puzzle enumeration from 0; humans designed this, not chat bots"), userToken)
+		postInternalError(ctx, ws, channel, err, userToken)
 		return
-	case level > 3:
-		postError(ws, channel, fmt.Sprintf("woaaaaah nelly! there's no such thing as puzzle 4!"), userToken)
+	case teamName != "" && teamName != registered:
+		postError(ctx, ws, channel, tr(config, u, "start.registered", "sorry, your team registered as %s, just say `start`.", escapeText(registered)), userToken)
 		return
 	default:
+		teamName = registered
 	}
 
-	event := "incorrect:" + flag
-	eventOk := false
-
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM logs WHERE team_id=? AND level=?", teamID, level).Scan(&count)
+	link, err := puzzleLink(config, team, teamName)
 	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+		postInternalError(ctx, ws, channel, err, userToken)
 		return
 	}
 
-	switch {
-	case level == 1:
-		if flag == config.Flag1 {
-			event = "flag 1"
-			eventOk = true
-		} else if flag == config.Flag2 {
-			event = "flag 2"
-			eventOk = true
-		}
-	case level == 2:
-		// Make sure they haven't done > 10 tries
-		switch {
-		case count >= 10:
-			postError(ws, channel, fmt.Sprintf("you've exhausted your 10 tries! no points 4 u"), userToken)
+	// Update the team name, can only happen once.
+	if registered == "" {
+		err = queries(db).CreateTeam(ctx, team, teamName)
+		if err != nil {
+			postInternalError(ctx, ws, channel, err, userToken)
 			return
-		default:
-			var dupCount int
-			err = db.QueryRow("SELECT COUNT(*) FROM logs WHERE team_id=? AND level=? AND event=?", teamID, level, event).Scan(&dupCount)
-			if err != nil {
-				postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
-				return
-			}
-			if dupCount > 0 {
-				postError(ws, channel, fmt.Sprintf("you (or a teammate) already tried that guess"), userToken)
-				return
-			}
-			if flag == config.Flag3 {
-				event = "flag 3"
-				eventOk = true
-			}
-		}
-	case level == 3:
-		if flag == config.Flag4 {
-			event = "flag 4"
-			eventOk = true
-		}
-		if flag == config.Flag5 {
-			event = "flag 5"
-			eventOk = true
-		}
-		if flag == config.Flag6 {
-			event = "flag 6"
-			eventOk = true
-		}
-		if flag == config.Flag7 {
-			event = "flag 7"
-			eventOk = true
-		}
-		if flag == config.Flag8 {
-			event = "flag 8"
-			eventOk = true
 		}
 	}
 
-	// Record log event
-	_, err = db.Exec("INSERT INTO logs SET user=?, event=?, level=?, team_id=?", u.username, event, level, teamID)
+	err = createTeamUserGroup(ctx, config, db, team, teamName)
 	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
-		return
+		logf(ctx, "doStart: creating user group: %s", err)
+	}
+	// The team's user group would give its members away.
+	entered := renderEntered(publicTeamLabel(config, team, teamName, time.Now()), "")
+	if !aliasesActive(config, time.Now()) {
+		entered = renderEntered(teamLabel(config, team, teamName), teamMention(ctx, db, team))
+	}
+	welcome := tr(config, u, "start.link", "Here is a link to the puzzle: %s", link)
+	if aliasesActive(config, time.Now()) {
+		welcome += "\n" + tr(config, u, "start.alias", "Until the end, your team appears on the scoreboard as %s.", teamAlias(config, team))
+	}
+	if config.Progression {
+		// The first level is open from the start.
+		for _, c := range currentChallenges() {
+			if c.released(time.Now()) && previousLevel(c.Level) == 0 {
+				welcome += "\n\n" + describeChallengeFor(ctx, config, c, team, teamName)
+			}
+		}
 	}
 
-	// Post to public channel
-	var m Message
-	m.Type = "message"
-	if eventOk {
-		m.Channel = publicChannel
-		m.Text = fmt.Sprintf("Team %s found %s!", team, event)
-		postMessage(ws, m)
+	// Record log event, and queue the announcement and the link
+	reply := outboxItem{kind: outboxReply, channel: channel, text: welcome}
+	if !isPrivate(channel) {
+		reply.channel = u.privateChannel
+		if u.privateChannel == "" {
+			logf(ctx, "doStart: can't DM %s, posting the link in %s", u.username, channel)
+			reply = outboxItem{kind: outboxEphemeral, channel: channel, text: welcome, event: userToken}
+		}
 	}
-	if level == 2 && (count+1) == 10 && !eventOk {
-		m.Channel = publicChannel
-		m.Text = fmt.Sprintf("Team %s ran out of tries! :(", team)
-		postMessage(ws, m)
+	outbox := []outboxItem{{kind: outboxAnnounce, text: entered}, reply}
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		return recordEvent(ctx, tx, outbox, store.InsertLogParams{User: u.username, Event: "start", Ref: correlationID(ctx), MsgTs: msgTsValue(msgTs)})
+	})
+	if isDuplicateKey(err) {
+		logf(ctx, "doStart: duplicate delivery of %s", msgTs)
+		return
 	}
-
-	// Return result
-	if eventOk {
-		m.Text = fmt.Sprintf("Congrats, you found %s!", event)
-	} else {
-		m.Text = fmt.Sprintf("Sorry, that's not right.")
-		if level == 2 {
-			m.Text += fmt.Sprintf(" You have %d tries left.", 10-(count+1))
-		}
+	if err != nil {
+		postInternalError(ctx, ws, channel, err, userToken)
+		return
 	}
-	m.Channel = channel
-	postMessage(ws, m)
-	log.Printf("doValidate: done (%s)", u.username)
-}
 
-type teamScores struct {
-	teamID                                                                         int
-	hasFlag1, hasFlag2, hasFlag3, hasFlag4, hasFlag5, hasFlag6, hasFlag7, hasFlag8 bool
+	// Post to public channel and return link
+	deliverOutbox(ctx, config, db, ws, outbox)
+	inviteTeam(ctx, config, db, team)
+	logf(ctx, "doStart: done (%s)", u.username)
 }
 
-// ScoreList is things
-type ScoreList []teamScores
-
-func (s ScoreList) Len() int {
-	return len(s)
-}
-
-func (s ScoreList) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s teamScores) numFlags() int {
-	numFlags := 0
-	if s.hasFlag1 {
-		numFlags++
-	}
-	if s.hasFlag2 {
-		numFlags++
-	}
-	if s.hasFlag3 {
-		numFlags++
+func doValidate(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, userToken string, channel string, msgTs string, sLevel string, flag string, upload bool) {
+	// Map userToken to user
+	u, err := resolveUser(ctx, config, userToken)
+	if err != nil {
+		postInternalError(ctx, ws, channel, err, userToken)
+		return
 	}
-	if s.hasFlag4 {
-		numFlags++
+
+	// Ignore redeliveries
+	handled, err := alreadyHandled(ctx, db, u.username, msgTs)
+	if err != nil {
+		if queuePending(ctx, config, ws, u, userToken, channel, msgTs, sLevel, flag, upload, err) {
+			return
+		}
+		postInternalError(ctx, ws, channel, err, userToken)
+		return
 	}
-	if s.hasFlag5 {
-		numFlags++
+	if handled {
+		return
 	}
-	if s.hasFlag6 {
-		numFlags++
+
+	// Check user exists in users table
+	logf(ctx, "doValidate: %s solving puzzle %s: %s", u.username, sLevel, flag)
+	row, err := userTeam(ctx, db, u.username)
+	if err != nil {
+		if queuePending(ctx, config, ws, u, userToken, channel, msgTs, sLevel, flag, upload, err) {
+			return
+		}
+		reportError(ctx, config, ws, channel, u, err, userToken)
+		return
 	}
-	if s.hasFlag7 {
-		numFlags++
+	team, teamID := row.Name, row.ID
+
+	// Guesses replayed after an outage (see pending.go) count as sent when
+	// they were queued.
+	switch currentEventState(config, db, submittedAt(ctx)) {
+	case eventUpcoming:
+		postError(ctx, ws, channel, tr(config, u, "validate.upcoming", "the CTF starts in %s, hold on to that flag.", formatDuration(config.CtfStart.Sub(submittedAt(ctx)))), userToken)
+		return
+	case eventPaused:
+		postError(ctx, ws, channel, tr(config, u, "validate.paused", "sorry, submissions are paused right now."), userToken)
+		return
+	case eventFinished:
+		postError(ctx, ws, channel, tr(config, u, "validate.finished", "the CTF is over, submissions are closed."), userToken)
+		return
 	}
-	if s.hasFlag8 {
-		numFlags++
+
+	// Disallow validation on public channel
+	if channel == getPublicChannel() {
+		postError(ctx, ws, channel, tr(config, u, "validate.public", "please send flags in a private message."), userToken)
+		return
 	}
-	return numFlags
-}
 
-func (s ScoreList) Less(i, j int) bool {
-	numFlagsLeft := s[i].numFlags()
-	numFlagsRight := s[j].numFlags()
-	return numFlagsLeft < numFlagsRight
-}
+	if len(flag) > maxFlagLength {
+		postError(ctx, ws, channel, tr(config, u, "validate.too-long", "flags are at most %d characters long.", maxFlagLength), userToken)
+		return
+	}
 
-func doTopScores(config Config, db *sql.DB, ws *websocket.Conn, userToken string, channel string) {
-	// Fetch data
-	rows, err := db.Query("select id, event, team_id from logs where team_id < 666")
-	if err != nil {
-		postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+	level, err := parseLevel(sLevel)
+	switch {
+	case err == errLevelTooLow:
+		postError(ctx, ws, channel, tr(config, u, "validate.level-too-low", "puzzles are numbered from 1."), userToken)
+		return
+	case err != nil:
+		postError(ctx, ws, channel, tr(config, u, "validate.bad-level", "%s is not a valid puzzle number", escapeText(sLevel)), userToken)
 		return
+	case level > maxLevel():
+		postError(ctx, ws, channel, tr(config, u, "validate.no-such-level", "there is no puzzle %d.", level), userToken)
+		return
+	default:
 	}
-	defer rows.Close()
 
-	// Extract data from rows
-	teams := map[int]bool{}
-	eventCounts := map[int]map[string]int{}
+	bits := levelRules(level).pow
+	var nonce string
+	if bits > 0 {
+		flag, nonce = splitPoW(flag)
+	}
 
-	for rows.Next() {
-		var id, teamID int
-		var event string
+	// Not a try: it doesn't count, isn't logged and leaves the proof of
+	// work unused.
+	if !upload && !formatMatches(level, flag, submittedAt(ctx)) {
+		postError(ctx, ws, channel, tr(config, u, "validate.bad-format", "that doesn't look like a flag for level %d.", level), userToken)
+		return
+	}
 
-		err := rows.Scan(&id, &event, &teamID)
-		if err != nil {
-			postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+	// Guesses queued during a database outage (see pending.go) aren't
+	// checked when replayed, their challenge may have expired meanwhile.
+	if bits > 0 {
+		if _, replayed := replayedAt(ctx); !replayed && !checkPoW(u.username, level, nonce, time.Now()) {
+			if nonce == "" {
+				postError(ctx, ws, channel, tr(config, u, "validate.pow-required", "guesses for level %d need a proof of work: say `pow %d` first.", level, level), userToken)
+			} else {
+				postError(ctx, ws, channel, tr(config, u, "validate.pow-invalid", "that proof of work is wrong or expired, say `pow %d` for a new one.", level), userToken)
+			}
 			return
 		}
+	}
 
-		teams[teamID] = true
+	if _, replayed := replayedAt(ctx); !replayed && !throttleGuess(ctx, config, db, ws, u, userToken, channel, teamID, team, level) {
+		return
+	}
 
-		eventCountsForTeam, ok := eventCounts[teamID]
-		if !ok {
-			eventCounts[teamID] = map[string]int{}
-			eventCountsForTeam = eventCounts[teamID]
+	if config.BatchIncorrectGuesses {
+		if _, correct := matchChallenge(level, flag, upload, submittedAt(ctx)); !correct && levelRules(level).unlimited() {
+			bufferIncorrectGuess(bufferedLog{username: u.username, event: "incorrect:" + flag, level: level, teamID: teamID, ref: correlationID(ctx), msgTs: msgTs})
+			postText(ws, channel, tr(config, u, "validate.incorrect", "Sorry, that's not right.")+" "+tr(config, u, "validate.receipt", "(receipt %s)", correlationID(ctx)))
+			return
 		}
-
-		switch event {
-		case "start", "flag 1", "flag 2", "flag 3", "flag 4", "flag 5", "flag 6", "flag 7":
-			prevCount, ok := eventCountsForTeam[event]
-			if ok {
-				eventCountsForTeam[event] = prevCount + 1
-			} else {
-				eventCountsForTeam[event] = 1
+		// Keep the logs in order.
+		err = flushLogBuffer(ctx, db)
+		if err != nil {
+			if queuePending(ctx, config, ws, u, userToken, channel, msgTs, sLevel, flag, upload, err) {
+				return
 			}
+			postInternalError(ctx, ws, channel, err, userToken)
+			return
 		}
 	}
 
-	// Flag 1: compute start/end time as score
-	scores := []teamScores{}
-	for team := range teams {
-		_, hasFlag1 := eventCounts[team]["flag 1"]
-		_, hasFlag2 := eventCounts[team]["flag 2"]
-		_, hasFlag3 := eventCounts[team]["flag 3"]
-		_, hasFlag4 := eventCounts[team]["flag 4"]
-		_, hasFlag5 := eventCounts[team]["flag 5"]
-		_, hasFlag6 := eventCounts[team]["flag 6"]
-		_, hasFlag7 := eventCounts[team]["flag 7"]
-		_, hasFlag8 := eventCounts[team]["flag 8"]
-
-		s := teamScores{}
-		s.teamID = team
-		s.hasFlag1 = hasFlag1
-		s.hasFlag2 = hasFlag2
-		s.hasFlag3 = hasFlag3
-		s.hasFlag4 = hasFlag4
-		s.hasFlag5 = hasFlag5
-		s.hasFlag6 = hasFlag6
-		s.hasFlag7 = hasFlag7
-		s.hasFlag8 = hasFlag8
-
-		scores = append(scores, s)
-	}
-
-	sort.Sort(sort.Reverse(ScoreList(scores)))
-
-	i := 0
-	text := ""
-	for _, team := range scores {
-		rows, err := db.Query(fmt.Sprintf("select name from teams where id = %d", team.teamID))
-		if err != nil {
-			postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
-			return
+	// Check and record the attempt in a single transaction.
+	sub := submission{teamID: teamID, team: team, level: level, flag: flag, who: u.username, msgTs: msgTs, u: u, upload: upload}
+	sub.reply = func(r submitResult) []outboxItem {
+		var result string
+		if r.correct {
+			result = tr(config, u, "validate.correct", "Congrats, you found %s!", r.event)
+		} else {
+			rules := levelRules(level)
+			result = tr(config, u, "validate.incorrect", "Sorry, that's not right.")
+			if r.left >= 0 {
+				result += " " + tr(config, u, "validate.tries-left", "You have %d tries left.", r.left)
+			}
+			if r.left == 0 && rules.lockout > 0 {
+				result += " " + tr(config, u, "validate.next-round", "You get %d more in %s.", rules.max, formatWait(rules.lockout))
+			}
+			// Quoted by "appeal" if the team thinks the guess was right.
+			result += " " + tr(config, u, "validate.receipt", "(receipt %s)", correlationID(ctx))
 		}
-		defer rows.Close()
-
-		var teamName string
-		rows.Next()
-		err = rows.Scan(&teamName)
-		if err != nil {
-			postError(ws, channel, fmt.Sprintf("sorry, something went wrong (%s)", err), userToken)
+		replies := []outboxItem{{kind: outboxReply, channel: channel, text: result}}
+		if r.unlockedText != "" {
+			dm := u.privateChannel
+			if dm == "" {
+				dm = channel
+			}
+			replies = append(replies, outboxItem{kind: outboxReply, channel: dm, text: r.unlockedText})
+		}
+		return replies
+	}
+	var r submitResult
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		r, err = submitFlag(ctx, config, tx, sub)
+		return err
+	})
+	if isDuplicateKey(err) {
+		logf(ctx, "doValidate: duplicate delivery of %s", msgTs)
+		return
+	}
+	if err != nil {
+		if queuePending(ctx, config, ws, u, userToken, channel, msgTs, sLevel, flag, upload, err) {
 			return
 		}
-
-		text += fmt.Sprintf("# %d: Team '%s' found %d flags\n", i, teamName, team.numFlags())
-		i++
+		postInternalError(ctx, ws, channel, err, userToken)
+		return
+	}
+	if r.rejection != nil {
+		reportError(ctx, config, ws, channel, u, r.rejection, userToken)
+		return
 	}
 
-	// Post to public channel
-	var m Message
-	m.Type = "message"
-	m.Text = text
-	m.Channel = channel
-	postMessage(ws, m)
+	// Post to public channel and return result
+	afterSubmit(ctx, config, db, ws, sub, r, u.username, fmt.Sprintf("%s found %s for your team!", u.username, r.event))
+	logf(ctx, "doValidate: done (%s)", u.username)
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Everything the bot says in the public channel goes through announce. Solves
+// go through announceSolve, which picks a celebration (see celebrations.go)
+// or, in digest mode
+// (config.AnnouncementDigestMinutes > 0) only counts them; the
+// announcement-digest job then posts a summary every so often.
+
+type solveDigest struct {
+	lock      sync.Mutex
+	since     time.Time
+	solves    map[string]int
+	teamOrder []string
+	outboxIDs []int64
+}
+
+var pendingSolves = solveDigest{since: time.Now(), solves: map[string]int{}}
+
+func announce(config Config, db *sql.DB, ws *websocket.Conn, text string) {
+	if !featureEnabled(db, "announcements") {
+		return
+	}
+	postText(ws, getPublicChannel(), text)
+}
+
+// announceSolve announces that label (see teamLabel) found event. The outbox
+// row is marked as posted once the announcement (or the digest which includes
+// it) went out.
+func announceSolve(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, label string, event string, outboxID int64) {
+	if config.AnnouncementDigestMinutes <= 0 {
+		celebrate(ctx, config, db, ws, label, event)
+		markPosted(ctx, db, outboxID)
+		return
+	}
+
+	pendingSolves.lock.Lock()
+	defer pendingSolves.lock.Unlock()
+	if _, ok := pendingSolves.solves[label]; !ok {
+		pendingSolves.teamOrder = append(pendingSolves.teamOrder, label)
+	}
+	pendingSolves.solves[label]++
+	pendingSolves.outboxIDs = append(pendingSolves.outboxIDs, outboxID)
+}
+
+func postSolveDigest(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if config.AnnouncementDigestMinutes <= 0 {
+		return
+	}
+	if inQuietHours(config, time.Now()) {
+		return
+	}
+	interval := time.Duration(config.AnnouncementDigestMinutes) * time.Minute
+
+	pendingSolves.lock.Lock()
+	if time.Since(pendingSolves.since) < interval {
+		pendingSolves.lock.Unlock()
+		return
+	}
+	since := pendingSolves.since
+	solves := pendingSolves.solves
+	teams := pendingSolves.teamOrder
+	outboxIDs := pendingSolves.outboxIDs
+	pendingSolves.solves = map[string]int{}
+	pendingSolves.teamOrder = nil
+	pendingSolves.outboxIDs = nil
+	pendingSolves.since = time.Now()
+	pendingSolves.lock.Unlock()
+
+	if len(teams) == 0 {
+		return
+	}
+	logf(ctx, "postSolveDigest: %d teams", len(teams))
+	minutes := int(time.Since(since).Minutes())
+	announce(config, db, ws, renderDigest(minutes, teams, solves))
+	for _, id := range outboxIDs {
+		markPosted(ctx, db, id)
+	}
+}
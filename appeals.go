@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// A team which thinks a guess was wrongly rejected can appeal it, quoting the
+// receipt from the bot's reply. Admins get a DM with a button per challenge
+// of that level ("accept as ...") and a reject button, which needs
+// http_listen (see interactivity.go). Accepting turns the logged guess into a
+// solve of that challenge.
+
+// appeal <receipt> <reason>
+func doAppeal(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	receipt, reason := args[0], strings.Join(args[1:], " ")
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	team, err := queries(db).UserTeam(ctx, u.username)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	guess, err := queries(db).RejectedGuess(ctx, receipt, team)
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, tr(config, u, "appeal.no-guess", "sorry, your team has no rejected guess with receipt %s.", escapeText(receipt)), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	appealID, err := queries(db).CreateAppeal(ctx, guess.LogID, u.username, reason, correlationID(ctx))
+	if isDuplicateKey(err) {
+		postError(ctx, ws, m.Channel, tr(config, u, "appeal.duplicate", "sorry, that guess was already appealed."), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAppeal: %s appealed %s (appeal %d)", u.username, receipt, appealID)
+	postText(ws, m.Channel, tr(config, u, "appeal.sent", "Thanks, the organizers will look at your appeal (#%d).", appealID))
+
+	text := fmt.Sprintf("Appeal #%d from %s: guess `%s` for level %d was rejected. Reason: %s", appealID, u.username, escapeText(strings.TrimPrefix(guess.Event, "incorrect:")), guess.Level, escapeText(reason))
+	buttons := []interface{}{}
+	for _, c := range currentChallenges() {
+		if c.Level != guess.Level {
+			continue
+		}
+		buttons = append(buttons, map[string]interface{}{
+			"type":      "button",
+			"text":      map[string]string{"type": "plain_text", "text": "Accept as " + c.Title},
+			"action_id": fmt.Sprintf("appeal_accept.%d", c.ID),
+			"value":     fmt.Sprintf("%d:%d", appealID, c.ID),
+		})
+	}
+	buttons = append(buttons, map[string]interface{}{
+		"type":      "button",
+		"text":      map[string]string{"type": "plain_text", "text": "Reject"},
+		"style":     "danger",
+		"action_id": "appeal_reject",
+		"value":     strconv.FormatInt(appealID, 10),
+	})
+	blocks := []interface{}{
+		map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+		map[string]interface{}{"type": "actions", "elements": buttons},
+	}
+	notifyAdmins(ctx, config, db, text, blocks)
+}
+
+// notifyAdmins DMs every user with the admin role.
+func notifyAdmins(ctx context.Context, config Config, db *sql.DB, text string, blocks []interface{}) {
+	admins, err := adminUsernames(ctx, config, db)
+	if err != nil {
+		logf(ctx, "notifyAdmins: %s", err)
+		return
+	}
+	for _, username := range admins {
+		id, err := resolveUsername(ctx, config, username)
+		if err != nil {
+			logf(ctx, "notifyAdmins: %s", err)
+			continue
+		}
+		u, err := resolveUser(ctx, config, id)
+		if err != nil {
+			logf(ctx, "notifyAdmins: %s", err)
+			continue
+		}
+		err = postBlocks(config, u.privateChannel, text, blocks)
+		if err != nil {
+			logf(ctx, "notifyAdmins: %s", err)
+		}
+	}
+}
+
+// adminUsername returns the clicking user's name if they are an admin, by role
+// or through admin_user_ids.
+func adminUsername(ctx context.Context, config Config, db *sql.DB, in interaction) (string, bool) {
+	u, err := resolveUser(ctx, config, in.userID)
+	if err != nil {
+		logf(ctx, "adminUsername: %s", err)
+		return "", false
+	}
+	if configAdmin(config, in.userID) {
+		return u.username, true
+	}
+	ok, err := hasPermission(ctx, db, u.username, permAdmin)
+	if err != nil {
+		logf(ctx, "adminUsername: %s", err)
+		return "", false
+	}
+	return u.username, ok
+}
+
+func doAppealAccept(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, in interaction) string {
+	admin, ok := adminUsername(ctx, config, db, in)
+	if !ok {
+		return ""
+	}
+	var appealID int64
+	var challengeID int
+	if _, err := fmt.Sscanf(in.value, "%d:%d", &appealID, &challengeID); err != nil {
+		logf(ctx, "doAppealAccept: %s", err)
+		return ""
+	}
+	c, found := challengeByEvent(fmt.Sprintf("flag %d", challengeID))
+	if !found {
+		return fmt.Sprintf("Appeal #%d: challenge %d doesn't exist anymore.", appealID, challengeID)
+	}
+
+	var username string
+	var teamID int
+	var decided, alreadySolved bool
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		var logID int
+		var at time.Time
+		var err error
+		q := queries(tx)
+		username, logID, decided, err = q.DecideAppeal(ctx, appealID, "accepted", admin)
+		if err != nil || !decided {
+			return err
+		}
+		teamID, at, err = q.LogEntry(ctx, logID)
+		if err != nil {
+			return err
+		}
+		solved, err := teamSolved(ctx, tx, teamID)
+		if err != nil {
+			return err
+		}
+		if missing := c.missingRequirements(solved); len(missing) > 0 {
+			// Rolls the decision back.
+			return &UserError{fmt.Sprintf("the team hasn't solved %s, which %s requires.", missing[0].Title, c.Title)}
+		}
+		err = q.SetLogEvent(ctx, logID, c.event())
+		if err != nil {
+			return err
+		}
+		alreadySolved = solved[c.event()]
+		if alreadySolved {
+			return nil
+		}
+		err = applyToProjections(ctx, tx, store.InsertLogParams{Event: c.event(), TeamID: sql.NullInt64{Int64: int64(teamID), Valid: true}})
+		if err != nil {
+			return err
+		}
+		return q.SetSolveTime(ctx, teamID, c.event(), at)
+	})
+	var userErr *UserError
+	if errors.As(err, &userErr) {
+		return fmt.Sprintf("Appeal #%d can't be accepted as %s: %s", appealID, c.Title, userErr.Error())
+	}
+	if err != nil {
+		logf(ctx, "doAppealAccept: %s", err)
+		return fmt.Sprintf("Appeal #%d: something went wrong (ref: %s)", appealID, correlationID(ctx))
+	}
+	if !decided {
+		return fmt.Sprintf("Appeal #%d was already decided.", appealID)
+	}
+	logf(ctx, "doAppealAccept: %s accepted appeal %d as %s", admin, appealID, c.event())
+	if alreadySolved {
+		err = dmUsername(ctx, config, ws, username, tr(config, userNamed(ctx, config, username), "appeal.accepted-solved", "Your appeal #%d was accepted, but your team had already found %s.", appealID, c.event()))
+		if err != nil {
+			logf(ctx, "doAppealAccept: %s", err)
+		}
+		return fmt.Sprintf("Appeal #%d accepted as %s by %s, the team had already found it.", appealID, c.Title, admin)
+	}
+	err = dmUsername(ctx, config, ws, username, tr(config, userNamed(ctx, config, username), "appeal.accepted", "Your appeal #%d was accepted, your team found %s!", appealID, c.event()))
+	if err != nil {
+		logf(ctx, "doAppealAccept: %s", err)
+	}
+	if name, err := queries(db).TeamName(ctx, teamID); err == nil {
+		announce(config, db, ws, fmt.Sprintf("After an appeal, team %s found %s!", publicTeamLabel(config, teamID, name, time.Now()), c.event()))
+	}
+	checkLeadChange(ctx, config, db, ws)
+	updateTicker(ctx, config, db, ws, c.event())
+	return fmt.Sprintf("Appeal #%d accepted as %s by %s.", appealID, c.Title, admin)
+}
+
+func doAppealReject(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, in interaction) string {
+	admin, ok := adminUsername(ctx, config, db, in)
+	if !ok {
+		return ""
+	}
+	appealID, err := strconv.ParseInt(in.value, 10, 64)
+	if err != nil {
+		logf(ctx, "doAppealReject: %s", err)
+		return ""
+	}
+	var username string
+	var decided bool
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		username, _, decided, err = queries(tx).DecideAppeal(ctx, appealID, "rejected", admin)
+		return err
+	})
+	if err != nil {
+		logf(ctx, "doAppealReject: %s", err)
+		return fmt.Sprintf("Appeal #%d: something went wrong (ref: %s)", appealID, correlationID(ctx))
+	}
+	if !decided {
+		return fmt.Sprintf("Appeal #%d was already decided.", appealID)
+	}
+	logf(ctx, "doAppealReject: %s rejected appeal %d", admin, appealID)
+	err = dmUsername(ctx, config, ws, username, tr(config, userNamed(ctx, config, username), "appeal.rejected", "Sorry, your appeal #%d was rejected.", appealID))
+	if err != nil {
+		logf(ctx, "doAppealReject: %s", err)
+	}
+	return fmt.Sprintf("Appeal #%d rejected by %s.", appealID, admin)
+}
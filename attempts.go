@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+)
+
+// The attempts table counts every validate per team and level. Its rows are
+// locked (SELECT ... FOR UPDATE) while a guess is checked and recorded, which
+// serializes a team's guesses for a level.
+
+// lockAttempts returns the number of attempts so far and locks the counter
+// until tx ends. Counters are created from the logs the first time, so
+// attempts made before the table existed still count.
+func lockAttempts(ctx context.Context, tx *sql.Tx, teamID int, level int) (int, error) {
+	_, err := dbExec(ctx, tx, "INSERT IGNORE INTO attempts (team_id, level, count) VALUES (?, ?, (SELECT COUNT(*) FROM logs WHERE team_id=? AND level=? AND "+store.CountedAttempt+"))", teamID, level, teamID, level)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = dbQueryRow(ctx, tx, "SELECT count FROM attempts WHERE team_id=? AND level=? FOR UPDATE", teamID, level).Scan(&count)
+	return count, err
+}
+
+func incrementAttempts(ctx context.Context, tx *sql.Tx, teamID int, level int) error {
+	_, err := dbExec(ctx, tx, "UPDATE attempts SET count=count+1 WHERE team_id=? AND level=?", teamID, level)
+	return err
+}
+
+// attemptRules are a level's limits, from its challenges' max_attempts,
+// lockout_minutes, cooldown_seconds and proof_of_work.
+//
+// Tries come in rounds of max: once a round is used up, the team is locked
+// out until lockout after its last try (for good if lockout is 0), then gets
+// another round. Counting rounds rather than resetting the counter keeps the
+// attempts table a plain count of the logs.
+type attemptRules struct {
+	max      int // 0 for no limit
+	lockout  time.Duration
+	cooldown time.Duration
+	pow      int // bits, see pow.go
+}
+
+func (c Challenge) attemptRules() attemptRules {
+	return attemptRules{
+		max:      c.MaxAttempts,
+		lockout:  time.Duration(c.LockoutMinutes) * time.Minute,
+		cooldown: time.Duration(c.CooldownSeconds) * time.Second,
+		pow:      c.ProofOfWork,
+	}
+}
+
+// levelRules returns the rules of level, which its challenges agree on.
+func levelRules(level int) attemptRules {
+	for _, c := range currentChallenges() {
+		if c.Level == level {
+			return c.attemptRules()
+		}
+	}
+	return attemptRules{}
+}
+
+// unlimited is true when guesses don't need to be counted as they happen,
+// see logbuffer.go.
+func (r attemptRules) unlimited() bool {
+	return r.max == 0 && r.cooldown == 0
+}
+
+// allowed says whether a team which made count tries, the last one at last,
+// can try again at now. If not, until is when it can, zero for never.
+func (r attemptRules) allowed(count int, last time.Time, now time.Time) (ok bool, until time.Time) {
+	if r.max > 0 && count > 0 {
+		if r.lockout == 0 && count >= r.max {
+			return false, time.Time{}
+		}
+		if r.lockout > 0 && count%r.max == 0 && now.Before(last.Add(r.lockout)) {
+			return false, last.Add(r.lockout)
+		}
+	}
+	if r.cooldown > 0 && !last.IsZero() && now.Before(last.Add(r.cooldown)) {
+		return false, last.Add(r.cooldown)
+	}
+	return true, time.Time{}
+}
+
+// left returns the tries left in the current round after count tries, -1 if
+// there is no limit.
+func (r attemptRules) left(count int) int {
+	switch {
+	case r.max == 0:
+		return -1
+	case count >= r.max && r.lockout == 0:
+		return 0
+	case count > 0 && count%r.max == 0:
+		return 0
+	}
+	return r.max - count%r.max
+}
+
+// describe is how the rules are shown in "challenges", "" if there are none.
+func (r attemptRules) describe() string {
+	parts := []string{}
+	if r.max > 0 {
+		text := fmt.Sprintf("%d tries per team", r.max)
+		if r.lockout > 0 {
+			text += fmt.Sprintf(", %d more %s after the last one", r.max, formatWait(r.lockout))
+		}
+		parts = append(parts, text)
+	}
+	if r.cooldown > 0 {
+		parts = append(parts, fmt.Sprintf("%s between tries", formatWait(r.cooldown)))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Level rules: " + strings.Join(parts, ", ")
+}
+
+// lastAttempt returns when the team last tried level, zero if it never did.
+func lastAttempt(ctx context.Context, tx *sql.Tx, teamID int, level int) (time.Time, error) {
+	var ts sql.NullInt64
+	err := dbQueryRow(ctx, tx, "SELECT UNIX_TIMESTAMP(MAX(ts)) FROM logs WHERE team_id=? AND level=? AND "+store.CountedAttempt, teamID, level).Scan(&ts)
+	if err != nil || !ts.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Int64, 0), nil
+}
+
+// formatWait is formatDuration, with seconds for waits under a minute.
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int((d+time.Second-1)/time.Second))
+	}
+	return formatDuration(d)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Every message addressed to the bot is stored as received in the audit
+// table, before it's parsed. When a player disputes what they typed, or a
+// parsing bug is suspected, the raw text is there; grep the bot's log for the
+// ref to see what happened next.
+
+func auditMessage(ctx context.Context, db *sql.DB, m Message) {
+	_, err := dbExec(ctx, db, "INSERT INTO audit (channel, user, msg_ts, text, ref) VALUES (?, ?, ?, ?, ?)",
+		m.Channel, m.User, m.Timestamp, m.Text, correlationID(ctx))
+	if err != nil {
+		logf(ctx, "auditMessage: %s", err)
+	}
+}
+
+// purgeAudit runs every hour and deletes messages older than
+// config.AuditRetentionDays, if set.
+func purgeAudit(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if config.AuditRetentionDays <= 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -config.AuditRetentionDays).UTC()
+	res, err := dbExec(ctx, db, "DELETE FROM audit WHERE received < ?", cutoff)
+	if err != nil {
+		logf(ctx, "purgeAudit: %s", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		logf(ctx, "purgeAudit: deleted %d messages", n)
+	}
+}
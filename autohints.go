@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// A challenge with auto_hint (see challenges.yaml.sample) gets its first hint
+// posted in the public channel if, auto_hint.after_hours after its release
+// (or ctf_start), fewer than auto_hint.min_solves teams solved it. The check
+// happens once per challenge; the outcome is kept in bot_state
+// ("auto-hint:<id>") so it isn't repeated after a restart.
+
+type AutoHint struct {
+	AfterHours float64 `yaml:"after_hours" json:"after_hours"`
+	MinSolves  int     `yaml:"min_solves" json:"min_solves"`
+}
+
+// autoHintDue returns when the challenge's auto hint should be considered, or
+// false if it has none.
+func autoHintDue(config Config, c Challenge) (time.Time, bool) {
+	if c.AutoHint == nil || len(c.Hints) == 0 {
+		return time.Time{}, false
+	}
+	start := c.Release
+	if start.IsZero() {
+		start = config.CtfStart
+	}
+	if start.IsZero() {
+		return time.Time{}, false
+	}
+	return start.Add(time.Duration(c.AutoHint.AfterHours * float64(time.Hour))), true
+}
+
+// releaseAutoHints is a job.
+func releaseAutoHints(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	now := time.Now()
+	for _, c := range currentChallenges() {
+		due, ok := autoHintDue(config, c)
+		if !ok || now.Before(due) {
+			continue
+		}
+		key := fmt.Sprintf("auto-hint:%d", c.ID)
+		done, err := getBotState(ctx, db, key)
+		if err != nil {
+			logf(ctx, "releaseAutoHints: %s", err)
+			return
+		}
+		if done != "" {
+			continue
+		}
+		solves, err := queries(db).CountSolves(ctx, c.event())
+		if err != nil {
+			logf(ctx, "releaseAutoHints: %s", err)
+			return
+		}
+		outcome := "released"
+		if solves >= c.AutoHint.MinSolves {
+			outcome = "not needed"
+		}
+		err = setBotState(ctx, db, key, outcome)
+		if err != nil {
+			logf(ctx, "releaseAutoHints: %s", err)
+			return
+		}
+		logf(ctx, "releaseAutoHints: challenge %d has %d solves, hint %s", c.ID, solves, outcome)
+		if outcome == "released" {
+			announce(config, db, ws, fmt.Sprintf("Only %d teams solved %s so far, here is a hint: %s", solves, c.Title, c.Hints[0]))
+		}
+	}
+}
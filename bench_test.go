@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The hot paths which don't need MySQL or Slack: turning log rows into scores
+// and splitting incoming messages into commands. Run
+//
+//	go test -run - -bench .
+//
+// before and after touching either.
+
+// fixtureLogs returns the fixture of an 8 hour event with teams teams, see
+// fixtures.go.
+func fixtureLogs(teams int) []fixtureRow {
+	return generateFixture(fixtureParams{Seed: 1, Teams: teams, Levels: 8, Start: time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC), Duration: 8 * time.Hour})
+}
+
+func benchmarkScores(b *testing.B, teams int) {
+	logs := fixtureLogs(teams)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tally := newScoreTally(nil, ComboConfig{})
+		for _, l := range logs {
+			tally.add(l.TeamID, l.TeamName, l.Event, l.Ts)
+		}
+		tally.scores()
+	}
+}
+
+func BenchmarkScores100Teams(b *testing.B)  { benchmarkScores(b, 100) }
+func BenchmarkScores1000Teams(b *testing.B) { benchmarkScores(b, 1000) }
+
+func BenchmarkCommandParts(b *testing.B) {
+	botID := "U0BENCH"
+	messages := []Message{
+		{Type: "message", Channel: "D0BENCH", User: "U0PLAYER", Text: "validate 3 flag{not-the-right-one}"},
+		{Type: "message", Channel: "C0PUBLIC", User: "U0PLAYER", Text: "<@U0BENCH> scores"},
+		{Type: "message", Channel: "C0PUBLIC", User: "U0PLAYER", Text: "anyone stuck on level 2?"},
+		{Type: "message", Subtype: "channel_join", Channel: "C0PUBLIC", User: "U0PLAYER", Text: "<@U0PLAYER> has joined the channel"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		commandParts(messages[i%len(messages)], botID)
+	}
+}
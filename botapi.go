@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Companion puzzle servers can submit a flag on behalf of a team, e.g. when
+// the team pops a shell on the target, with
+//
+//	POST /api/submit
+//	Authorization: Bearer <key>
+//	{"team_id": 12, "level": 3, "flag": "flag{...}", "id": "req-42"}
+//
+// on http_listen. Keys are in config.APIKeys, by server name. A correct flag
+// is logged and announced like a DM submission, as user "api:<server>";
+// id (optional, at most 20 characters) makes retries safe. Wrong flags are
+// rejected without being logged: the server is supposed to know.
+
+type apiSubmission struct {
+	TeamID int    `json:"team_id"`
+	Level  int    `json:"level"`
+	Flag   string `json:"flag"`
+	ID     string `json:"id"`
+}
+
+type apiResult struct {
+	Result string `json:"result,omitempty"`
+	Event  string `json:"event,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Submissions are tiny.
+const maxAPIBodySize = 1 << 16
+
+// apiServer returns the name of the server whose key the request carries.
+func apiServer(keys map[string]string, r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+	for name, key := range keys {
+		if key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func writeAPIResult(w http.ResponseWriter, status int, result apiResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func apiSubmitHandler(config Config, db *sql.DB, ws *websocket.Conn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIResult(w, http.StatusMethodNotAllowed, apiResult{Error: "POST only"})
+			return
+		}
+		server, ok := apiServer(config.APIKeys, r)
+		if !ok {
+			writeAPIResult(w, http.StatusUnauthorized, apiResult{Error: "bad or missing key"})
+			return
+		}
+		var s apiSubmission
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize)).Decode(&s)
+		if err != nil || s.Flag == "" || len(s.ID) > 20 {
+			writeAPIResult(w, http.StatusBadRequest, apiResult{Error: "expected team_id, level, flag and an optional id of at most 20 characters"})
+			return
+		}
+		ctx := withCorrelationID(r.Context(), newCorrelationID())
+		status, result := apiSubmit(ctx, config, db, ws, server, s)
+		writeAPIResult(w, status, result)
+	})
+}
+
+// apiSubmit checks and records a submission, returning the HTTP status and
+// body of the response.
+func apiSubmit(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, server string, s apiSubmission) (int, apiResult) {
+	username := "api:" + server
+	logf(ctx, "apiSubmit: %s for team %d, level %d: %s", username, s.TeamID, s.Level, s.Flag)
+	now := time.Now()
+	if state := currentEventState(config, db, now); state != eventLive {
+		return http.StatusConflict, apiResult{Error: "the CTF isn't running"}
+	}
+	teamName, err := queries(db).TeamName(ctx, s.TeamID)
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, apiResult{Error: fmt.Sprintf("team %d hasn't started", s.TeamID)}
+	}
+	if err != nil {
+		logf(ctx, "apiSubmit: %s", err)
+		return http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)}
+	}
+	c, ok := matchChallenge(s.Level, normalizeFlag(s.Flag), false, now)
+	if !ok {
+		return http.StatusUnprocessableEntity, apiResult{Result: "incorrect"}
+	}
+
+	sub := submission{teamID: s.TeamID, team: teamName, level: c.Level, flag: normalizeFlag(s.Flag), who: username, msgTs: s.ID, trusted: true}
+	var r submitResult
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		r, err = submitFlag(ctx, config, tx, sub)
+		return err
+	})
+	if isDuplicateKey(err) {
+		logf(ctx, "apiSubmit: duplicate request %s", s.ID)
+		return handledResult(ctx, db, username, s.ID)
+	}
+	if err != nil {
+		logf(ctx, "apiSubmit: %s", err)
+		return http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)}
+	}
+	if r.rejection != nil {
+		return http.StatusConflict, apiResult{Event: r.event, Error: r.rejection.Error()}
+	}
+	afterSubmit(ctx, config, db, ws, sub, r, "", fmt.Sprintf("%s submitted %s for your team!", server, r.event))
+	logf(ctx, "apiSubmit: done (%s)", username)
+	return http.StatusOK, apiResult{Result: "correct", Event: r.event}
+}
+
+// handledResult answers a retry of a request which was already handled with
+// the result of the first one.
+func handledResult(ctx context.Context, db *sql.DB, username string, id string) (int, apiResult) {
+	event, err := queries(db).LoggedEvent(ctx, username, id)
+	if err != nil {
+		logf(ctx, "handledResult: %s", err)
+		return http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)}
+	}
+	if strings.HasPrefix(event, "incorrect:") {
+		return http.StatusOK, apiResult{Result: "incorrect"}
+	}
+	return http.StatusOK, apiResult{Result: "correct", Event: event}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// The bot_state table holds small values the bot needs to remember across
+// restarts, e.g. the timestamp of the message it keeps pinned.
+
+func getBotState(ctx context.Context, db *sql.DB, name string) (string, error) {
+	var value string
+	err := dbQueryRow(ctx, db, "SELECT value FROM bot_state WHERE name=?", name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func setBotState(ctx context.Context, db *sql.DB, name string, value string) error {
+	_, err := dbExec(ctx, db, "INSERT INTO bot_state (name, value) VALUES (?, ?) ON DUPLICATE KEY UPDATE value=?", name, value, value)
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+
+	"golang.org/x/net/websocket"
+)
+
+// Solve announcements are picked from config.Celebrations, a weighted pool,
+// so the public channel doesn't read "Team X found flag N!" a hundred times.
+// A celebration can come with an image (e.g. a GIF), which is posted as a
+// Block Kit image through the Web API. Without celebrations, the bot keeps
+// saying "Team X found flag N!".
+
+type Celebration struct {
+	// Go template with .Team and .Flag, e.g. ":tada: {{.Team}} got {{.Flag}}!"
+	Text string `json:"text"`
+	// Relative chance of being picked, defaults to 1.
+	Weight   int    `json:"weight"`
+	ImageURL string `json:"image_url"`
+}
+
+type celebrationData struct {
+	Team string
+	Flag string
+}
+
+// pickCelebration returns a random celebration, weighted, or false if there
+// are none. intn is usually rand.Intn.
+func pickCelebration(celebrations []Celebration, intn func(int) int) (Celebration, bool) {
+	total := 0
+	for _, c := range celebrations {
+		total += celebrationWeight(c)
+	}
+	if total == 0 {
+		return Celebration{}, false
+	}
+	n := intn(total)
+	for _, c := range celebrations {
+		n -= celebrationWeight(c)
+		if n < 0 {
+			return c, true
+		}
+	}
+	return Celebration{}, false
+}
+
+func celebrationWeight(c Celebration) int {
+	if c.Weight == 0 {
+		return 1
+	}
+	if c.Weight < 0 {
+		return 0
+	}
+	return c.Weight
+}
+
+// celebrate announces that label (see teamLabel) found event.
+func celebrate(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, label string, event string) {
+	text := renderSolve(label, event)
+	c, ok := pickCelebration(config.Celebrations, rand.Intn)
+	if !ok {
+		announce(config, db, ws, text)
+		return
+	}
+	rendered, err := renderTemplate(c.Text, celebrationData{Team: label, Flag: event})
+	if err != nil {
+		logf(ctx, "celebrate: %s", err)
+	} else {
+		text = rendered
+	}
+	if c.ImageURL == "" || !featureEnabled(db, "announcements") {
+		announce(config, db, ws, text)
+		return
+	}
+	blocks := []interface{}{
+		map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+		map[string]interface{}{"type": "image", "image_url": c.ImageURL, "alt_text": "celebration"},
+	}
+	err = postBlocks(config, getPublicChannel(), text, blocks)
+	if err != nil {
+		logf(ctx, "celebrate: %s", err)
+		announce(config, db, ws, text)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Once the event is over, the bot reveals the podium in the public channel:
+// third place, pause, second place, pause, first place. Members of the
+// podium teams also get a DM.
+//
+// There is no scoreboard freeze, so the ceremony starts
+// config.Ceremony.DelaySeconds after ctf_end, giving in-flight submissions
+// time to land.
+
+type CeremonyConfig struct {
+	Disabled     bool `json:"disabled"`
+	DelaySeconds int  `json:"delay_seconds"`
+	PauseSeconds int  `json:"pause_seconds"`
+	// Templates get .Team, .Flags and .Place.
+	Intro           string   `json:"intro"`
+	Places          []string `json:"places"` // first place first
+	Congratulations string   `json:"congratulations"`
+}
+
+var defaultCeremony = CeremonyConfig{
+	DelaySeconds: 60,
+	PauseSeconds: 20,
+	Intro:        "The CTF is over! Time to reveal the winners...",
+	Places: []string{
+		":first_place_medal: And the winner is... Team {{.Team}} with {{.Flags}} flags!",
+		":second_place_medal: In second place, Team {{.Team}} with {{.Flags}} flags!",
+		":third_place_medal: In third place, Team {{.Team}} with {{.Flags}} flags!",
+	},
+	Congratulations: "Congratulations! Your team {{.Team}} finished #{{.Place}} with {{.Flags}} flags.",
+}
+
+type ceremonyData struct {
+	Team  string
+	Flags int
+	Place int
+}
+
+func ceremonyConfig(config Config) CeremonyConfig {
+	c := config.Ceremony
+	if c.DelaySeconds == 0 {
+		c.DelaySeconds = defaultCeremony.DelaySeconds
+	}
+	if c.PauseSeconds == 0 {
+		c.PauseSeconds = defaultCeremony.PauseSeconds
+	}
+	if c.Intro == "" {
+		c.Intro = defaultCeremony.Intro
+	}
+	if len(c.Places) == 0 {
+		c.Places = defaultCeremony.Places
+	}
+	if c.Congratulations == "" {
+		c.Congratulations = defaultCeremony.Congratulations
+	}
+	return c
+}
+
+func renderCeremony(text string, data ceremonyData) (string, error) {
+	t, err := template.New("ceremony").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
+
+// runCeremony is a job. The ceremony itself blocks for a few minutes.
+func runCeremony(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	c := ceremonyConfig(config)
+	if c.Disabled || config.CtfEnd.IsZero() || time.Now().Before(config.CtfEnd.Add(time.Duration(c.DelaySeconds)*time.Second)) {
+		return
+	}
+	done, err := getBotState(ctx, db, "ceremony_done")
+	if err != nil {
+		logf(ctx, "runCeremony: %s", err)
+		return
+	}
+	if done != "" {
+		return
+	}
+	// Mark it done first, a crash halfway shouldn't replay it.
+	err = setBotState(ctx, db, "ceremony_done", time.Now().Format(time.RFC3339))
+	if err != nil {
+		logf(ctx, "runCeremony: %s", err)
+		return
+	}
+
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		logf(ctx, "runCeremony: %s", err)
+		return
+	}
+	podium := len(c.Places)
+	if len(scores) < podium {
+		podium = len(scores)
+	}
+
+	logf(ctx, "runCeremony: revealing %d places", podium)
+	announce(config, db, ws, c.Intro)
+	pause := time.Duration(c.PauseSeconds) * time.Second
+	for place := podium; place >= 1; place-- {
+		time.Sleep(pause)
+		s := scores[place-1]
+		data := ceremonyData{Team: teamLabel(config, s.teamID, s.teamName), Flags: s.numFlags(), Place: place}
+		text, err := renderCeremony(c.Places[place-1], data)
+		if err != nil {
+			logf(ctx, "runCeremony: %s", err)
+			continue
+		}
+		announce(config, db, ws, text)
+		congratulateTeam(ctx, config, db, ws, c, s.teamID, data)
+	}
+	revealAliases(ctx, config, db, ws, scores)
+}
+
+func congratulateTeam(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, c CeremonyConfig, teamID int, data ceremonyData) {
+	text, err := renderCeremony(c.Congratulations, data)
+	if err != nil {
+		logf(ctx, "congratulateTeam: %s", err)
+		return
+	}
+	notifyTeam(ctx, config, db, ws, teamID, "", "ceremony", text)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"gopkg.in/yaml.v2"
+)
+
+// Challenges are defined in challenges.yaml (see challenges.yaml.sample),
+// loaded at startup and reloaded with "admin challenges reload". A correct
+// flag for challenge N is logged as "flag N", so IDs must never be reused.
+//
+// Without a challenges file, the puzzles array in config.json is used, with
+// the same keys.
+type Challenge struct {
+	ID          int    `yaml:"id" json:"id"`
+	Level       int    `yaml:"level" json:"level"`
+	Title       string `yaml:"title" json:"title"`
+	Description string `yaml:"description" json:"description"`
+	// e.g. crypto or web, see suggest.go.
+	Category string `yaml:"category" json:"category"`
+	// The flag, other accepted flags, or the hex SHA-256 of the flag.
+	Flag     string   `yaml:"flag" json:"flag"`
+	Flags    []string `yaml:"flags" json:"flags"`
+	FlagHash string   `yaml:"flag_hash" json:"flag_hash"`
+	// How guesses are compared to them: exact, case_insensitive, trim or
+	// regex, see flagmatch.go.
+	Match    string `yaml:"match" json:"match"`
+	patterns []*regexp.Regexp
+	Points   int      `yaml:"points" json:"points"`
+	Hints    []string `yaml:"hints" json:"hints"`
+	// Points each hint costs, see hints.go. 0 uses hint_penalty.
+	HintPenalty int `yaml:"hint_penalty" json:"hint_penalty"`
+	// Tries each team gets on the challenge's level, 0 for unlimited. With
+	// lockout_minutes, a team which used them all gets max_attempts more
+	// that long after its last try; without, it's locked out for good.
+	// cooldown_seconds is the minimum time between two tries of a team.
+	// Challenges sharing a level must agree, see attempts.go.
+	MaxAttempts     int `yaml:"max_attempts" json:"max_attempts"`
+	LockoutMinutes  int `yaml:"lockout_minutes" json:"lockout_minutes"`
+	CooldownSeconds int `yaml:"cooldown_seconds" json:"cooldown_seconds"`
+	// Zero bits of the proof of work each try needs, see pow.go. Also the
+	// same for the whole level.
+	ProofOfWork int `yaml:"proof_of_work" json:"proof_of_work"`
+	// IDs of the challenges a team must solve before this one counts.
+	Requires []int `yaml:"requires" json:"requires"`
+	// Regular expression every flag of the challenge matches, e.g.
+	// flag\{[0-9a-f]{32}\}. Guesses on the level which match no format
+	// aren't counted as tries.
+	FlagFormat string `yaml:"flag_format" json:"flag_format"`
+	format     *regexp.Regexp
+	// Can't be solved (or seen) before then, zero means from the start.
+	Release time.Time `yaml:"release" json:"release"`
+	Files   []string  `yaml:"files" json:"files"`
+	// Link DMed to teams when they unlock the challenge, a template like
+	// puzzle_link. See progression.go.
+	URL string `yaml:"url" json:"url"`
+	// Posts the first hint if few teams solved it, see autohints.go.
+	AutoHint *AutoHint `yaml:"auto_hint" json:"auto_hint"`
+	// Service the challenge depends on, see servicechecks.go.
+	HealthCheck *HealthCheck `yaml:"health_check" json:"health_check"`
+	// Accepts a file checked by a command instead of a flag, see
+	// uploads.go.
+	Upload *UploadValidator `yaml:"upload" json:"upload"`
+}
+
+type challengesFile struct {
+	Challenges []Challenge `yaml:"challenges"`
+}
+
+var loadedChallenges []Challenge
+var challengesLock sync.RWMutex
+
+func challengesPath(config Config) string {
+	if config.ChallengesFile == "" {
+		return "challenges.yaml"
+	}
+	return config.ChallengesFile
+}
+
+// loadChallenges (re)reads the challenges. On error, the challenges loaded
+// before stay in place.
+func loadChallenges(config Config) error {
+	var cs []Challenge
+	data, err := readConfigFile(challengesPath(config))
+	switch {
+	case os.IsNotExist(err) && config.ChallengesFile == "":
+		cs = append([]Challenge{}, config.Puzzles...)
+	case err != nil:
+		return err
+	default:
+		var f challengesFile
+		err = yaml.UnmarshalStrict(data, &f)
+		if err != nil {
+			return fmt.Errorf("%s: %s", challengesPath(config), err)
+		}
+		cs = f.Challenges
+	}
+
+	seen := map[int]bool{}
+	for i := range cs {
+		c := &cs[i]
+		switch {
+		case c.ID < 1:
+			return fmt.Errorf("challenge %q: id must be at least 1", c.Title)
+		case seen[c.ID]:
+			return fmt.Errorf("challenge %d: duplicate id", c.ID)
+		case c.Level < 1:
+			return fmt.Errorf("challenge %d: level must be at least 1", c.ID)
+		case c.Flag == "" && len(c.Flags) == 0 && c.FlagHash == "" && c.Upload == nil:
+			return fmt.Errorf("challenge %d: needs flag, flags, flag_hash or upload", c.ID)
+		case c.Upload != nil && len(c.Upload.Command) == 0:
+			return fmt.Errorf("challenge %d: upload needs a command", c.ID)
+		case c.MaxAttempts < 0 || c.LockoutMinutes < 0 || c.CooldownSeconds < 0:
+			return fmt.Errorf("challenge %d: max_attempts, lockout_minutes and cooldown_seconds can't be negative", c.ID)
+		case c.ProofOfWork < 0 || c.ProofOfWork > maxProofOfWork:
+			return fmt.Errorf("challenge %d: proof_of_work must be between 0 and %d", c.ID, maxProofOfWork)
+		case c.LockoutMinutes > 0 && c.MaxAttempts == 0:
+			return fmt.Errorf("challenge %d: lockout_minutes needs max_attempts", c.ID)
+		case c.AutoHint != nil && len(c.Hints) == 0:
+			return fmt.Errorf("challenge %d: auto_hint needs a hint", c.ID)
+		}
+		c.FlagHash = strings.ToLower(c.FlagHash)
+		err = compileMatch(c)
+		if err != nil {
+			return err
+		}
+		if c.FlagFormat != "" {
+			expr := "^(?:" + c.FlagFormat + ")$"
+			if c.Match == matchCaseInsensitive {
+				expr = "(?i)" + expr
+			}
+			format, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("challenge %d: flag_format: %s", c.ID, err)
+			}
+			c.format = format
+			// With regex, the flags are patterns.
+			for _, f := range append([]string{c.Flag}, c.Flags...) {
+				if f != "" && c.Match != matchRegex && !c.looksLikeFlag(f) {
+					return fmt.Errorf("challenge %d: flag %q doesn't match flag_format", c.ID, f)
+				}
+			}
+		}
+		seen[c.ID] = true
+		if c.Points == 0 {
+			c.Points = 1
+		}
+		if c.Title == "" {
+			c.Title = fmt.Sprintf("Flag %d", c.ID)
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].ID < cs[j].ID })
+
+	rules := map[int]attemptRules{}
+	for _, c := range cs {
+		if r, ok := rules[c.Level]; ok && r != c.attemptRules() {
+			return fmt.Errorf("challenge %d: level %d has different max_attempts, lockout_minutes, cooldown_seconds or proof_of_work", c.ID, c.Level)
+		}
+		rules[c.Level] = c.attemptRules()
+		for _, id := range c.Requires {
+			if !seen[id] || id == c.ID {
+				return fmt.Errorf("challenge %d: requires unknown challenge %d", c.ID, id)
+			}
+		}
+	}
+
+	challengesLock.Lock()
+	defer challengesLock.Unlock()
+	loadedChallenges = cs
+	return nil
+}
+
+// currentChallenges returns all the challenges, ordered by ID. The slice must
+// not be modified.
+func currentChallenges() []Challenge {
+	challengesLock.RLock()
+	defer challengesLock.RUnlock()
+	return loadedChallenges
+}
+
+func (c Challenge) released(now time.Time) bool {
+	return c.Release.IsZero() || !now.Before(c.Release) || levelOpened(c.Level)
+}
+
+func (c Challenge) event() string {
+	return fmt.Sprintf("flag %d", c.ID)
+}
+
+// matches tells if flag solves c. upload is true for files sent with
+// "validate" (see uploads.go), whose flag is only known to the bot.
+func (c Challenge) matches(flag string, upload bool) bool {
+	if upload {
+		return c.uploadAccepted(flag)
+	}
+	return c.flagMatches(flag)
+}
+
+// missingRequirements returns the challenges c requires which aren't in
+// solved (events, e.g. "flag 3").
+func (c Challenge) missingRequirements(solved map[string]bool) []Challenge {
+	missing := []Challenge{}
+	for _, id := range c.Requires {
+		r, ok := challengeByEvent(fmt.Sprintf("flag %d", id))
+		if ok && !solved[r.event()] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// teamSolved returns the events the team solved, e.g. "flag 3".
+func teamSolved(ctx context.Context, tx sqlConn, teamID int) (map[string]bool, error) {
+	rows, err := dbQuery(ctx, tx, "SELECT event FROM scoreboard WHERE team_id=?", teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	solved := map[string]bool{}
+	for rows.Next() {
+		var event string
+		err = rows.Scan(&event)
+		if err != nil {
+			return nil, err
+		}
+		solved[event] = true
+	}
+	return solved, rows.Err()
+}
+
+// formatMatches is false if flag can't be the answer to any released
+// challenge of level, going by their flag_format. Typed flags can't pass for
+// files, which don't go through this check.
+func formatMatches(level int, flag string, now time.Time) bool {
+	if strings.HasPrefix(flag, uploadFlagPrefix) {
+		return false
+	}
+	for _, c := range currentChallenges() {
+		if c.Level == level && c.released(now) && c.looksLikeFlag(flag) {
+			return true
+		}
+	}
+	// Nothing released on the level: the guess is just wrong.
+	return !levelReleased(level, now)
+}
+
+func levelReleased(level int, now time.Time) bool {
+	for _, c := range currentChallenges() {
+		if c.Level == level && c.released(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchChallenge finds the released challenge of level which flag solves, see
+// Challenge.matches.
+func matchChallenge(level int, flag string, upload bool, now time.Time) (Challenge, bool) {
+	for _, c := range currentChallenges() {
+		if c.Level == level && c.released(now) && c.matches(flag, upload) {
+			return c, true
+		}
+	}
+	return Challenge{}, false
+}
+
+// challengeByEvent returns the challenge a "flag N" event is about.
+func challengeByEvent(event string) (Challenge, bool) {
+	for _, c := range currentChallenges() {
+		if c.event() == event {
+			return c, true
+		}
+	}
+	return Challenge{}, false
+}
+
+var errLevelTooLow = errors.New("levels are numbered from 1")
+
+// parseLevel parses a level typed by a user. It doesn't check the level
+// exists, see maxLevel.
+func parseLevel(s string) (int, error) {
+	level, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if level < 1 {
+		return 0, errLevelTooLow
+	}
+	return level, nil
+}
+
+func maxLevel() int {
+	max := 0
+	for _, c := range currentChallenges() {
+		if c.Level > max {
+			max = c.Level
+		}
+	}
+	return max
+}
+
+// releasedBetween returns the challenges released in [from, until).
+func releasedBetween(from time.Time, until time.Time) []Challenge {
+	cs := []Challenge{}
+	for _, c := range currentChallenges() {
+		if !c.Release.IsZero() && !c.Release.Before(from) && c.Release.Before(until) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func describeChallenge(c Challenge) string {
+	lines := []string{fmt.Sprintf("*%d. %s* (level %d, %d points)", c.ID, c.Title, c.Level, c.Points)}
+	if since, down := serviceDown(c.ID); down {
+		status := fmt.Sprintf(":warning: degraded: its service has been down for %s", formatDuration(time.Since(since)))
+		if c.HealthCheck.PauseAttempts {
+			status += ", wrong guesses don't use up tries meanwhile"
+		}
+		lines = append(lines, status)
+	}
+	if c.Description != "" {
+		lines = append(lines, c.Description)
+	}
+	if rules := c.attemptRules().describe(); rules != "" {
+		lines = append(lines, rules)
+	}
+	for _, f := range c.Files {
+		lines = append(lines, "• "+f)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// challenges
+func doChallenges(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	now := time.Now()
+	if currentEventState(config, db, now) == eventUpcoming {
+		postText(ws, m.Channel, fmt.Sprintf("The CTF starts in %s, come back then!", formatDuration(config.CtfStart.Sub(now))))
+		return
+	}
+	// With progression, only the levels the team unlocked, with their
+	// links in DMs.
+	teamID, teamName := 0, ""
+	solved := map[string]bool{}
+	if config.Progression {
+		u, err := resolveUser(ctx, config, m.User)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		row, err := userTeam(ctx, db, u.username)
+		var notOnTeam *NotOnTeam
+		if err != nil && !errors.As(err, &notOnTeam) {
+			reportError(ctx, config, ws, m.Channel, u, err, m.User)
+			return
+		}
+		if err == nil {
+			teamID, teamName = row.ID, row.Name
+			solved, err = teamSolved(ctx, db, teamID)
+			if err != nil {
+				postInternalError(ctx, ws, m.Channel, err, m.User)
+				return
+			}
+		}
+	}
+	parts := []string{}
+	for _, c := range currentChallenges() {
+		switch {
+		case !c.released(now) || !levelUnlocked(config, c.Level, solved):
+		case teamID != 0 && isPrivate(m.Channel):
+			parts = append(parts, describeChallengeFor(ctx, config, c, teamID, teamName))
+		default:
+			parts = append(parts, describeChallenge(c))
+		}
+	}
+	if len(parts) == 0 {
+		postText(ws, m.Channel, "No challenges have been released yet.")
+		return
+	}
+	postText(ws, m.Channel, strings.Join(parts, "\n\n"))
+}
+
+// admin challenges [reload]
+func doAdminChallenges(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if len(args) == 1 && args[0] == "reload" {
+		err := loadChallenges(config)
+		if err != nil {
+			logf(ctx, "doAdminChallenges: %s", err)
+			postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, I kept the old challenges: %s", err), m.User)
+			return
+		}
+		logf(ctx, "doAdminChallenges: %s reloaded the challenges", m.User)
+		postText(ws, m.Channel, fmt.Sprintf("Loaded %d challenges.", len(currentChallenges())))
+		return
+	}
+	if len(args) != 0 {
+		postError(ctx, ws, m.Channel, "usage: admin challenges [reload]", m.User)
+		return
+	}
+
+	lines := []string{}
+	for _, c := range currentChallenges() {
+		release := "released"
+		if !c.released(time.Now()) {
+			release = "releases " + c.Release.Format(time.RFC3339)
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s (level %d, %d points, %d hints, %s)", c.ID, c.Title, c.Level, c.Points, len(c.Hints), release))
+	}
+	if len(lines) == 0 {
+		postText(ws, m.Channel, "No challenges are defined.")
+		return
+	}
+	postText(ws, m.Channel, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// publicChannel is the ID of config.PublicChannel, resolved at startup and
+// again when a channel is renamed.
+var publicChannel string
+var publicChannelLock sync.RWMutex
+
+func getPublicChannel() string {
+	publicChannelLock.RLock()
+	defer publicChannelLock.RUnlock()
+	return publicChannel
+}
+
+func setPublicChannel(id string) {
+	publicChannelLock.Lock()
+	defer publicChannelLock.Unlock()
+	publicChannel = id
+}
+
+var channelIDPattern = regexp.MustCompile(`^[CG][A-Z0-9]{8,}$`)
+
+func isChannelID(s string) bool {
+	return channelIDPattern.MatchString(s)
+}
+
+// channelIDCache maps channel names to IDs. Listing every channel takes many
+// requests in a large workspace, so it's only done once, and again after a
+// rename.
+var channelIDCache map[string]string
+var channelIDCacheLock sync.Mutex
+
+func channelIDs(config Config) (map[string]string, error) {
+	channelIDCacheLock.Lock()
+	defer channelIDCacheLock.Unlock()
+	if channelIDCache != nil {
+		return channelIDCache, nil
+	}
+
+	api := newSlackClient(config)
+	ids := map[string]string{}
+	params := &slack.GetConversationsParameters{
+		Types:           []string{"public_channel", "private_channel"},
+		ExcludeArchived: true,
+		Limit:           1000,
+	}
+	for {
+		channels, cursor, err := api.GetConversationsContext(context.Background(), params)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			ids[channel.Name] = channel.ID
+		}
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	channelIDCache = ids
+	return ids, nil
+}
+
+func forgetChannelIDs() {
+	channelIDCacheLock.Lock()
+	defer channelIDCacheLock.Unlock()
+	channelIDCache = nil
+}
+
+// channelRenamed re-resolves public_channel by name. If the public channel
+// itself was renamed, its ID is still valid and is kept; if another channel
+// now has the configured name, the bot switches to it.
+func channelRenamed(config Config, id string, name string) {
+	if isChannelID(config.PublicChannel) {
+		return
+	}
+	current := getPublicChannel()
+	if id == current && name != config.PublicChannel {
+		log.Printf("channelRenamed: public channel %s is now called %s, update public_channel", id, name)
+		return
+	}
+	if name != config.PublicChannel {
+		return
+	}
+	forgetChannelIDs()
+	resolved, err := resolveChannel(config)
+	if err != nil {
+		log.Printf("channelRenamed: %s", err)
+		return
+	}
+	if resolved != current {
+		log.Printf("channelRenamed: public channel is now %s (was %s)", resolved, current)
+		setPublicChannel(resolved)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// With config.CreateChannels, the bot creates public_channel if it doesn't
+// exist yet, sets its topic to the event's schedule (or channel_topic), and
+// invites the admins (users with the admin role). It also creates
+// admin_channel, a private channel for the organizers, if set. Existing
+// channels are left alone. Needs the channels:manage and groups:write scopes.
+
+type responseConversationCreate struct {
+	Channel struct {
+		Id string `json:"id"`
+	} `json:"channel"`
+}
+
+func createChannel(ctx context.Context, config Config, name string, private bool) (string, error) {
+	var resp responseConversationCreate
+	err := traceSlack(ctx, "conversations.create", func() error {
+		return callSlackAPI(config.SlackApiToken, "conversations.create", url.Values{"name": {name}, "is_private": {fmt.Sprint(private)}}, &resp)
+	})
+	return resp.Channel.Id, err
+}
+
+func setChannelTopic(ctx context.Context, config Config, channel string, topic string) error {
+	return traceSlack(ctx, "conversations.setTopic", func() error {
+		return callSlackAPI(config.SlackApiToken, "conversations.setTopic", url.Values{"channel": {channel}, "topic": {topic}}, nil)
+	})
+}
+
+// initialTopic is channel_topic, or when the event runs and how to start.
+func initialTopic(config Config) string {
+	if config.ChannelTopic != "" {
+		return config.ChannelTopic
+	}
+	topic := fmt.Sprintf("DM @%s \"help\" to get started", config.BotName)
+	if !config.CtfStart.IsZero() && !config.CtfEnd.IsZero() {
+		start, end := config.CtfStart.UTC(), config.CtfEnd.UTC()
+		endFormat := "15:04"
+		if start.YearDay() != end.YearDay() || end.Sub(start) >= 24*time.Hour {
+			endFormat = "Jan 2 15:04"
+		}
+		topic = fmt.Sprintf("CTF %s – %s UTC • %s", start.Format("Jan 2 15:04"), end.Format(endFormat), topic)
+	}
+	return topic
+}
+
+// inviteAdmins invites the admins (see adminUsernames) to channel.
+func inviteAdmins(ctx context.Context, config Config, db *sql.DB, channel string) error {
+	usernames, err := adminUsernames(ctx, config, db)
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for _, username := range usernames {
+		id, err := resolveUsername(ctx, config, username)
+		if err != nil {
+			logf(ctx, "inviteAdmins: %s", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return traceSlack(ctx, "conversations.invite", func() error {
+		return callSlackAPI(config.SlackApiToken, "conversations.invite", url.Values{"channel": {channel}, "users": {strings.Join(ids, ",")}}, nil)
+	})
+}
+
+// ensureChannels runs at startup, before public_channel is resolved.
+func ensureChannels(ctx context.Context, config Config, db *sql.DB) error {
+	if !config.CreateChannels {
+		return nil
+	}
+	ids, err := channelIDs(config)
+	if err != nil {
+		return err
+	}
+	created := false
+	if _, ok := ids[config.PublicChannel]; !ok && !isChannelID(config.PublicChannel) {
+		id, err := createChannel(ctx, config, config.PublicChannel, false)
+		if err != nil {
+			return fmt.Errorf("creating %s: %s", config.PublicChannel, err)
+		}
+		logf(ctx, "ensureChannels: created %s (%s)", config.PublicChannel, id)
+		created = true
+		err = setChannelTopic(ctx, config, id, initialTopic(config))
+		if err != nil {
+			logf(ctx, "ensureChannels: %s", err)
+		}
+		err = inviteAdmins(ctx, config, db, id)
+		if err != nil {
+			logf(ctx, "ensureChannels: %s", err)
+		}
+	}
+	if _, ok := ids[config.AdminChannel]; !ok && config.AdminChannel != "" {
+		id, err := createChannel(ctx, config, config.AdminChannel, true)
+		if err != nil {
+			return fmt.Errorf("creating %s: %s", config.AdminChannel, err)
+		}
+		logf(ctx, "ensureChannels: created %s (%s)", config.AdminChannel, id)
+		created = true
+		err = inviteAdmins(ctx, config, db, id)
+		if err != nil {
+			logf(ctx, "ensureChannels: %s", err)
+		}
+	}
+	if created {
+		forgetChannelIDs()
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+/**
+ * Load test for amigo_bot.
+ *
+ * Pretends to be Slack: serves the handful of Web API methods the bot uses
+ * and the RTM websocket, then simulates one player per team sending start,
+ * validate and scores. Reports throughput and latency (time until the bot's
+ * first reply) per command.
+ *
+ * Usage:
+ *   go run ./cmd/loadtest -mysql 'root@/amigo_test' -teams 200 -duration 1m
+ * then start the bot with "slack_api_url": "http://localhost:8085/api/" and
+ * the same (throwaway!) database. -mysql seeds the users table.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/net/websocket"
+)
+
+const botID = "ULOADBOT"
+
+type message struct {
+	Id        int    `json:"id"`
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	Timestamp string `json:"ts"`
+	Channel   string `json:"channel"`
+	User      string `json:"user"`
+	Text      string `json:"text"`
+}
+
+// fakeSlack routes the bot's replies to the simulated player waiting on that
+// DM channel.
+type fakeSlack struct {
+	addr    string
+	channel string
+	lock    sync.Mutex
+	ws      *websocket.Conn
+	ready   chan struct{}
+	waiting map[string]chan struct{}
+	tsSeq   int64
+}
+
+func playerID(i int) string      { return fmt.Sprintf("ULOAD%04d", i) }
+func playerName(i int) string    { return fmt.Sprintf("loadtest-%04d", i) }
+func playerChannel(i int) string { return "D" + playerID(i)[1:] }
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeSlack) api(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	switch method {
+	case "rtm.start":
+		writeJSON(w, map[string]interface{}{"ok": true, "url": "ws://" + f.addr + "/ws", "self": map[string]string{"id": botID}})
+	case "users.info":
+		var i int
+		fmt.Sscanf(r.Form.Get("user"), "ULOAD%d", &i)
+		writeJSON(w, map[string]interface{}{"ok": true, "user": map[string]string{"id": r.Form.Get("user"), "name": playerName(i)}})
+	case "conversations.open":
+		writeJSON(w, map[string]interface{}{"ok": true, "channel": map[string]string{"id": "D" + r.Form.Get("users")[1:]}})
+	case "conversations.list":
+		list := []map[string]string{{"id": "CLOADPUBLIC", "name": f.channel}}
+		writeJSON(w, map[string]interface{}{"ok": true, "channels": list})
+	case "chat.postMessage":
+		writeJSON(w, map[string]interface{}{"ok": true, "channel": r.Form.Get("channel"), "ts": f.nextTs()})
+	default:
+		writeJSON(w, map[string]interface{}{"ok": true})
+	}
+}
+
+func (f *fakeSlack) nextTs() string {
+	n := atomic.AddInt64(&f.tsSeq, 1)
+	return fmt.Sprintf("%d.%06d", time.Now().Unix(), n%1000000)
+}
+
+func (f *fakeSlack) rtm(ws *websocket.Conn) {
+	f.lock.Lock()
+	f.ws = ws
+	f.lock.Unlock()
+	close(f.ready)
+	log.Printf("bot connected")
+
+	for {
+		var m message
+		err := websocket.JSON.Receive(ws, &m)
+		if err != nil {
+			log.Fatalf("bot disconnected: %s", err)
+		}
+		f.lock.Lock()
+		c, ok := f.waiting[m.Channel]
+		if ok {
+			delete(f.waiting, m.Channel)
+		}
+		f.lock.Unlock()
+		if ok {
+			close(c)
+		}
+	}
+}
+
+// send delivers text from player i and waits for the first reply.
+func (f *fakeSlack) send(i int, text string, timeout time.Duration) (time.Duration, error) {
+	done := make(chan struct{})
+	f.lock.Lock()
+	f.waiting[playerChannel(i)] = done
+	m := message{Type: "message", Channel: playerChannel(i), User: playerID(i), Text: text, Timestamp: f.nextTs()}
+	start := time.Now()
+	err := websocket.JSON.Send(f.ws, m)
+	f.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case <-done:
+		return time.Since(start), nil
+	case <-time.After(timeout):
+		f.lock.Lock()
+		delete(f.waiting, playerChannel(i))
+		f.lock.Unlock()
+		return 0, fmt.Errorf("no reply to %q after %s", text, timeout)
+	}
+}
+
+type results struct {
+	lock      sync.Mutex
+	latencies map[string][]time.Duration
+	errors    int
+}
+
+func (r *results) record(command string, d time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err != nil {
+		r.errors++
+		return
+	}
+	r.latencies[command] = append(r.latencies[command], d)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func seed(dsn string, teams int, firstTeam int) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Fatalf("sql.Open: %s", err)
+	}
+	defer db.Close()
+	for i := 0; i < teams; i++ {
+		_, err = db.Exec("INSERT INTO users SET user=?, team=? ON DUPLICATE KEY UPDATE team=?", playerName(i), firstTeam+i, firstTeam+i)
+		if err != nil {
+			log.Fatalf("seeding users: %s", err)
+		}
+	}
+	log.Printf("seeded %d users", teams)
+}
+
+func player(f *fakeSlack, r *results, i int, deadline time.Time, timeout time.Duration) {
+	d, err := f.send(i, fmt.Sprintf("start loadtest team %d", i), timeout)
+	r.record("start", d, err)
+	for time.Now().Before(deadline) {
+		var command, text string
+		switch n := rand.Intn(20); {
+		case n == 0:
+			command, text = "scores", "scores"
+		default:
+			// Levels 1 and 3 have no attempt limit.
+			level := []int{1, 3}[rand.Intn(2)]
+			command, text = "validate", fmt.Sprintf("validate %d guess-%d-%d", level, i, rand.Int63())
+		}
+		d, err = f.send(i, text, timeout)
+		r.record(command, d, err)
+	}
+}
+
+func main() {
+	listen := flag.String("listen", "localhost:8085", "address of the fake Slack")
+	channel := flag.String("public-channel", "ctf-test", "the bot's public_channel")
+	teams := flag.Int("teams", 50, "number of simulated teams (one player each)")
+	duration := flag.Duration("duration", time.Minute, "how long to send traffic for")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for a reply")
+	dsn := flag.String("mysql", "", "if set, seed the users table of this (test!) database")
+	firstTeam := flag.Int("first-team", 1, "team ID of the first simulated team")
+	flag.Parse()
+
+	if *dsn != "" {
+		seed(*dsn, *teams, *firstTeam)
+	}
+
+	f := &fakeSlack{addr: *listen, channel: *channel, ready: make(chan struct{}), waiting: map[string]chan struct{}{}}
+	http.HandleFunc("/api/", f.api)
+	http.Handle("/ws", websocket.Handler(f.rtm))
+	go func() {
+		log.Fatal(http.ListenAndServe(*listen, nil))
+	}()
+	log.Printf("waiting for the bot, start it with \"slack_api_url\": \"http://%s/api/\"", *listen)
+	<-f.ready
+
+	r := &results{latencies: map[string][]time.Duration{}}
+	start := time.Now()
+	deadline := start.Add(*duration)
+	var wg sync.WaitGroup
+	for i := 0; i < *teams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			player(f, r, i, deadline, *timeout)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := 0
+	commands := []string{}
+	for command, l := range r.latencies {
+		commands = append(commands, command)
+		total += len(l)
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	}
+	sort.Strings(commands)
+	fmt.Printf("%d requests in %s: %.1f req/s, %d errors\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds(), r.errors)
+	fmt.Printf("%-10s %8s %10s %10s %10s\n", "command", "count", "p50", "p99", "max")
+	for _, command := range commands {
+		l := r.latencies[command]
+		fmt.Printf("%-10s %8d %10s %10s %10s\n", command, len(l), percentile(l, 0.5), percentile(l, 0.99), l[len(l)-1])
+	}
+}
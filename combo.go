@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// A combo is a run of solves of different levels, each within
+// combo.window_minutes of the previous one. Every new level after the first
+// one of the run is worth combo.bonus extra points, and is announced. Combos
+// are worked out from the scoreboard's timestamps (see scoreTally), so
+// rebuilding the projections recomputes them.
+
+type ComboConfig struct {
+	WindowMinutes int `json:"window_minutes"` // 0 disables combos
+	Bonus         int `json:"bonus"`
+}
+
+func (c ComboConfig) enabled() bool {
+	return c.WindowMinutes > 0 && c.Bonus != 0
+}
+
+func (c ComboConfig) window() time.Duration {
+	return time.Duration(c.WindowMinutes) * time.Minute
+}
+
+// comboState follows one team's solves, in the order they happened.
+type comboState struct {
+	levels map[int]bool
+	last   time.Time
+}
+
+// add records a solve and returns the length of the combo (in levels) it
+// extends, or 0 if it doesn't extend one.
+func (s *comboState) add(level int, at time.Time, window time.Duration) int {
+	if s.levels == nil || at.Sub(s.last) > window {
+		s.levels = map[int]bool{}
+	}
+	s.last = at
+	if s.levels[level] {
+		return 0
+	}
+	s.levels[level] = true
+	if len(s.levels) < 2 {
+		return 0
+	}
+	return len(s.levels)
+}
+
+// announceCombo is called after a team found event, and announces it if it
+// extended a combo.
+func announceCombo(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, teamID int, teamName string, event string) {
+	if !config.Combo.enabled() {
+		return
+	}
+	rows, err := dbQuery(ctx, db, "SELECT event, UNIX_TIMESTAMP(ts) FROM scoreboard WHERE team_id=? ORDER BY ts, event", teamID)
+	if err != nil {
+		logf(ctx, "announceCombo: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	state := comboState{}
+	combo := 0
+	for rows.Next() {
+		var e string
+		var ts int64
+		err = rows.Scan(&e, &ts)
+		if err != nil {
+			logf(ctx, "announceCombo: %s", err)
+			return
+		}
+		c, ok := challengeByEvent(e)
+		if !ok {
+			continue
+		}
+		n := state.add(c.Level, time.Unix(ts, 0), config.Combo.window())
+		if e == event {
+			combo = n
+		}
+	}
+	if combo == 0 {
+		return
+	}
+	logf(ctx, "announceCombo: team %d, %d levels", teamID, combo)
+	announce(config, db, ws, fmt.Sprintf("Combo! Team %s solved %d levels in a row (+%d points)", publicTeamLabel(config, teamID, teamName, time.Now()), combo, config.Combo.Bonus))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/websocket"
+)
+
+type commandHandler func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string)
+
+// A command is what a user types after mentioning the bot (or directly in a
+// DM). minArgs is the minimum number of words following the command name.
+type command struct {
+	name       string
+	minArgs    int
+	permission permission
+	handler    commandHandler
+}
+
+var commands = []command{
+	{"help", 0, permNone, func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+		doHelp(ctx, config, ws, m.User, m.Channel)
+	}},
+	{"start", 0, permPlay, func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+		doStart(ctx, config, db, ws, m.User, m.Channel, m.Timestamp, strings.Join(args, " "))
+	}},
+	{"validate", 1, permPlay, func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+		switch {
+		case len(m.Files) > 0:
+			doValidateUpload(ctx, config, db, ws, m, args)
+		case len(args) < 2:
+			u, _ := resolveUser(ctx, config, m.User) // English if it fails
+			postError(ctx, ws, m.Channel, tr(config, u, "validate.usage", "send `validate <level> <flag>`, or attach a file to `validate <level>`."), m.User)
+		default:
+			doValidate(ctx, config, db, ws, m.User, m.Channel, m.Timestamp, args[0], normalizeFlag(strings.Join(args[1:], " ")), false)
+		}
+	}},
+	{"scores", 0, permViewScores, func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+		doTopScores(ctx, config, db, ws, m.User, m.Channel)
+	}},
+	{"challenges", 0, permPlay, doChallenges},
+	{"notify", 0, permNone, doNotify},
+	{"observe", 0, permNone, doObserve},
+	{"plain", 0, permNone, doPlain},
+	{"mydata", 0, permNone, doMyData},
+	{"judge", 1, permJudge, doJudge},
+	{"appeal", 2, permPlay, doAppeal},
+	{"taunt", 1, permPlay, doTaunt},
+	{"hint", 1, permPlay, doHint},
+	{"pow", 1, permPlay, doPoW},
+	{"suggest", 0, permPlay, doSuggest},
+	{"token", 1, permManageTeam, doToken},
+	{"register", 1, permPlay, doRegister},
+	{"invite", 1, permPlay, doInvite},
+	{"join", 1, permPlay, doJoin},
+	{"admin", 1, permAdmin, doAdmin},
+}
+
+// dispatch finds the command in parts[0], checks the caller is allowed to run
+// it and runs it. parts must not include the bot mention.
+func dispatch(config Config, db *sql.DB, ws *websocket.Conn, m Message, parts []string) {
+	ctx := withCorrelationID(context.Background(), newCorrelationID())
+	auditMessage(ctx, db, m)
+	if continueSetup(ctx, config, db, ws, m) {
+		return
+	}
+	if !runCommand(ctx, commands, config, db, ws, m, parts) && !doEasterEgg(ctx, config, db, ws, m, parts) {
+		u, _ := resolveUser(ctx, config, m.User) // English if it fails
+		postError(ctx, ws, m.Channel, tr(config, u, "error.unknown-command", "sorry, I didn't understand that."), m.User)
+	}
+}
+
+// runCommand looks up parts[0] in cmds and runs it. Returns false if no
+// command matched.
+func runCommand(ctx context.Context, cmds []command, config Config, db *sql.DB, ws *websocket.Conn, m Message, parts []string) bool {
+	if len(parts) == 0 {
+		return false
+	}
+	for _, c := range cmds {
+		if parts[0] != c.name || len(parts)-1 < c.minArgs {
+			continue
+		}
+		ctx, span := tracer().Start(ctx, "command."+c.name, trace.WithAttributes(attribute.String("correlation_id", correlationID(ctx))))
+		start := time.Now()
+		if authorized(ctx, config, db, ws, m, c.permission) {
+			c.handler(ctx, config, db, ws, m, parts[1:])
+		}
+		span.End()
+		logf(ctx, "command %s took %s", c.name, time.Since(start))
+		return true
+	}
+	return false
+}
+
+// authorized checks the permission for the user who sent m, replying with an
+// error if the user isn't allowed.
+func authorized(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, perm permission) bool {
+	if perm == permNone || configAdmin(config, m.User) {
+		return true
+	}
+	// Not being able to DM the user doesn't matter here, the handler deals
+	// with it.
+	u, err := resolveUser(ctx, config, m.User)
+	var noDM *DMUnavailable
+	if err != nil && !errors.As(err, &noDM) {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return false
+	}
+	ok, err := hasPermission(ctx, db, u.username, perm)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return false
+	}
+	if !ok {
+		postError(ctx, ws, m.Channel, tr(config, u, "error.not-allowed", "sorry, you are not allowed to do that."), m.User)
+		return false
+	}
+	return true
+}
@@ -2,36 +2,213 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
 )
 
 type Config struct {
 	BotName       string `json:"bot_name"`
 	SlackApiToken string `json:"slack_api_token"`
+	SigningSecret string `json:"slack_signing_secret"`
+	// App-level token (xapp-...): use Socket Mode instead of RTM, see
+	// socketmode.go.
+	SlackAppToken string `json:"slack_app_token"`
 	MysqlConn     string `json:"mysql_conn_string"`
 	PuzzleLink    string `json:"puzzle_link"`
 	PublicChannel string `json:"public_channel"`
-	Flag1         string `json:"flag1"`
-	Flag2         string `json:"flag2"`
-	Flag3         string `json:"flag3"`
-	Flag4         string `json:"flag4"`
-	Flag5         string `json:"flag5"`
-	Flag6         string `json:"flag6"`
-	Flag7         string `json:"flag7"`
-	Flag8         string `json:"flag8"`
+
+	// Key for .TeamToken in puzzle_link, see puzzlelink.go.
+	PuzzleLinkSecret string `json:"puzzle_link_secret"`
+
+	// Create public_channel and admin_channel if they don't exist, with
+	// channel_topic (or the schedule) as the public channel's topic. See
+	// channelsetup.go.
+	CreateChannels bool   `json:"create_channels"`
+	AdminChannel   string `json:"admin_channel"`
+	ChannelTopic   string `json:"channel_topic"`
+	// Keep the public channel's topic up to date, changing it at most this
+	// often, see topic.go. 0 leaves the topic alone.
+	TopicRefreshMinutes int `json:"topic_refresh_minutes"`
+
+	// Slack user IDs (e.g. U024BE7LH) who are admins on top of the roles
+	// table, see roles.go.
+	AdminUserIDs []string `json:"admin_user_ids"`
+
+	// Defaults to challenges.yaml, see challenges.go.
+	ChallengesFile string `json:"challenges_file"`
+	// Used when there is no challenges file.
+	Puzzles []Challenge `json:"puzzles"`
+	// Teams only see a level once they solved one of the level before, see
+	// progression.go.
+	Progression bool `json:"progression"`
+	// Points a hint costs unless its challenge says otherwise, see hints.go.
+	HintPenalty int `json:"hint_penalty"`
+
+	// Lets several events share a database, see internal/store/prefix.go.
+	TablePrefix string `json:"table_prefix"`
+
+	// "mysql" (the default), "postgres" or "sqlite", see
+	// internal/store/dialect.go. The mysql_* settings below apply to all
+	// three, mysql_conn_string being the driver's data source name.
+	DatabaseDriver string `json:"database_driver"`
+
+	// Optional read-only replica for scores and exports, see readDB.
+	MysqlReplicaConn string `json:"mysql_replica_conn_string"`
+	// Connection pool, see db.go. 0 keeps database/sql's default.
+	MysqlMaxOpenConns           int `json:"mysql_max_open_conns"`
+	MysqlMaxIdleConns           int `json:"mysql_max_idle_conns"`
+	MysqlConnMaxLifetimeSeconds int `json:"mysql_conn_max_lifetime_seconds"`
+
+	// Defaults to https://slack.com/api/
+	SlackApiURL string `json:"slack_api_url"`
+
+	// Tracing, see tracing.go
+	OtelEndpoint string `json:"otel_endpoint"`
+	OtelInsecure bool   `json:"otel_insecure"`
+
+	// Event window (RFC 3339), either can be omitted. See event.go.
+	CtfStart time.Time `json:"ctf_start"`
+	CtfEnd   time.Time `json:"ctf_end"`
+	// Optional user token for the bot's account, used to set its status.
+	StatusToken string `json:"status_token"`
+
+	// When > 0, solves are announced as a digest every that many minutes.
+	AnnouncementDigestMinutes int `json:"announcement_digest_minutes"`
+	// Throttles rapid-fire guesses per team and level, see ratelimit.go.
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	// Minimum time between two "takes the lead" announcements, default 5.
+	LeadChangeThrottleMinutes int `json:"lead_change_throttle_minutes"`
+	// Per-level solve counts in the public channel: "", "post" or "pin", see
+	// ticker.go.
+	SolveTicker string `json:"solve_ticker"`
+	// No proactive DMs or digests during these hours, see quiet.go.
+	QuietHours QuietHoursConfig `json:"quiet_hours"`
+	// Morning summary of the previous day, see daily.go.
+	DailySummary DailySummaryConfig `json:"daily_summary"`
+	// Secret phrase to response and bonus points, see eastereggs.go.
+	EasterEggs map[string]EasterEgg `json:"easter_eggs"`
+
+	// Pre-approved taunts, see taunt.go. Empty disables the taunt command.
+	Taunts               []string `json:"taunts"`
+	TauntCooldownMinutes int      `json:"taunt_cooldown_minutes"`
+
+	// Weighted pool of solve announcements, see celebrations.go.
+	Celebrations []Celebration `json:"celebrations"`
+	// Winner announcement after ctf_end, see ceremony.go.
+	Ceremony CeremonyConfig `json:"ceremony"`
+
+	// Bonus points for solving several levels in quick succession, see
+	// combo.go.
+	Combo ComboConfig `json:"combo"`
+
+	// Create a Slack user group per team on start, see usergroups.go.
+	TeamUserGroups bool `json:"team_user_groups"`
+
+	// Let players form teams with register, invite and join, see
+	// registration.go.
+	Registration RegistrationConfig `json:"registration"`
+
+	// Raw messages in the audit table are deleted after this many days, 0
+	// keeps them forever. See audit.go.
+	AuditRetentionDays int `json:"audit_retention_days"`
+	// Personal data is deleted this many days after ctf_end, 0 keeps it. See
+	// privacy.go.
+	RetentionDays int `json:"retention_days"`
+
+	// Address (e.g. ":8080") to serve Slack interactivity (buttons) on, see
+	// interactivity.go. Empty disables it.
+	HTTPListen string `json:"http_listen"`
+	// Also receive messages through the Events API on http_listen, see
+	// events.go.
+	EventsAPI bool `json:"events_api"`
+	// Puzzle servers which can submit flags for teams on http_listen, name
+	// to key. See botapi.go.
+	APIKeys map[string]string `json:"api_keys"`
+	// Captains can create API tokens for their team's tools, see teamapi.go.
+	TeamTokens bool `json:"team_tokens"`
+
+	// Restart if nothing was read from Slack for this many minutes while
+	// Slack was active, see watchdog.go. 0 disables it.
+	WatchdogMinutes int `json:"watchdog_minutes"`
+	// The websocket is considered dead after this many seconds without a
+	// frame; pings keep it busy in between. See keepalive.go. 0 disables it.
+	WebsocketTimeoutSeconds int `json:"websocket_timeout_seconds"`
+
+	// Keep this many raw Slack frames in memory for "admin debug dump", see
+	// traffic.go. 0 disables it.
+	DebugTrafficFrames int `json:"debug_traffic_frames"`
+
+	// Fake teams solving challenges on a timer, for demos before launch. See
+	// demo.go.
+	Demo DemoConfig `json:"demo"`
+
+	// Write incorrect guesses in batches, see logbuffer.go.
+	BatchIncorrectGuesses bool `json:"batch_incorrect_guesses"`
+	// Correct-looking flags are kept in this file while MySQL is down, see
+	// pending.go. Empty turns that off.
+	PendingFile string `json:"pending_file"`
+
+	// professional, playful (default) or pirate, see personality.go.
+	Personality string `json:"personality"`
+	// Reply language, see i18n.go. Defaults to locales and English.
+	LocalesDir      string `json:"locales_dir"`
+	DefaultLanguage string `json:"default_language"`
+
+	// Hide team names on the scoreboard until ctf_end, see aliases.go.
+	ScoreboardAliases   bool `json:"scoreboard_aliases"`
+	ScoreboardAliasSeed int  `json:"scoreboard_alias_seed"`
+
+	// compact, emoji, table or blocks
+	ScoreboardStyle string `json:"scoreboard_style"`
+	// Shown next to the first len(RankDecorations) teams on the scoreboard.
+	RankDecorations []string `json:"rank_decorations"`
+	// Team ID to emoji, shown next to the team's name everywhere.
+	TeamBadges map[int]string `json:"team_badges"`
 }
 
 func configRead() Config {
-	config_file, err := os.Open("config.json")
+	data, err := readConfigFile("config.json")
 	if err != nil {
-		log.Panicf("failed to open config.json: %s\n", err)
+		log.Panicf("failed to read config.json: %s\n", err)
 	}
-	decoder := json.NewDecoder(config_file)
 	config := Config{}
-	err = decoder.Decode(&config)
+	err = json.Unmarshal(data, &config)
 	if err != nil {
 		log.Panicf("json decoding failed: %s\n", err)
 	}
 	return config
 }
+
+// configKeyEnv holds an age identity (AGE-SECRET-KEY-1...). When it's set,
+// readConfigFile reads path + ".age" and decrypts it, so that config.json
+// (tokens) and challenges.yaml (flags) aren't stored in the clear:
+//
+//	age -r age1... -o config.json.age config.json
+const configKeyEnv = "AMIGO_CONFIG_KEY"
+
+func readConfigFile(path string) ([]byte, error) {
+	key := os.Getenv(configKeyEnv)
+	if key == "" {
+		return ioutil.ReadFile(path)
+	}
+	identities, err := age.ParseIdentities(strings.NewReader(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", configKeyEnv, err)
+	}
+	f, err := os.Open(path + ".age")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := age.Decrypt(f, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("%s.age: %s", path, err)
+	}
+	return ioutil.ReadAll(r)
+}
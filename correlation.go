@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// Each inbound message gets a short correlation ID. It prefixes every log
+// line, is stored with the logs rows written while handling the message and
+// is shown to the user when something goes wrong, so an admin can grep for
+// exactly what happened when someone complains.
+
+type correlationKey struct{}
+
+func newCorrelationID() string {
+	b := make([]byte, 3)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Printf("rand.Read: %s", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// correlationID returns "" for work which didn't start from a message.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// logf is log.Printf with the correlation ID prepended.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	id := correlationID(ctx)
+	if id == "" {
+		log.Printf(format, args...)
+		return
+	}
+	log.Printf("[%s] %s", id, fmt.Sprintf(format, args...))
+}
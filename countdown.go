@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The countdown is a message pinned in the public channel which the bot edits
+// every minute with the time left and the current leader. Its timestamp is
+// kept in bot_state so restarts keep editing the same message. The solve
+// ticker (ticker.go) can keep one too.
+
+var lastCountdown string
+var lastCountdownLock sync.Mutex
+
+type responsePostMessage struct {
+	Channel   string `json:"channel"`
+	Timestamp string `json:"ts"`
+}
+
+func countdownText(ctx context.Context, config Config, db *sql.DB, now time.Time) (string, error) {
+	status, emoji, _ := eventStatus(config, db, now)
+	text := fmt.Sprintf("%s %s", emoji, status)
+
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		return "", err
+	}
+	if len(scores) > 0 && scores[0].numFlags() > 0 {
+		leader := scores[0]
+		text += fmt.Sprintf("\nLeader: Team %s (%d flags)", publicTeamLabel(config, leader.teamID, leader.teamName, now), leader.numFlags())
+	}
+	return text, nil
+}
+
+func updateCountdown(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	publicChannel := getPublicChannel()
+	if !featureEnabled(db, "countdown") || publicChannel == "" {
+		return
+	}
+	text, err := countdownText(ctx, config, db, time.Now())
+	if err != nil {
+		logf(ctx, "updateCountdown: %s", err)
+		return
+	}
+
+	lastCountdownLock.Lock()
+	defer lastCountdownLock.Unlock()
+	if text == lastCountdown {
+		return
+	}
+
+	if updatePinned(ctx, config, db, "countdown_ts", text) {
+		lastCountdown = text
+	}
+}
+
+// updatePinned edits the pinned message whose timestamp is in bot_state
+// under key, or posts and pins a new one. It returns false if text couldn't
+// be posted.
+func updatePinned(ctx context.Context, config Config, db *sql.DB, key string, text string) bool {
+	publicChannel := getPublicChannel()
+	ts, err := getBotState(ctx, db, key)
+	if err != nil {
+		logf(ctx, "updatePinned: %s", err)
+		return false
+	}
+	if ts != "" {
+		err = traceSlack(ctx, "chat.update", func() error {
+			return callSlackAPI(config.SlackApiToken, "chat.update", url.Values{"channel": {publicChannel}, "ts": {ts}, "text": {text}}, nil)
+		})
+		if err == nil {
+			return true
+		}
+		// The message was probably deleted, post a new one.
+		logf(ctx, "updatePinned: %s", err)
+	}
+
+	var resp responsePostMessage
+	err = traceSlack(ctx, "chat.postMessage", func() error {
+		return callSlackAPI(config.SlackApiToken, "chat.postMessage", url.Values{"channel": {publicChannel}, "text": {text}}, &resp)
+	})
+	if err != nil {
+		logf(ctx, "updatePinned: %s", err)
+		return false
+	}
+	err = traceSlack(ctx, "pins.add", func() error {
+		return callSlackAPI(config.SlackApiToken, "pins.add", url.Values{"channel": {resp.Channel}, "timestamp": {resp.Timestamp}}, nil)
+	})
+	if err != nil {
+		logf(ctx, "updatePinned: %s", err)
+	}
+	err = setBotState(ctx, db, key, resp.Timestamp)
+	if err != nil {
+		logf(ctx, "updatePinned: %s", err)
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// For multi-day events, the bot posts a summary of the previous day every
+// morning at config.DailySummary.Time. The summary is computed from the
+// logs' timestamps, so it doesn't matter whether the bot was up all day.
+type DailySummaryConfig struct {
+	Time     string `json:"time"`     // e.g. 09:00, empty disables the summary
+	Timezone string `json:"timezone"` // defaults to UTC
+}
+
+type dailySolves struct {
+	teamID   int
+	teamName string
+	solves   int
+}
+
+func solvesBetween(ctx context.Context, db *sql.DB, from time.Time, until time.Time) ([]dailySolves, error) {
+	rows, err := dbQuery(ctx, db, "SELECT logs.team_id, teams.name, COUNT(*) AS n FROM logs JOIN teams ON teams.id = logs.team_id WHERE logs.team_id < ? AND logs.event LIKE 'flag %' AND logs.ts >= ? AND logs.ts < ? GROUP BY logs.team_id, teams.name ORDER BY n DESC", store.TestTeamID, from.UTC(), until.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []dailySolves{}
+	for rows.Next() {
+		var d dailySolves
+		err = rows.Scan(&d.teamID, &d.teamName, &d.solves)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+func dailySummaryText(ctx context.Context, config Config, db *sql.DB, from time.Time, until time.Time) (string, error) {
+	solves, err := solvesBetween(ctx, db, from, until)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	lines := []string{fmt.Sprintf("Good morning! Here is what happened on %s:", from.Format("Monday, January 2"))}
+	if len(solves) == 0 {
+		lines = append(lines, "No flags were found.")
+	} else {
+		total := 0
+		parts := []string{}
+		for _, d := range solves {
+			total += d.solves
+			parts = append(parts, fmt.Sprintf("Team %s (%d)", publicTeamLabel(config, d.teamID, d.teamName, now), d.solves))
+		}
+		lines = append(lines, fmt.Sprintf("%d flags were found: %s", total, strings.Join(parts, ", ")))
+	}
+
+	released := []string{}
+	for _, c := range releasedBetween(from, until) {
+		released = append(released, c.Title)
+	}
+	if len(released) > 0 {
+		lines = append(lines, fmt.Sprintf("New challenges: %s", strings.Join(released, ", ")))
+	}
+
+	before, err := computeScoresBefore(ctx, config, db, from)
+	if err != nil {
+		return "", err
+	}
+	after, err := computeScoresBefore(ctx, config, db, until)
+	if err != nil {
+		return "", err
+	}
+	oldLeader, hadLeader := currentLeader(before)
+	newLeader, hasLeader := currentLeader(after)
+	if hasLeader && (!hadLeader || oldLeader.teamID != newLeader.teamID) {
+		lines = append(lines, fmt.Sprintf("Team %s is the new leader with %d flags!", publicTeamLabel(config, newLeader.teamID, newLeader.teamName, now), newLeader.numFlags()))
+	} else if hasLeader {
+		lines = append(lines, fmt.Sprintf("Team %s is still in the lead with %d flags.", publicTeamLabel(config, newLeader.teamID, newLeader.teamName, now), newLeader.numFlags()))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// postDailySummary is a job. bot_state remembers the last day a summary was
+// posted.
+func postDailySummary(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	ds := config.DailySummary
+	if ds.Time == "" || inQuietHours(config, time.Now()) {
+		return
+	}
+	at, err := parseClock(ds.Time)
+	if err != nil {
+		logf(ctx, "postDailySummary: %s", err)
+		return
+	}
+	loc, err := loadTimezone(ds.Timezone)
+	if err != nil {
+		logf(ctx, "postDailySummary: %s", err)
+		return
+	}
+	now := time.Now().In(loc)
+	if now.Hour()*60+now.Minute() < at {
+		return
+	}
+	today := now.Format("2006-01-02")
+	last, err := getBotState(ctx, db, "daily_summary_date")
+	if err != nil {
+		logf(ctx, "postDailySummary: %s", err)
+		return
+	}
+	if last == today {
+		return
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	yesterday := midnight.AddDate(0, 0, -1)
+	// Nothing to summarize if the event wasn't running yesterday.
+	running := (config.CtfStart.IsZero() || config.CtfStart.Before(midnight)) &&
+		(config.CtfEnd.IsZero() || config.CtfEnd.After(yesterday))
+	if running {
+		text, err := dailySummaryText(ctx, config, db, yesterday, midnight)
+		if err != nil {
+			logf(ctx, "postDailySummary: %s", err)
+			return
+		}
+		announce(config, db, ws, text)
+	}
+	err = setBotState(ctx, db, "daily_summary_date", today)
+	if err != nil {
+		logf(ctx, "postDailySummary: %s", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// sql.Open doesn't connect, so without the ping a bad DSN would only show up
+// on the first command. The database may still be starting (e.g. both in the
+// same docker-compose), hence the retries.
+const (
+	dbPingAttempts = 12
+	dbPingInterval = 5 * time.Second
+)
+
+// replicaDB is the read-only replica, if mysql_replica_conn_string is set.
+var replicaDB *sql.DB
+
+// readDB returns the connection heavy, read-only commands (scores, exports)
+// should use. Replication lag means it can be a little behind: anything which
+// must see the latest submissions (attempt limits, lead changes) stays on db.
+func readDB(db *sql.DB) *sql.DB {
+	if replicaDB != nil {
+		return replicaDB
+	}
+	return db
+}
+
+func openDB(config Config, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(dialect.Driver(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	if config.MysqlMaxOpenConns > 0 {
+		db.SetMaxOpenConns(config.MysqlMaxOpenConns)
+	}
+	if config.MysqlMaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MysqlMaxIdleConns)
+	}
+	if config.MysqlConnMaxLifetimeSeconds > 0 {
+		db.SetConnMaxLifetime(time.Duration(config.MysqlConnMaxLifetimeSeconds) * time.Second)
+	}
+
+	for i := 1; ; i++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if i == dbPingAttempts {
+			db.Close()
+			return nil, err
+		}
+		log.Printf("openDB: %s, retrying in %s", err, dbPingInterval)
+		time.Sleep(dbPingInterval)
+	}
+}
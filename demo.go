@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// Demo mode shows the event format before launch: a few fake teams start
+// and then, every solve_every_seconds, one of them guesses a flag. Guesses
+// go through the same logs, projections and outbox as real ones, so the
+// scoreboard, announcements and lead changes all behave as on the day.
+//
+// The fake teams are ordinary rows in users, teams and logs: use a
+// throwaway database (or table_prefix) and don't enable it for the real
+// event.
+type DemoConfig struct {
+	Teams             int `json:"teams"` // 0 disables demo mode
+	SolveEverySeconds int `json:"solve_every_seconds"`
+	// Demo teams get IDs from here on, default 600. They must stay below
+	// the test team (666) to show up on the scoreboard.
+	FirstTeamID int `json:"first_team_id"`
+}
+
+var demoTeamNames = []string{"Null Pointers", "Bit Flippers", "Segfault Squad", "Root Cause", "Buffer Overlords", "Off By One", "Heap Spray", "Race Conditions"}
+
+type demoTeam struct {
+	id   int
+	name string
+	user string
+}
+
+func demoTeams(config Config) []demoTeam {
+	first := config.Demo.FirstTeamID
+	if first == 0 {
+		first = 600
+	}
+	teams := []demoTeam{}
+	for i := 0; i < config.Demo.Teams; i++ {
+		name := demoTeamNames[i%len(demoTeamNames)]
+		if i >= len(demoTeamNames) {
+			name = fmt.Sprintf("%s %d", name, i/len(demoTeamNames)+1)
+		}
+		teams = append(teams, demoTeam{id: first + i, name: name, user: fmt.Sprintf("demo-%d", i+1)})
+	}
+	return teams
+}
+
+// startDemo registers the demo teams and starts guessing in the background.
+func startDemo(config Config, db *sql.DB, ws *websocket.Conn) {
+	if config.Demo.Teams == 0 {
+		return
+	}
+	ctx := withCorrelationID(context.Background(), "demo")
+	teams := demoTeams(config)
+	for _, t := range teams {
+		err := registerDemoTeam(ctx, config, db, ws, t)
+		if err != nil {
+			log.Panicf("Failed to register demo team %s: %s", t.name, err)
+		}
+	}
+	fmt.Printf("[OK] Demo mode, %d teams\n", len(teams))
+
+	interval := time.Duration(config.Demo.SolveEverySeconds) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			ctx := withCorrelationID(context.Background(), newCorrelationID())
+			t := teams[rand.Intn(len(teams))]
+			demoGuess(ctx, config, db, ws, t)
+		}
+	}()
+}
+
+// registerDemoTeam does what "start" does for a new team, unless the team
+// exists from a previous run.
+func registerDemoTeam(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, t demoTeam) error {
+	err := queries(db).CreateTeam(ctx, t.id, t.name)
+	if isDuplicateKey(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = dbExec(ctx, db, "INSERT IGNORE INTO users (user, team) VALUES (?, ?)", t.user, t.id)
+	if err != nil {
+		return err
+	}
+	outbox := []outboxItem{{kind: outboxAnnounce, text: renderEntered(publicTeamLabel(config, t.id, t.name, time.Now()), "")}}
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		return recordEvent(ctx, tx, outbox, store.InsertLogParams{User: t.user, Event: "start", Ref: correlationID(ctx)})
+	})
+	if err != nil {
+		return err
+	}
+	deliverOutbox(ctx, config, db, ws, outbox)
+	return nil
+}
+
+// demoGuess makes t guess a released challenge it hasn't solved yet. Half
+// the guesses are wrong, as long as the team has tries left.
+func demoGuess(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, t demoTeam) {
+	now := time.Now()
+	var outbox []outboxItem
+	solved := false
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		done, err := teamSolved(ctx, tx, t.id)
+		if err != nil {
+			return err
+		}
+		candidates := []Challenge{}
+		for _, c := range currentChallenges() {
+			if c.released(now) && !done[c.event()] && len(c.missingRequirements(done)) == 0 {
+				candidates = append(candidates, c)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+		c := candidates[rand.Intn(len(candidates))]
+		count, err := lockAttempts(ctx, tx, t.id, c.Level)
+		if err != nil {
+			return err
+		}
+		rules := c.attemptRules()
+		last, err := lastAttempt(ctx, tx, t.id, c.Level)
+		if err != nil {
+			return err
+		}
+		if ok, _ := rules.allowed(count, last, now); !ok {
+			return nil
+		}
+		event := c.event()
+		if rand.Intn(2) == 0 && rules.left(count+1) != 0 {
+			event = fmt.Sprintf("incorrect:demo-%d", rand.Int())
+		} else {
+			solved = true
+			outbox = append(outbox, outboxItem{kind: outboxSolve, text: publicTeamLabel(config, t.id, t.name, time.Now()), event: event})
+		}
+		err = recordEvent(ctx, tx, outbox, store.InsertLogParams{
+			User:   t.user,
+			Event:  event,
+			Level:  sql.NullInt64{Int64: int64(c.Level), Valid: true},
+			TeamID: sql.NullInt64{Int64: int64(t.id), Valid: true},
+			Ref:    correlationID(ctx),
+		})
+		if err != nil {
+			return err
+		}
+		return incrementAttempts(ctx, tx, t.id, c.Level)
+	})
+	if err != nil {
+		logf(ctx, "demoGuess: %s", err)
+		return
+	}
+	deliverOutbox(ctx, config, db, ws, outbox)
+	if solved {
+		checkLeadChange(ctx, config, db, ws)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/net/websocket"
+)
+
+// The users table holds Slack usernames. To DM someone the bot hasn't heard
+// from yet, it needs their user ID, which is looked up with users.list.
+
+var userIDCache map[string]string
+var userIDCacheLock sync.Mutex
+
+func resolveUsername(ctx context.Context, config Config, username string) (string, error) {
+	userIDCacheLock.Lock()
+	defer userIDCacheLock.Unlock()
+
+	if id, ok := userIDCache[username]; ok {
+		return id, nil
+	}
+
+	logf(ctx, "resolving username: %s", username)
+	api := newSlackClient(config)
+	var users []slack.User
+	err := traceSlack(ctx, "users.list", func() (err error) {
+		users, err = api.GetUsersContext(ctx)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	userIDCache = map[string]string{}
+	for _, u := range users {
+		userIDCache[u.Name] = u.ID
+	}
+	id, ok := userIDCache[username]
+	if !ok {
+		return "", fmt.Errorf("no Slack user named %s", username)
+	}
+	return id, nil
+}
+
+// userNamed returns username's user, e.g. to reply in their language, or a
+// user with the default language if they can't be looked up.
+func userNamed(ctx context.Context, config Config, username string) user {
+	id, err := resolveUsername(ctx, config, username)
+	if err != nil {
+		logf(ctx, "userNamed: %s", err)
+		return user{username: username}
+	}
+	u, err := resolveUser(ctx, config, id)
+	if err != nil && u.username == "" {
+		logf(ctx, "userNamed: %s", err)
+		return user{username: username}
+	}
+	return u
+}
+
+// DMUnavailable means the bot can't open a DM with a user, e.g. because an
+// admin restricted who can DM them. resolveUser still returns the user, with
+// no privateChannel.
+type DMUnavailable struct {
+	Err error
+}
+
+func (e *DMUnavailable) Error() string { return "can't DM the user: " + e.Err.Error() }
+
+func (e *DMUnavailable) Unwrap() error { return e.Err }
+
+// postEphemeral posts text in channel, only visible to userToken.
+func postEphemeral(ctx context.Context, config Config, channel string, userToken string, text string) error {
+	return traceSlack(ctx, "chat.postEphemeral", func() error {
+		return callSlackAPI(config.SlackApiToken, "chat.postEphemeral", url.Values{"channel": {channel}, "user": {userToken}, "text": {text}}, nil)
+	})
+}
+
+// dmUsername sends a private message to a user from the users table.
+func dmUsername(ctx context.Context, config Config, ws *websocket.Conn, username string, text string) error {
+	id, err := resolveUsername(ctx, config, username)
+	if err != nil {
+		return err
+	}
+	u, err := resolveUser(ctx, config, id)
+	if err != nil {
+		return err
+	}
+	postText(ws, u.privateChannel, text)
+	return nil
+}
+
+// teamMembers returns the usernames of everyone on a team.
+func teamMembers(ctx context.Context, db *sql.DB, teamID int) ([]string, error) {
+	rows, err := dbQuery(ctx, db, "SELECT user FROM users WHERE team=?", teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var username string
+		err = rows.Scan(&username)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// "admin doctor" is the one-stop check when the bot feels slow or something
+// doesn't work: Slack scopes, database latency, queues, caches, connection
+// age, missing tables and configuration mistakes, with a :warning: on
+// anything that needs attention.
+
+// slackConnectedAt is when the RTM websocket was (re)connected.
+var slackConnectedAt time.Time
+
+// scopeUses lists the scopes the bot needs, and for what. Scopes only needed
+// with some settings are checked by doctorConfig.
+var scopeUses = []struct {
+	scope string
+	use   string
+}{
+	{"chat:write", "replies and announcements"},
+	{"users:read", "resolving users"},
+	{"im:write", "DMs"},
+	{"channels:read", "finding public_channel"},
+}
+
+// slackScopes returns the scopes granted to the token, from the headers of an
+// auth.test call.
+func slackScopes(ctx context.Context, config Config) (map[string]bool, error) {
+	var scopes map[string]bool
+	err := traceSlack(ctx, "auth.test", func() error {
+		resp, err := http.PostForm(slackAPIURL+"auth.test", url.Values{"token": {config.SlackApiToken}})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("auth.test failed with code %d", resp.StatusCode)
+		}
+		scopes = map[string]bool{}
+		for _, s := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				scopes[s] = true
+			}
+		}
+		return nil
+	})
+	return scopes, err
+}
+
+func dbLatency(ctx context.Context, db *sql.DB) string {
+	start := time.Now()
+	err := db.PingContext(ctx)
+	if err != nil {
+		return ":warning: " + err.Error()
+	}
+	latency := time.Since(start)
+	text := latency.Round(100 * time.Microsecond).String()
+	if latency > 100*time.Millisecond {
+		text = ":warning: " + text
+	}
+	return text
+}
+
+// missingTables lists the tables the bot uses which don't exist.
+func missingTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := dbQuery(ctx, db, dialect.TablesQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, t := range store.Tables {
+		if !existing[tablePrefix+t] {
+			missing = append(missing, tablePrefix+t)
+		}
+	}
+	return missing, nil
+}
+
+// doctorConfig lists configuration mistakes. scopes can be nil if they
+// couldn't be fetched.
+func doctorConfig(config Config, scopes map[string]bool) []string {
+	problems := []string{}
+	if len(config.APIKeys) > 0 && config.HTTPListen == "" {
+		problems = append(problems, "api_keys needs http_listen")
+	}
+	if config.EventsAPI && config.HTTPListen == "" {
+		problems = append(problems, "events_api needs http_listen")
+	}
+	if config.HTTPListen != "" && config.SigningSecret == "" {
+		problems = append(problems, "http_listen is set but slack_signing_secret isn't, every request will be rejected")
+	}
+	if !config.CtfStart.IsZero() && !config.CtfEnd.IsZero() && !config.CtfEnd.After(config.CtfStart) {
+		problems = append(problems, "ctf_end isn't after ctf_start")
+	}
+	if len(currentChallenges()) == 0 {
+		problems = append(problems, "no challenges are defined")
+	}
+	if config.PendingFile == "" {
+		problems = append(problems, "pending_file isn't set, flags sent while MySQL is down are lost")
+	}
+	if config.WebsocketTimeoutSeconds == 0 {
+		problems = append(problems, "websocket_timeout_seconds isn't set, a dead connection may go unnoticed")
+	}
+	needs := map[string]bool{
+		"channels:history": config.WatchdogMinutes > 0,
+		"channels:manage":  config.CreateChannels,
+		"files:write":      true,
+	}
+	if scopes != nil {
+		for scope, needed := range needs {
+			if needed && !scopes[scope] {
+				problems = append(problems, fmt.Sprintf("missing Slack scope %s", scope))
+			}
+		}
+	}
+	return problems
+}
+
+func countPending(config Config) int {
+	if config.PendingFile == "" {
+		return 0
+	}
+	n := 0
+	for _, f := range []string{config.PendingFile, config.PendingFile + ".replay"} {
+		submissions, err := readPending(f)
+		if err == nil {
+			n += len(submissions)
+		}
+	}
+	return n
+}
+
+// admin doctor
+func doAdminDoctor(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	lines := []string{}
+
+	scopes, err := slackScopes(ctx, config)
+	switch {
+	case err != nil:
+		lines = append(lines, fmt.Sprintf("Slack: :warning: %s", err))
+	case len(scopes) == 0:
+		lines = append(lines, "Slack: no scopes reported (legacy token?)")
+		scopes = nil
+	default:
+		missing := []string{}
+		for _, s := range scopeUses {
+			if !scopes[s.scope] {
+				missing = append(missing, fmt.Sprintf("%s (%s)", s.scope, s.use))
+			}
+		}
+		if len(missing) == 0 {
+			lines = append(lines, fmt.Sprintf("Slack: %d scopes, none missing", len(scopes)))
+		} else {
+			lines = append(lines, "Slack: :warning: missing "+strings.Join(missing, ", "))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("Connected to Slack since %s (%s ago)", slackConnectedAt.Format(time.RFC3339), formatDuration(time.Since(slackConnectedAt))))
+
+	lines = append(lines, "Database: "+dbLatency(ctx, db))
+	if replicaDB != nil {
+		lines = append(lines, "Replica: "+dbLatency(ctx, replicaDB))
+	}
+	missing, err := missingTables(ctx, db)
+	switch {
+	case err != nil:
+		lines = append(lines, fmt.Sprintf("Tables: :warning: %s", err))
+	case len(missing) > 0:
+		lines = append(lines, fmt.Sprintf("Tables: :warning: missing %s, run -migrate", strings.Join(missing, ", ")))
+	default:
+		lines = append(lines, "Tables: all there")
+	}
+	pending, err := pendingMigrations(ctx, db)
+	switch {
+	case err != nil:
+		lines = append(lines, fmt.Sprintf("Migrations: :warning: %s", err))
+	case len(pending) > 0:
+		names := []string{}
+		for _, m := range pending {
+			names = append(names, fmt.Sprintf("%d (%s)", m.version, m.name))
+		}
+		lines = append(lines, fmt.Sprintf("Migrations: :warning: %s pending, restart the bot or run -migrate", strings.Join(names, ", ")))
+	default:
+		lines = append(lines, fmt.Sprintf("Migrations: up to date (version %d)", migrations[len(migrations)-1].version))
+	}
+
+	var unposted int
+	err = dbQueryRow(ctx, db, "SELECT COUNT(*) FROM outbox WHERE posted=false").Scan(&unposted)
+	if err != nil {
+		logf(ctx, "doAdminDoctor: %s", err)
+	}
+	logBufferLock.Lock()
+	buffered := len(logBuffer)
+	logBufferLock.Unlock()
+	pendingSolves.lock.Lock()
+	digest := len(pendingSolves.outboxIDs)
+	pendingSolves.lock.Unlock()
+	lines = append(lines, fmt.Sprintf("Queues: %d unposted outbox messages, %d buffered guesses, %d solves waiting for the digest, %d submissions waiting for the database", unposted, buffered, digest, countPending(config)))
+
+	userCacheLock.Lock()
+	users := len(userCache)
+	userCacheLock.Unlock()
+	userIDCacheLock.Lock()
+	userIDs := len(userIDCache)
+	userIDCacheLock.Unlock()
+	channelIDCacheLock.Lock()
+	channels := len(channelIDCache)
+	channelIDCacheLock.Unlock()
+	seenMessages.lock.Lock()
+	seen := len(seenMessages.seen)
+	seenMessages.lock.Unlock()
+	lines = append(lines, fmt.Sprintf("Caches: %d users, %d usernames, %d channels, %d recent messages", users, userIDs, channels, seen))
+
+	problems := doctorConfig(config, scopes)
+	for _, p := range problems {
+		lines = append(lines, "Config: :warning: "+p)
+	}
+	if len(problems) == 0 {
+		lines = append(lines, "Config: no problems found")
+	}
+	postText(ws, m.Channel, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Organizers can hide meta-puzzles in the bot: config.EasterEggs maps secret
+// phrases to a response and, optionally, bonus points. A phrase which isn't a
+// command triggers its egg. The points go into the awards table (as judge
+// "easter-egg"), once per team; the easter_eggs table records who found what.
+
+type EasterEgg struct {
+	Response string `json:"response"`
+	Points   int    `json:"points"`
+}
+
+// findEasterEgg matches the message against the phrases, ignoring case and
+// spacing.
+func findEasterEgg(config Config, parts []string) (string, EasterEgg, bool) {
+	phrase := strings.ToLower(strings.Join(parts, " "))
+	for trigger, egg := range config.EasterEggs {
+		if strings.ToLower(strings.Join(strings.Fields(trigger), " ")) == phrase {
+			return trigger, egg, true
+		}
+	}
+	return "", EasterEgg{}, false
+}
+
+// doEasterEgg returns false if parts isn't an easter egg.
+func doEasterEgg(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, parts []string) bool {
+	trigger, egg, ok := findEasterEgg(config, parts)
+	if !ok {
+		return false
+	}
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return true
+	}
+	logf(ctx, "doEasterEgg: %s found %q", u.username, trigger)
+	if egg.Points == 0 {
+		postText(ws, m.Channel, egg.Response)
+		return true
+	}
+
+	row, err := queries(db).UserTeamName(ctx, u.username)
+	if err == sql.ErrNoRows {
+		postText(ws, m.Channel, egg.Response)
+		return true
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return true
+	}
+	first := true
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		_, err := dbExec(ctx, tx, "INSERT INTO easter_eggs (team_id, phrase, user) VALUES (?, ?, ?)", row.ID, trigger, u.username)
+		if isDuplicateKey(err) {
+			first = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = dbExec(ctx, tx, "INSERT INTO awards (team_id, points, reason, judge, ref) VALUES (?, ?, ?, 'easter-egg', ?)", row.ID, egg.Points, "easter egg", correlationID(ctx))
+		return err
+	})
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return true
+	}
+	if !first {
+		postText(ws, m.Channel, egg.Response+"\n(Your team already found this one.)")
+		return true
+	}
+	postText(ws, m.Channel, egg.Response+fmt.Sprintf("\n+%d points for your team!", egg.Points))
+	checkLeadChange(ctx, config, db, ws)
+	return true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A user pasting the same malformed command over and over shouldn't get a
+// wall of identical errors back. The first error is sent as usual; a repeat
+// within errorRepeatWindow is sent once more with a notice, and later repeats
+// in that window are dropped.
+
+const errorRepeatWindow = time.Minute
+
+type repeatedError struct {
+	first    time.Time
+	repeated bool
+}
+
+type errorLimiter struct {
+	lock sync.Mutex
+	seen map[string]*repeatedError
+}
+
+var errorLimits = &errorLimiter{seen: make(map[string]*repeatedError)}
+
+// check returns whether to send message to userToken and whether to add the
+// cooldown notice.
+func (l *errorLimiter) check(userToken string, message string, now time.Time) (send bool, notice bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for k, e := range l.seen {
+		if now.Sub(e.first) > errorRepeatWindow {
+			delete(l.seen, k)
+		}
+	}
+	key := userToken + "\x00" + message
+	e, ok := l.seen[key]
+	switch {
+	case !ok:
+		l.seen[key] = &repeatedError{first: now}
+		return true, false
+	case !e.repeated:
+		e.repeated = true
+		return true, true
+	default:
+		return false, false
+	}
+}
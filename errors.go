@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// Handlers report failures with reportError, which picks the reply from the
+// error's type. Only the types below turn into specific replies; anything
+// else (database, driver or Slack errors, which can say things like "Error
+// 1045: Access denied for user...") is logged in full and the user only gets
+// the correlation ID to quote to an admin.
+
+// UserError is a reply for the user, already translated.
+type UserError struct {
+	Message string
+}
+
+func (e *UserError) Error() string { return e.Message }
+
+// NotOnTeam means the user isn't in the users table.
+type NotOnTeam struct {
+	User string
+}
+
+func (e *NotOnTeam) Error() string { return e.User + " is not on a team" }
+
+// AttemptLimit means the team can't try the level right now: it used all its
+// tries (for good if Wait is 0) or must wait between two tries.
+type AttemptLimit struct {
+	Max      int
+	Wait     time.Duration
+	Cooldown bool
+}
+
+func (e *AttemptLimit) Error() string {
+	if e.Cooldown {
+		return fmt.Sprintf("cooling down for %s", e.Wait)
+	}
+	return fmt.Sprintf("used all %d tries", e.Max)
+}
+
+func (e *AttemptLimit) message(config Config, u user) string {
+	switch {
+	case e.Cooldown:
+		return tr(config, u, "validate.cooldown", "please wait %s before trying this level again.", formatWait(e.Wait))
+	case e.Wait > 0:
+		return tr(config, u, "validate.locked-out", "you have used your %d tries, you get %d more in %s.", e.Max, e.Max, formatWait(e.Wait))
+	}
+	return tr(config, u, "validate.no-tries-left", "you have used all %d tries for this level.", e.Max)
+}
+
+// InternalError says what the bot was doing when err happened, for the logs.
+type InternalError struct {
+	Op  string
+	Err error
+}
+
+func (e *InternalError) Error() string { return e.Op + ": " + e.Err.Error() }
+
+func (e *InternalError) Unwrap() error { return e.Err }
+
+// reportError replies to the user who sent a command which failed with err.
+// u can be empty if the user couldn't be resolved.
+func reportError(ctx context.Context, config Config, ws *websocket.Conn, channel string, u user, err error, userToken string) {
+	var userErr *UserError
+	var notOnTeam *NotOnTeam
+	var limit *AttemptLimit
+	switch {
+	case errors.As(err, &userErr):
+		postError(ctx, ws, channel, userErr.Message, userToken)
+	case errors.As(err, &notOnTeam):
+		postError(ctx, ws, channel, tr(config, u, "error.no-team", "sorry, I don't know which team you are on."), userToken)
+	case errors.As(err, &limit):
+		postError(ctx, ws, channel, limit.message(config, u), userToken)
+	default:
+		postInternalError(ctx, ws, channel, err, userToken)
+	}
+}
+
+// userTeam returns the user's team, NotOnTeam or an InternalError.
+func userTeam(ctx context.Context, db sqlConn, username string) (store.UserTeamNameRow, error) {
+	row, err := queries(db).UserTeamName(ctx, username)
+	switch {
+	case err == sql.ErrNoRows:
+		return row, &NotOnTeam{User: username}
+	case err != nil:
+		return row, &InternalError{Op: "looking up the team of " + username, Err: err}
+	}
+	return row, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// The event is upcoming until config.CtfStart and finished after
+// config.CtfEnd. Either can be left out. Organizers pause the event by
+// turning off the submissions feature.
+type eventState int
+
+const (
+	eventUpcoming eventState = iota
+	eventLive
+	eventPaused
+	eventFinished
+)
+
+func currentEventState(config Config, db *sql.DB, now time.Time) eventState {
+	switch {
+	case !config.CtfStart.IsZero() && now.Before(config.CtfStart):
+		return eventUpcoming
+	case !config.CtfEnd.IsZero() && !now.Before(config.CtfEnd):
+		return eventFinished
+	case !featureEnabled(db, "submissions"):
+		return eventPaused
+	default:
+		return eventLive
+	}
+}
+
+// formatDuration rounds to the minute and prints e.g. 3h12m or 5m.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	if h == 0 {
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dh%02dm", h, m)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// With config.EventsAPI, messages also arrive through the Events API on
+// /slack/events (next to /slack/interactivity, see interactivity.go), while
+// the RTM websocket keeps running. Slack then delivers most messages twice:
+// whichever copy comes first is handled, the other is dropped. If either path
+// breaks, flags keep coming in through the other one, and replies which can't
+// be sent over the websocket go through chat.postMessage instead.
+
+// How long a message is remembered for deduplication. Slack retries an event
+// for a few minutes at most.
+const dedupWindow = 10 * time.Minute
+
+type messageDedup struct {
+	lock sync.Mutex
+	seen map[string]time.Time
+}
+
+var seenMessages = &messageDedup{seen: make(map[string]time.Time)}
+
+// firstDelivery returns false if a message with the same channel and ts was
+// already handled within dedupWindow. Membership events have no ts, they're
+// identified by who joined or left which channel.
+func (d *messageDedup) firstDelivery(m Message, now time.Time) bool {
+	key := m.Channel + "/" + m.Timestamp
+	if m.Timestamp == "" {
+		if !membershipEvents[m.Type] {
+			return true
+		}
+		key = m.Type + "/" + m.Channel + "/" + m.User
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for k, t := range d.seen {
+		if now.Sub(t) > dedupWindow {
+			delete(d.seen, k)
+		}
+	}
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = now
+	return true
+}
+
+// handleMessage is where messages from both transports end up.
+func handleMessage(config Config, db *sql.DB, ws *websocket.Conn, m Message, botID string) {
+	if !seenMessages.firstDelivery(m, time.Now()) {
+		return
+	}
+	if m.Type == "channel_rename" || m.Type == "group_rename" {
+		go channelRenamed(config, m.Channel, m.Text)
+	}
+	if membershipEvents[m.Type] {
+		go handleMembership(config, db, ws, m, botID)
+	}
+	if parts, ok := commandParts(m, botID); ok {
+		go dispatch(config, db, ws, m, parts)
+	}
+}
+
+type eventsPayload struct {
+	Type      string  `json:"type"`
+	Challenge string  `json:"challenge"`
+	Event     Message `json:"event"`
+}
+
+// eventsHandler serves /slack/events. It must be wrapped in
+// verifySlackRequest.
+func eventsHandler(config Config, db *sql.DB, ws *websocket.Conn, botID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		recordTraffic("events in", body)
+		var p eventsPayload
+		err = json.Unmarshal(body, &p)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch p.Type {
+		case "url_verification":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(p.Challenge))
+		case "event_callback":
+			// Slack wants an answer within 3 seconds, the work happens after.
+			w.WriteHeader(http.StatusOK)
+			noteSlackActivity()
+			if p.Event.Type == "message" || membershipEvents[p.Event.Type] {
+				handleMessage(config, db, ws, p.Event, botID)
+			}
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// webAPIFallbackToken is set when replies may go through the Web API if the
+// websocket is broken, see postMessage.
+var webAPIFallbackToken string
+
+func postMessageWebAPI(m Message) error {
+	params := url.Values{}
+	params.Set("channel", m.Channel)
+	params.Set("text", m.Text)
+	return callSlackAPI(webAPIFallbackToken, "chat.postMessage", params, nil)
+}
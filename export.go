@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/slack-go/slack"
+	"golang.org/x/net/websocket"
+)
+
+// "admin export <kind>" uploads a CSV file to the conversation it was asked
+// in. Exports read from the replica, see readDB.
+
+var exports = map[string]func(ctx context.Context, config Config, db *sql.DB) ([][]string, error){
+	"engagement": engagementRows,
+}
+
+// admin export <kind>
+func doAdminExport(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	export, ok := exports[args[0]]
+	if len(args) != 1 || !ok {
+		postError(ctx, ws, m.Channel, "usage: admin export engagement", m.User)
+		return
+	}
+	rows, err := export(ctx, config, readDB(db))
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	err = w.WriteAll(rows)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	err = uploadFile(config, m.Channel, args[0]+".csv", "csv", b.String())
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminExport: %s exported %s (%d rows)", m.User, args[0], len(rows)-1)
+}
+
+// engagementRows has one row per user in the users table: commands sent to
+// the bot (from the audit table, so only within audit_retention_days), flags
+// found, hints used (logged as "hint ..." events) and the number of distinct
+// hours in which they did any of these.
+func engagementRows(ctx context.Context, config Config, db *sql.DB) ([][]string, error) {
+	type engagement struct {
+		team     sql.NullInt64
+		commands int
+		flags    int
+		hints    int
+		hours    map[string]bool
+	}
+	users := map[string]*engagement{}
+	rows, err := dbQuery(ctx, db, "SELECT user, team FROM users")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var username string
+		e := &engagement{hours: map[string]bool{}}
+		if err = rows.Scan(&username, &e.team); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users[username] = e
+	}
+	rows.Close()
+
+	rows, err = dbQuery(ctx, db, "SELECT user, SUM(CASE WHEN event LIKE 'flag %' THEN 1 ELSE 0 END), SUM(CASE WHEN event LIKE 'hint %' THEN 1 ELSE 0 END) FROM logs WHERE user IS NOT NULL GROUP BY user")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var username string
+		var flags, hints int
+		if err = rows.Scan(&username, &flags, &hints); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if e, ok := users[username]; ok {
+			e.flags, e.hints = flags, hints
+		}
+	}
+	rows.Close()
+
+	rows, err = dbQuery(ctx, db, "SELECT DISTINCT user, DATE_FORMAT(ts, '%Y-%m-%d %H') FROM logs WHERE user IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var username, hour string
+		if err = rows.Scan(&username, &hour); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if e, ok := users[username]; ok {
+			e.hours[hour] = true
+		}
+	}
+	rows.Close()
+
+	// The audit table has Slack user IDs.
+	names, err := usernamesByID(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = dbQuery(ctx, db, "SELECT user, DATE_FORMAT(received, '%Y-%m-%d %H'), COUNT(*) FROM audit GROUP BY user, DATE_FORMAT(received, '%Y-%m-%d %H')")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, hour string
+		var n int
+		if err = rows.Scan(&id, &hour, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if e, ok := users[names[id]]; ok {
+			e.commands += n
+			e.hours[hour] = true
+		}
+	}
+	rows.Close()
+
+	usernames := []string{}
+	for username := range users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	out := [][]string{{"user", "team", "commands", "flags", "hints", "hours_active"}}
+	for _, username := range usernames {
+		e := users[username]
+		team := ""
+		if e.team.Valid {
+			team = strconv.FormatInt(e.team.Int64, 10)
+		}
+		out = append(out, []string{username, team, strconv.Itoa(e.commands), strconv.Itoa(e.flags), strconv.Itoa(e.hints), strconv.Itoa(len(e.hours))})
+	}
+	return out, nil
+}
+
+// usernamesByID maps every Slack user ID to its username.
+func usernamesByID(ctx context.Context, config Config) (map[string]string, error) {
+	api := newSlackClient(config)
+	var users []slack.User
+	err := traceSlack(ctx, "users.list", func() (err error) {
+		users, err = api.GetUsersContext(ctx)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("users.list: %s", err)
+	}
+	names := map[string]string{}
+	for _, u := range users {
+		names[u.ID] = u.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// Feature flags let organizers turn things off during the event without
+// restarting the bot. The features table only holds overrides; anything not
+// in it uses the default below.
+var featureDefaults = map[string]bool{
+	"scores":        true, // the scores command
+	"scores-public": true, // scores command in the public channel
+	"announcements": true, // posting team progress to the public channel
+	"submissions":   true, // validate command, off pauses the event
+	"countdown":     true, // pinned countdown message in the public channel
+	"invites":       true, // invite teams to the public channel on start
+	"ticker":        true, // per-level solve counts, see ticker.go
+}
+
+var featureCache map[string]bool
+var featureCacheLock sync.Mutex
+
+func loadFeatures(db *sql.DB) (map[string]bool, error) {
+	rows, err := dbQuery(context.Background(), db, "SELECT name, enabled FROM features")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	features := map[string]bool{}
+	for name, enabled := range featureDefaults {
+		features[name] = enabled
+	}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		err = rows.Scan(&name, &enabled)
+		if err != nil {
+			return nil, err
+		}
+		features[name] = enabled
+	}
+	return features, rows.Err()
+}
+
+// featureEnabled never fails: if the features table can't be read, the
+// default is used.
+func featureEnabled(db *sql.DB, name string) bool {
+	featureCacheLock.Lock()
+	defer featureCacheLock.Unlock()
+
+	if featureCache == nil {
+		features, err := loadFeatures(db)
+		if err != nil {
+			log.Printf("loadFeatures: %s", err)
+			return featureDefaults[name]
+		}
+		featureCache = features
+	}
+	return featureCache[name]
+}
+
+func setFeature(ctx context.Context, db *sql.DB, name string, enabled bool) error {
+	featureCacheLock.Lock()
+	defer featureCacheLock.Unlock()
+
+	_, err := dbExec(ctx, db, "INSERT INTO features (name, enabled) VALUES (?, ?) ON DUPLICATE KEY UPDATE enabled=?", name, enabled, enabled)
+	if err != nil {
+		return err
+	}
+	featureCache = nil
+	return nil
+}
+
+// admin feature [on|off <name>]
+func doAdminFeature(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if len(args) == 0 {
+		names := []string{}
+		for name := range featureDefaults {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := []string{}
+		for _, name := range names {
+			state := "off"
+			if featureEnabled(db, name) {
+				state = "on"
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", name, state))
+		}
+		postText(ws, m.Channel, strings.Join(lines, "\n"))
+		return
+	}
+
+	if len(args) != 2 || (args[0] != "on" && args[0] != "off") {
+		postError(ctx, ws, m.Channel, "usage: admin feature [on|off <feature>]", m.User)
+		return
+	}
+	name := args[1]
+	if _, ok := featureDefaults[name]; !ok {
+		postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, I don't know about a feature called %s.", escapeText(name)), m.User)
+		return
+	}
+	err := setFeature(ctx, db, name, args[0] == "on")
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminFeature: %s turned %s %s", m.User, name, args[0])
+	postText(ws, m.Channel, fmt.Sprintf("%s is now %s.", name, args[0]))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// Fixtures are synthetic event logs which look like a real event: teams
+// start at different times, have different skill, solve levels in order
+// and make wrong guesses along the way. The same seed always gives the same
+// rows, so they can be used by the benchmarks and checked into a repo.
+//
+//	amigo_bot -gen-fixture fixture.jsonl -fixture-teams 50 -fixture-seed 7
+//	amigo_bot -load-fixture fixture.jsonl
+//
+// -load-fixture writes the rows into the configured database (use a
+// throwaway one!), after which the bot shows the fixture's scoreboard.
+
+type fixtureRow struct {
+	User     string    `json:"user"`
+	TeamID   int       `json:"team_id"`
+	TeamName string    `json:"team_name"`
+	Event    string    `json:"event"`
+	Level    int       `json:"level,omitempty"` // 0 for start
+	Ts       time.Time `json:"ts"`
+}
+
+type fixtureParams struct {
+	Seed     int64
+	Teams    int
+	Levels   int
+	Start    time.Time
+	Duration time.Duration
+}
+
+// generateFixture returns the rows ordered by time. Challenge IDs are the
+// level numbers, i.e. level N is solved with "flag N".
+func generateFixture(p fixtureParams) []fixtureRow {
+	r := rand.New(rand.NewSource(p.Seed))
+	end := p.Start.Add(p.Duration)
+	rows := []fixtureRow{}
+	for team := 1; team <= p.Teams; team++ {
+		name := fmt.Sprintf("team %d", team)
+		players := r.Intn(4) + 1
+		skill := 0.2 + 0.8*r.Float64()
+		at := p.Start.Add(time.Duration(r.Int63n(int64(p.Duration / 10))))
+		for i := 1; i <= players; i++ {
+			rows = append(rows, fixtureRow{User: fmt.Sprintf("player-%d-%d", team, i), TeamID: team, TeamName: name, Event: "start", Ts: at.Add(time.Duration(i) * time.Second)})
+		}
+		for level := 1; level <= p.Levels; level++ {
+			// Harder levels take longer, better teams are faster.
+			mean := float64(level) * 20 * float64(time.Minute) / skill
+			solveAt := at.Add(time.Duration(r.ExpFloat64() * mean))
+			wrong := r.Intn(int(math.Ceil(10*(1-skill)))+level) + 1
+			for i := 0; i < wrong; i++ {
+				guessAt := at.Add(time.Duration(r.Int63n(int64(solveAt.Sub(at)) + 1)))
+				if !guessAt.Before(end) {
+					continue
+				}
+				rows = append(rows, fixtureRow{User: fmt.Sprintf("player-%d-%d", team, r.Intn(players)+1), TeamID: team, TeamName: name, Event: fmt.Sprintf("incorrect:guess-%d", r.Int63()), Level: level, Ts: guessAt})
+			}
+			if !solveAt.Before(end) {
+				break
+			}
+			rows = append(rows, fixtureRow{User: fmt.Sprintf("player-%d-%d", team, r.Intn(players)+1), TeamID: team, TeamName: name, Event: fmt.Sprintf("flag %d", level), Level: level, Ts: solveAt})
+			at = solveAt
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Ts.Before(rows[j].Ts) })
+	return rows
+}
+
+func writeFixture(w io.Writer, rows []fixtureRow) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		err := enc.Encode(row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFixture(path string) ([]fixtureRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows := []fixtureRow{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var row fixtureRow
+		err = json.Unmarshal(scanner.Bytes(), &row)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// loadFixture inserts the teams, users and logs. Projections are rebuilt
+// when the bot starts.
+func loadFixture(ctx context.Context, db *sql.DB, rows []fixtureRow) error {
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		teams := map[int]bool{}
+		for _, row := range rows {
+			if !teams[row.TeamID] {
+				teams[row.TeamID] = true
+				err := queries(tx).CreateTeam(ctx, row.TeamID, row.TeamName)
+				if err != nil {
+					return err
+				}
+			}
+			level := sql.NullInt64{Int64: int64(row.Level), Valid: row.Level != 0}
+			teamID := sql.NullInt64{Int64: int64(row.TeamID), Valid: row.Event != "start"}
+			if row.Event == "start" {
+				_, err := dbExec(ctx, tx, "INSERT IGNORE INTO users (user, team) VALUES (?, ?)", row.User, row.TeamID)
+				if err != nil {
+					return err
+				}
+			}
+			_, err := dbExec(ctx, tx, "INSERT INTO logs (user, event, level, team_id, ref, ts) VALUES (?, ?, ?, ?, ?, ?)", row.User, row.Event, level, teamID, "fixture", row.Ts.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// genFixture writes a fixture for the command line flags.
+func genFixture(path string, teams int, seed int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	rows := generateFixture(fixtureParams{
+		Seed:     seed,
+		Teams:    teams,
+		Levels:   8,
+		Start:    time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC),
+		Duration: 8 * time.Hour,
+	})
+	err = writeFixture(f, rows)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Printf("[OK] %d rows written to %s\n", len(rows), path)
+	return f.Close()
+}
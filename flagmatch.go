@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A challenge's match says how guesses are compared to its flag and flags:
+// - "exact" (the default): byte for byte
+// - "case_insensitive": ignoring case
+// - "trim": ignoring whitespace around the flag and inside its braces, so
+//   "CTF{ abc }" is CTF{abc}
+// - "regex": flag and flags are regular expressions the whole guess must
+//   match, e.g. CTF\{s3cr3t_[0-9]+\}
+// With case_insensitive or trim, flag_hash is the hash of the lowercased or
+// trimmed flag; it can't be used with regex.
+
+const (
+	matchExact           = "exact"
+	matchCaseInsensitive = "case_insensitive"
+	matchTrim            = "trim"
+	matchRegex           = "regex"
+)
+
+// compileMatch checks c.Match and compiles what it needs. flag_format is
+// compiled after, with c.format.
+func compileMatch(c *Challenge) error {
+	switch c.Match {
+	case "":
+		c.Match = matchExact
+	case matchExact, matchCaseInsensitive, matchTrim:
+	case matchRegex:
+		if c.FlagHash != "" {
+			return fmt.Errorf("challenge %d: flag_hash can't be used with match regex", c.ID)
+		}
+		c.patterns = nil
+		for _, f := range append([]string{c.Flag}, c.Flags...) {
+			if f == "" {
+				continue
+			}
+			p, err := regexp.Compile("^(?:" + f + ")$")
+			if err != nil {
+				return fmt.Errorf("challenge %d: flag %q: %s", c.ID, f, err)
+			}
+			c.patterns = append(c.patterns, p)
+		}
+	default:
+		return fmt.Errorf("challenge %d: match must be %s, %s, %s or %s", c.ID, matchExact, matchCaseInsensitive, matchTrim, matchRegex)
+	}
+	return nil
+}
+
+// canonicalFlag is what gets compared, and hashed for flag_hash.
+func (c Challenge) canonicalFlag(flag string) string {
+	switch c.Match {
+	case matchCaseInsensitive:
+		return strings.ToLower(flag)
+	case matchTrim:
+		return trimFlag(flag)
+	default:
+		return flag
+	}
+}
+
+// trimFlag removes whitespace around flag, and around the braces of e.g.
+// "CTF { abc }".
+func trimFlag(flag string) string {
+	flag = strings.TrimSpace(flag)
+	open := strings.Index(flag, "{")
+	if open < 0 || !strings.HasSuffix(flag, "}") {
+		return flag
+	}
+	prefix := strings.TrimSpace(flag[:open])
+	inner := strings.TrimSpace(flag[open+1 : len(flag)-1])
+	return prefix + "{" + inner + "}"
+}
+
+func (c Challenge) flagMatches(flag string) bool {
+	if c.Match == matchRegex {
+		for _, p := range c.patterns {
+			if p.MatchString(flag) {
+				return true
+			}
+		}
+		return false
+	}
+	flag = c.canonicalFlag(flag)
+	if c.FlagHash != "" {
+		sum := sha256.Sum256([]byte(flag))
+		if hex.EncodeToString(sum[:]) == c.FlagHash {
+			return true
+		}
+	}
+	for _, f := range append([]string{c.Flag}, c.Flags...) {
+		if f != "" && flag == c.canonicalFlag(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeFlag tells if flag matches c's flag_format, if it has one.
+func (c Challenge) looksLikeFlag(flag string) bool {
+	if c.format == nil {
+		return true
+	}
+	if c.Match == matchTrim {
+		flag = trimFlag(flag)
+	}
+	return c.format.MatchString(flag)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fuzz targets for the code which parses user text: splitting messages into
+// commands, the flag normalizer, level parsing, escaping and message
+// splitting. Besides not panicking, each target checks the properties the
+// handlers rely on. Run one with
+//
+//	go test -run - -fuzz FuzzCommandParts -fuzztime 1m
+//
+// after touching any of them; plain go test runs the seeds below. Inputs which
+// failed are kept in testdata/fuzz, add them to fuzzSeeds once fixed.
+
+var fuzzSeeds = []string{
+	"validate 1 abcdefgh",
+	"<@U0BOT> validate 2 flag{hello}",
+	"validate 3 <http://example.com|example.com>",
+	"validate 3 <mailto:a@b.c|a@b.c>",
+	"validate 1 `flag{code}`",
+	"validate 1 ```flag```",
+	"validate 1 &lt;script&gt; &amp;amp;",
+	"start <!channel> @here",
+	"validate ١ flag",
+	"validate -1 x",
+	"validate 99999999999999999999 x",
+	"validate 1 \u202eflag\u200b",
+	"hint 2 confirm",
+	"admin outage 1 2016-07-08T18:00:00Z 2016-07-08T19:00:00Z",
+	"",
+}
+
+func addFuzzSeeds(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+}
+
+func FuzzCommandParts(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		m := Message{Type: "message", Channel: "D0FUZZ", User: "U0FUZZ", Text: input}
+		parts, ok := commandParts(m, "U0BOT")
+		if !ok {
+			return
+		}
+		for _, p := range parts {
+			if p == "" || strings.IndexFunc(p, unicode.IsSpace) >= 0 {
+				t.Fatalf("commandParts: part %q has spaces", p)
+			}
+		}
+		if len(parts) < 2 {
+			return
+		}
+		flag := normalizeFlag(strings.Join(parts[1:], " "))
+		if len(flag) > len(input) {
+			t.Fatalf("normalizeFlag: %q is longer than the input", flag)
+		}
+		if utf8.ValidString(input) && !utf8.ValidString(flag) {
+			t.Fatalf("normalizeFlag: %q isn't valid UTF-8", flag)
+		}
+		if level, err := parseLevel(parts[0]); err == nil && level < 1 {
+			t.Fatalf("parseLevel: %q gives level %d", parts[0], level)
+		}
+		normalizeGuess(input)
+	})
+}
+
+func FuzzEscapeText(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		escaped := escapeText(input)
+		if strings.ContainsAny(escaped, "<>") || strings.Contains(escaped, "@here") || strings.Contains(escaped, "@channel") {
+			t.Fatalf("escapeText: %q still has markup", escaped)
+		}
+		if validateTeamName(Config{}, user{}, input) == nil && strings.ContainsAny(input, "<>@`") {
+			t.Fatalf("validateTeamName: accepted %q, which has markup", input)
+		}
+	})
+}
+
+func FuzzSplitMessage(f *testing.F) {
+	addFuzzSeeds(f)
+	// Huge inputs.
+	f.Add(strings.Repeat("validate 1 🚩 ", maxMessageLength/4))
+	f.Fuzz(func(t *testing.T, input string) {
+		total := 0
+		for _, part := range splitMessage(input) {
+			if len(part) > maxMessageLength {
+				t.Fatalf("splitMessage: part of %d bytes", len(part))
+			}
+			if utf8.ValidString(input) && !utf8.ValidString(part) {
+				t.Fatalf("splitMessage: cut inside a character")
+			}
+			total += len(part)
+		}
+		if total > len(input) {
+			t.Fatalf("splitMessage: parts are longer than the input")
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Golden files pin down what the bot posts. Each case below renders fixed
+// data (see render.go and scoreboard.go) and is compared with
+// testdata/golden/<name>; blocks are compared as indented JSON. Run
+//
+//	go test -run TestGolden
+//
+// after touching any message, and add -update to accept the new output once
+// it looks right.
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+type goldenCase struct {
+	name   string
+	render func() (text string, blocks []interface{})
+}
+
+func textCase(name string, text string) goldenCase {
+	return goldenCase{name, func() (string, []interface{}) { return text, nil }}
+}
+
+// goldenStandings covers a decoration, points, combos, awards, hints, a
+// handicap, an unknown elapsed time and a team with no flags.
+func goldenStandings() []standing {
+	return []standing{
+		{rank: 1, decoration: ":first_place_medal:", teamName: "Llamas", flags: []bool{true, true, true, false}, numFlags: 3, points: 250, bonus: 2, elapsed: 102 * time.Minute},
+		{rank: 2, teamName: "Alpacas :alpaca:", flags: []bool{true, false, true, false}, numFlags: 2, points: 2, awarded: 5, penalty: 1, handicap: "×1.5", elapsed: 3*time.Hour + 5*time.Minute},
+		{rank: 3, teamName: "Vicuñas", flags: []bool{true, false, false, false}, numFlags: 1, points: 1},
+		{rank: 4, teamName: "Guanacos", flags: []bool{false, false, false, false}},
+	}
+}
+
+func goldenCases() []goldenCase {
+	config := Config{}
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	timed := Config{CtfStart: now.Add(90 * time.Minute), CtfEnd: now.Add(5 * time.Hour)}
+
+	cases := []goldenCase{}
+	for _, style := range []string{"compact", "emoji", "table", "blocks"} {
+		r := scoreboardRenderers[style]
+		cases = append(cases, goldenCase{"scoreboard-" + style, func() (string, []interface{}) { return r.render(goldenStandings()) }})
+	}
+	cases = append(cases, goldenCase{"scoreboard-plain", func() (string, []interface{}) { return plainRenderer{}.render(goldenStandings()) }})
+
+	for _, s := range []struct {
+		name   string
+		config Config
+		state  eventState
+		now    time.Time
+	}{
+		{"upcoming", timed, eventUpcoming, now},
+		{"live", config, eventLive, now},
+		{"live-until-end", timed, eventLive, now.Add(2 * time.Hour)},
+		{"paused", timed, eventPaused, now.Add(2 * time.Hour)},
+		{"finished", timed, eventFinished, now.Add(6 * time.Hour)},
+	} {
+		text, emoji, presence := renderStatus(s.config, s.state, s.now)
+		cases = append(cases, textCase("status-"+s.name, fmt.Sprintf("%s %s (%s)", emoji, text, presence)))
+	}
+
+	cases = append(cases,
+		textCase("help", renderHelp(config, user{})),
+		textCase("announce-entered", renderEntered("Llamas", "")),
+		textCase("announce-entered-mention", renderEntered("Llamas :llama:", "<!subteam^S0123|@team-llamas>")),
+		textCase("announce-solve", renderSolve("Llamas", "flag 3")),
+		textCase("announce-lead-change", renderLeadChange("Alpacas")),
+		textCase("announce-digest", renderDigest(15, []string{"Llamas", "Alpacas", "Vicuñas"}, map[string]int{"Llamas": 1, "Alpacas": 3, "Vicuñas": 1})),
+	)
+	return cases
+}
+
+// goldenOutput is what's stored in the golden file.
+func goldenOutput(c goldenCase) (string, error) {
+	text, blocks := c.render()
+	if blocks == nil {
+		return text + "\n", nil
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"text": text, "blocks": blocks}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+func TestGolden(t *testing.T) {
+	for _, c := range goldenCases() {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := goldenOutput(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", "golden", c.name)
+			if *update {
+				err = ioutil.WriteFile(path, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				t.Fatalf("no golden file, run with -update")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(want) != got {
+				t.Errorf("differs:\n%s", goldenDiff(string(want), got))
+			}
+		})
+	}
+}
+
+// goldenDiff shows the lines which differ, wanted (-) then got (+).
+func goldenDiff(want string, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	var b strings.Builder
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			fmt.Fprintf(&b, "%d:\n- %s\n+ %s\n", i+1, w, g)
+		}
+	}
+	return b.String()
+}
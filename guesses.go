@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// "admin guesses" clusters the incorrect guesses of each level, so organizers
+// can spot common misconceptions (and post a clarification) during the event,
+// and for the write-up afterwards. Guesses are clustered by normalizeGuess.
+
+// Clusters shown per level.
+const guessClustersPerLevel = 10
+
+// normalizeGuess lowercases a guess, drops a flag{...}-style wrapper and
+// anything that isn't a letter or digit, so "Flag{ Hello_World }" and
+// "helloworld" end up together.
+func normalizeGuess(guess string) string {
+	g := strings.ToLower(strings.TrimSpace(guess))
+	if i := strings.Index(g, "{"); i >= 0 && strings.HasSuffix(g, "}") {
+		g = g[i+1 : len(g)-1]
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, g)
+}
+
+type guessCluster struct {
+	normalized string
+	guesses    int
+	teams      map[int]bool
+	// Most frequent spelling, shown in the report.
+	variants map[string]int
+}
+
+func (c *guessCluster) example() string {
+	best, n := "", 0
+	for v, count := range c.variants {
+		if count > n || (count == n && v < best) {
+			best, n = v, count
+		}
+	}
+	return best
+}
+
+// clusterGuesses returns each level's clusters, biggest (by teams) first.
+func clusterGuesses(ctx context.Context, db *sql.DB, level int) (map[int][]*guessCluster, error) {
+	query := "SELECT level, team_id, event FROM logs WHERE event LIKE 'incorrect:%' AND team_id < ? AND level IS NOT NULL"
+	args := []interface{}{store.TestTeamID}
+	if level > 0 {
+		query += " AND level=?"
+		args = append(args, level)
+	}
+	rows, err := dbQuery(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byLevel := map[int]map[string]*guessCluster{}
+	for rows.Next() {
+		var l, teamID int
+		var event string
+		err = rows.Scan(&l, &teamID, &event)
+		if err != nil {
+			return nil, err
+		}
+		guess := strings.TrimPrefix(event, "incorrect:")
+		key := normalizeGuess(guess)
+		if byLevel[l] == nil {
+			byLevel[l] = map[string]*guessCluster{}
+		}
+		c, ok := byLevel[l][key]
+		if !ok {
+			c = &guessCluster{normalized: key, teams: map[int]bool{}, variants: map[string]int{}}
+			byLevel[l][key] = c
+		}
+		c.guesses++
+		c.teams[teamID] = true
+		c.variants[guess]++
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	clusters := map[int][]*guessCluster{}
+	for l, m := range byLevel {
+		for _, c := range m {
+			clusters[l] = append(clusters[l], c)
+		}
+		sort.Slice(clusters[l], func(i, j int) bool {
+			a, b := clusters[l][i], clusters[l][j]
+			if len(a.teams) != len(b.teams) {
+				return len(a.teams) > len(b.teams)
+			}
+			if a.guesses != b.guesses {
+				return a.guesses > b.guesses
+			}
+			return a.normalized < b.normalized
+		})
+	}
+	return clusters, nil
+}
+
+// admin guesses [<level>]
+func doAdminGuesses(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	level := 0
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			postError(ctx, ws, m.Channel, "usage: admin guesses [<level>]", m.User)
+			return
+		}
+		level = n
+	} else if len(args) > 1 {
+		postError(ctx, ws, m.Channel, "usage: admin guesses [<level>]", m.User)
+		return
+	}
+
+	clusters, err := clusterGuesses(ctx, readDB(db), level)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if len(clusters) == 0 {
+		postText(ws, m.Channel, "No incorrect guesses yet.")
+		return
+	}
+	levels := []int{}
+	for l := range clusters {
+		levels = append(levels, l)
+	}
+	sort.Ints(levels)
+
+	lines := []string{}
+	for _, l := range levels {
+		lines = append(lines, fmt.Sprintf("*Level %d* (%d distinct wrong answers)", l, len(clusters[l])))
+		for i, c := range clusters[l] {
+			if i == guessClustersPerLevel {
+				break
+			}
+			line := fmt.Sprintf("• `%s`: %d teams, %d guesses", escapeText(c.example()), len(c.teams), c.guesses)
+			if len(c.variants) > 1 {
+				line += fmt.Sprintf(", %d spellings", len(c.variants))
+			}
+			lines = append(lines, line)
+		}
+	}
+	postText(ws, m.Channel, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Handicaps let beginner teams compete with experienced ones: admins can give
+// a team a multiplier on its challenge points and/or a head start (points it
+// starts with). They are stored in the handicaps table and shown next to the
+// team's score.
+
+// admin handicap [<team> <multiplier> [<head start>] | <team> off]
+func doAdminHandicap(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	usage := "usage: admin handicap [<team> <multiplier> [<head start>] | <team> off]"
+	if len(args) == 0 {
+		listHandicaps(ctx, config, db, ws, m)
+		return
+	}
+	if len(args) >= 2 && args[len(args)-1] == "off" {
+		teamID, teamName, ok := handicapTeam(ctx, db, ws, m, args[:len(args)-1])
+		if !ok {
+			return
+		}
+		err := queries(db).RemoveHandicap(ctx, teamID)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		logf(ctx, "doAdminHandicap: %s removed team %d's handicap", m.User, teamID)
+		postText(ws, m.Channel, fmt.Sprintf("Team %s has no handicap anymore.", teamLabel(config, teamID, teamName)))
+		checkLeadChange(ctx, config, db, ws)
+		return
+	}
+
+	// The team is everything before the multiplier, like in judge award.
+	i := 1
+	multiplier := 0
+	for ; i < len(args); i++ {
+		if n, ok := parseMultiplier(args[i]); ok {
+			multiplier = n
+			break
+		}
+	}
+	if i == len(args) || len(args) > i+2 {
+		postError(ctx, ws, m.Channel, usage, m.User)
+		return
+	}
+	headStart := 0
+	if len(args) == i+2 {
+		n, err := strconv.Atoi(strings.TrimPrefix(args[i+1], "+"))
+		if err != nil {
+			postError(ctx, ws, m.Channel, usage, m.User)
+			return
+		}
+		headStart = n
+	}
+	teamID, teamName, ok := handicapTeam(ctx, db, ws, m, args[:i])
+	if !ok {
+		return
+	}
+
+	err := queries(db).SetHandicap(ctx, teamID, multiplier, headStart)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminHandicap: %s set team %d's handicap to %d%%, +%d", m.User, teamID, multiplier, headStart)
+	postText(ws, m.Channel, fmt.Sprintf("Team %s now has a handicap of %s.", teamLabel(config, teamID, teamName), handicapLabel(multiplier, headStart)))
+	checkLeadChange(ctx, config, db, ws)
+}
+
+func handicapTeam(ctx context.Context, db *sql.DB, ws *websocket.Conn, m Message, args []string) (int, string, bool) {
+	team := strings.Join(args, " ")
+	teamID, teamName, err := findTeam(ctx, db, team)
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, I don't know team %s.", escapeText(team)), m.User)
+		return 0, "", false
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return 0, "", false
+	}
+	return teamID, teamName, true
+}
+
+func listHandicaps(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message) {
+	handicaps, err := queries(db).Handicaps(ctx)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	lines := []string{}
+	for _, h := range handicaps {
+		lines = append(lines, fmt.Sprintf("%s: %s", teamLabel(config, h.TeamID, h.TeamName), handicapLabel(h.Multiplier, h.HeadStart)))
+	}
+	if len(lines) == 0 {
+		postText(ws, m.Channel, "No team has a handicap.")
+		return
+	}
+	postText(ws, m.Channel, strings.Join(lines, "\n"))
+}
+
+// parseMultiplier turns "1.5" or "x1.5" into a percentage (150).
+func parseMultiplier(s string) (int, bool) {
+	f, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimPrefix(s, "x"), "×"), 64)
+	if err != nil || f <= 0 || f > 100 {
+		return 0, false
+	}
+	return int(math.Round(f * 100)), true
+}
+
+// handicapLabel is e.g. "×1.5, +10 head start".
+func handicapLabel(multiplier int, headStart int) string {
+	parts := []string{}
+	if multiplier != 100 {
+		parts = append(parts, "×"+strconv.FormatFloat(float64(multiplier)/100, 'f', -1, 64))
+	}
+	if headStart != 0 {
+		parts = append(parts, fmt.Sprintf("%+d head start", headStart))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// handicap is the team's handicapLabel, or "" if it has none.
+func (s teamScores) handicap() string {
+	if (s.multiplier == 0 || s.multiplier == 100) && s.headStart == 0 {
+		return ""
+	}
+	multiplier := s.multiplier
+	if multiplier == 0 {
+		multiplier = 100
+	}
+	return handicapLabel(multiplier, s.headStart)
+}
+
+// addHandicaps applies the handicaps to the tallied teams, and adds the teams
+// which only have a head start so far.
+func addHandicaps(ctx context.Context, db *sql.DB, tally *scoreTally) error {
+	handicaps, err := queries(db).ScoredHandicaps(ctx)
+	if err != nil {
+		return err
+	}
+	for _, h := range handicaps {
+		if _, ok := tally.teams[h.TeamID]; !ok && h.HeadStart == 0 {
+			continue
+		}
+		s := tally.team(h.TeamID, h.TeamName)
+		s.multiplier = h.Multiplier
+		s.headStart = h.HeadStart
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// Every hour while the event is live, admins get a DM about each released
+// challenge: how many teams solved it, attempts per team and the most common
+// wrong guess (both per level, since guesses are logged per level), and
+// whether its auto hint went out. Challenges which look broken are flagged:
+// no solves an hour after release, or the same wrong guess from several teams
+// (often a flag typo or a format mismatch).
+
+// A wrong guess shared by this many teams gets a challenge flagged.
+const suspiciousGuessTeams = 3
+
+type challengeHealth struct {
+	challenge    Challenge
+	solves       int
+	avgAttempts  float64
+	topGuess     string
+	topGuessTeam int
+	hint         string
+}
+
+func (h challengeHealth) suspicious(now time.Time, started time.Time) []string {
+	reasons := []string{}
+	if h.solves == 0 && !started.IsZero() && now.Sub(started) >= time.Hour {
+		reasons = append(reasons, "no solves after an hour")
+	}
+	if h.topGuessTeam >= suspiciousGuessTeams {
+		reasons = append(reasons, fmt.Sprintf("%d teams guessed the same wrong flag", h.topGuessTeam))
+	}
+	return reasons
+}
+
+func startedTeams(ctx context.Context, db *sql.DB) (int, error) {
+	var n int
+	err := dbQueryRow(ctx, db, "SELECT COUNT(DISTINCT users.team) FROM logs JOIN users ON users.user = logs.user WHERE logs.event='start' AND users.team < ?", store.TestTeamID).Scan(&n)
+	return n, err
+}
+
+func measureChallenge(ctx context.Context, db *sql.DB, c Challenge) (challengeHealth, error) {
+	h := challengeHealth{challenge: c}
+	var err error
+	h.solves, err = queries(db).CountSolves(ctx, c.event())
+	if err != nil {
+		return h, err
+	}
+	var avg sql.NullFloat64
+	err = dbQueryRow(ctx, db, "SELECT AVG(count) FROM attempts WHERE level=? AND team_id < ?", c.Level, store.TestTeamID).Scan(&avg)
+	if err != nil {
+		return h, err
+	}
+	h.avgAttempts = avg.Float64
+	err = dbQueryRow(ctx, db, "SELECT event, COUNT(DISTINCT team_id) AS n FROM logs WHERE level=? AND event LIKE 'incorrect:%' AND team_id < ? GROUP BY event ORDER BY n DESC LIMIT 1", c.Level, store.TestTeamID).Scan(&h.topGuess, &h.topGuessTeam)
+	if err != nil && err != sql.ErrNoRows {
+		return h, err
+	}
+	h.topGuess = strings.TrimPrefix(h.topGuess, "incorrect:")
+	h.hint, err = getBotState(ctx, db, fmt.Sprintf("auto-hint:%d", c.ID))
+	return h, err
+}
+
+func challengeHealthText(ctx context.Context, config Config, db *sql.DB, now time.Time) (string, error) {
+	teams, err := startedTeams(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{fmt.Sprintf("Challenge health (%d teams started):", teams)}
+	for _, c := range currentChallenges() {
+		if !c.released(now) {
+			continue
+		}
+		h, err := measureChallenge(ctx, db, c)
+		if err != nil {
+			return "", err
+		}
+		line := fmt.Sprintf("• %d. %s: %d/%d solved, %.1f attempts per team on level %d", c.ID, c.Title, h.solves, teams, h.avgAttempts, c.Level)
+		if h.topGuessTeam > 0 {
+			line += fmt.Sprintf(", top wrong guess `%s` (%d teams)", escapeText(h.topGuess), h.topGuessTeam)
+		}
+		if h.hint != "" {
+			line += ", auto hint " + h.hint
+		}
+		started := c.Release
+		if started.IsZero() {
+			started = config.CtfStart
+		}
+		if reasons := h.suspicious(now, started); len(reasons) > 0 {
+			line += "\n  :warning: likely broken: " + strings.Join(reasons, ", ")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// sendChallengeHealth is a job.
+func sendChallengeHealth(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	now := time.Now()
+	if currentEventState(config, db, now) != eventLive || inQuietHours(config, now) {
+		return
+	}
+	text, err := challengeHealthText(ctx, config, readDB(db), now)
+	if err != nil {
+		logf(ctx, "sendChallengeHealth: %s", err)
+		return
+	}
+	dmAdmins(ctx, config, db, ws, text)
+}
@@ -1,20 +1,19 @@
 package main
 
 import (
-	"log"
+	"context"
 
 	"golang.org/x/net/websocket"
 )
 
 // Prints help message
-func doHelp(config Config, ws *websocket.Conn, user string, channel string) {
+func doHelp(ctx context.Context, config Config, ws *websocket.Conn, user string, channel string) {
 	var m Message
 	m.Type = "message"
 	m.Channel = channel
 
-	m.Text = `start _team name_: sets your team's name and PMs you a link to a puzzle. This starts your clock.
-validate _level_ _flag_: tells you if a flag for a level is correct (message or invite me to a private channel first!).
-scores: tells you the current top scores (beta)`
-	log.Printf("posting: %v", m)
+	u, _ := resolveUser(ctx, config, user) // English if it fails
+	m.Text = renderHelp(config, u)
+	logf(ctx, "posting: %v", m)
 	postMessage(ws, m)
 }
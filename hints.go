@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// hint <level> gives the team the next hint for a level: the hints of the
+// level's released challenges, in challenge ID order. Each hint taken is
+// logged as "hint <challenge id>", without a level so it doesn't count as a
+// try, and costs the team the challenge's hint_penalty (or the global
+// hint_penalty) points. When a hint costs points, the player has to repeat
+// the command with "confirm".
+
+type levelHint struct {
+	challenge Challenge
+	text      string
+}
+
+// levelHints returns the hints of level, in the order they are given out.
+func levelHints(level int, now time.Time) []levelHint {
+	hints := []levelHint{}
+	for _, c := range currentChallenges() {
+		if c.Level != level || !c.released(now) {
+			continue
+		}
+		for _, h := range c.Hints {
+			hints = append(hints, levelHint{c, h})
+		}
+	}
+	return hints
+}
+
+func hintPenalty(config Config, c Challenge) int {
+	if c.HintPenalty != 0 {
+		return c.HintPenalty
+	}
+	return config.HintPenalty
+}
+
+// hintsTaken counts the hints the team took on level.
+func hintsTaken(ctx context.Context, db sqlConn, teamID int, level int) (int, error) {
+	rows, err := dbQuery(ctx, db, "SELECT event FROM logs WHERE team_id=? AND event LIKE 'hint %'", teamID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var event string
+		err = rows.Scan(&event)
+		if err != nil {
+			return 0, err
+		}
+		c, ok := hintChallenge(event)
+		if ok && c.Level == level {
+			n++
+		}
+	}
+	return n, rows.Err()
+}
+
+// hintChallenge returns the challenge a "hint N" event is about.
+func hintChallenge(event string) (Challenge, bool) {
+	return challengeByEvent("flag " + strings.TrimPrefix(event, "hint "))
+}
+
+// hint <level> [confirm]
+func doHint(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	handled, err := alreadyHandled(ctx, db, u.username, m.Timestamp)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if handled {
+		return
+	}
+	row, err := userTeam(ctx, db, u.username)
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	if m.Channel == getPublicChannel() {
+		postError(ctx, ws, m.Channel, tr(config, u, "hint.public", "please ask for hints in a private message."), m.User)
+		return
+	}
+	level, err := parseLevel(args[0])
+	if err != nil {
+		postError(ctx, ws, m.Channel, tr(config, u, "validate.bad-level", "%s is not a valid puzzle number", escapeText(args[0])), m.User)
+		return
+	}
+	if level > maxLevel() {
+		postError(ctx, ws, m.Channel, tr(config, u, "validate.no-such-level", "there is no puzzle %d.", level), m.User)
+		return
+	}
+	hints := levelHints(level, time.Now())
+	confirmed := len(args) > 1 && args[1] == "confirm"
+
+	var reply string
+	var outbox []outboxItem
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		// Serializes the team's hints (and guesses) for the level.
+		_, err := lockAttempts(ctx, tx, row.ID, level)
+		if err != nil {
+			return err
+		}
+		taken, err := hintsTaken(ctx, tx, row.ID, level)
+		if err != nil {
+			return err
+		}
+		if taken >= len(hints) {
+			reply = tr(config, u, "hint.none", "there are no more hints for level %d.", level)
+			return nil
+		}
+		next := hints[taken]
+		penalty := hintPenalty(config, next.challenge)
+		if penalty > 0 && !confirmed {
+			reply = tr(config, u, "hint.cost", "Hint %d of %d for level %d costs your team %d points. Say `hint %d confirm` to get it.", taken+1, len(hints), level, penalty, level)
+			return nil
+		}
+		text := tr(config, u, "hint.reply", "Hint %d of %d for level %d: %s", taken+1, len(hints), level, next.text)
+		outbox = append(outbox, outboxItem{kind: outboxReply, channel: m.Channel, text: text})
+		return recordEvent(ctx, tx, outbox, store.InsertLogParams{
+			User:   u.username,
+			Event:  fmt.Sprintf("hint %d", next.challenge.ID),
+			TeamID: sql.NullInt64{Int64: int64(row.ID), Valid: true},
+			Ref:    correlationID(ctx),
+			MsgTs:  msgTsValue(m.Timestamp),
+		})
+	})
+	if isDuplicateKey(err) {
+		logf(ctx, "doHint: duplicate delivery of %s", m.Timestamp)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if reply != "" {
+		postText(ws, m.Channel, reply)
+		return
+	}
+	logf(ctx, "doHint: %s took a hint for level %d", u.username, level)
+	deliverOutbox(ctx, config, db, ws, outbox)
+	notifyTeam(ctx, config, db, ws, row.ID, u.username, "teammate-hints", fmt.Sprintf("%s took a hint for level %d.", u.username, level))
+}
+
+// addHints takes the penalty of every hint taken before until (unless until
+// is zero) off the teams' scores.
+func addHints(ctx context.Context, config Config, db *sql.DB, tally *scoreTally, until time.Time) error {
+	query := "SELECT logs.team_id, teams.name, logs.event FROM logs JOIN teams ON teams.id = logs.team_id WHERE logs.team_id < ? AND logs.event LIKE 'hint %'"
+	args := []interface{}{store.TestTeamID}
+	if !until.IsZero() {
+		query += " AND logs.ts < ?"
+		args = append(args, until.UTC())
+	}
+	rows, err := dbQuery(ctx, db, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var teamID int
+		var teamName, event string
+		err = rows.Scan(&teamID, &teamName, &event)
+		if err != nil {
+			return err
+		}
+		c, ok := hintChallenge(event)
+		if !ok {
+			continue
+		}
+		tally.team(teamID, teamName).penalty += hintPenalty(config, c)
+	}
+	return rows.Err()
+}
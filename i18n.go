@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Replies are written in English. A catalog, locales/<language>.json (see
+// locales/fr.json.sample), maps message keys to translations, which must keep
+// the same %s/%d verbs in the same order. Each user gets the catalog matching
+// the locale of their Slack profile ("fr-FR", then "fr"), else the one for
+// default_language, else English. Users don't have to set anything.
+
+var catalogs = map[string]map[string]string{}
+
+func localesDir(config Config) string {
+	if config.LocalesDir == "" {
+		return "locales"
+	}
+	return config.LocalesDir
+}
+
+// loadCatalogs reads every catalog in the locales directory. Having none is
+// fine.
+func loadCatalogs(config Config) error {
+	paths, err := filepath.Glob(filepath.Join(localesDir(config), "*.json"))
+	if err != nil {
+		return err
+	}
+	loaded := map[string]map[string]string{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var catalog map[string]string
+		err = json.Unmarshal(data, &catalog)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		loaded[strings.TrimSuffix(filepath.Base(path), ".json")] = catalog
+	}
+	catalogs = loaded
+	return nil
+}
+
+// catalogFor picks the catalog for a Slack locale, or nil for English.
+func catalogFor(config Config, locale string) map[string]string {
+	for _, lang := range []string{locale, strings.SplitN(locale, "-", 2)[0], config.DefaultLanguage} {
+		if c, ok := catalogs[lang]; ok && lang != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// tr formats the translation of key for u, falling back to the event's
+// personality (see personality.go) and then to format.
+func tr(config Config, u user, key string, format string, args ...interface{}) string {
+	if t, ok := catalogFor(config, u.locale)[key]; ok {
+		format = t
+	} else if t, ok := personalityFor(config)[key]; ok {
+		format = t
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Slack can deliver the same message more than once (retries, reconnects).
+// start and validate store the message's timestamp in logs.msg_ts, and a
+// unique key on (user, msg_ts) guarantees a message is only ever acted upon
+// once. alreadyHandled is the cheap check done before any side effect;
+// isDuplicateKey catches the race where two copies are handled concurrently.
+
+func alreadyHandled(ctx context.Context, db *sql.DB, username string, msgTs string) (bool, error) {
+	if msgTs == "" {
+		return false, nil
+	}
+	var id int
+	err := dbQueryRow(ctx, db, "SELECT id FROM logs WHERE user=? AND msg_ts=?", username, msgTs).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	logf(ctx, "alreadyHandled: %s %s", username, msgTs)
+	return true, nil
+}
+
+func isDuplicateKey(err error) bool {
+	return dialect.IsDuplicateKey(err)
+}
+
+// msgTsValue stores messages without a timestamp as NULL, which the unique
+// key ignores.
+func msgTsValue(msgTs string) sql.NullString {
+	return sql.NullString{String: msgTs, Valid: msgTs != ""}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// With config.HTTPListen, the bot serves Slack's interactivity requests
+// (button clicks) on /slack/interactivity. Set the app's Request URL to
+// https://<host>/slack/interactivity. Every request is signature-checked,
+// see signature.go.
+
+type interaction struct {
+	userID      string
+	actionID    string
+	value       string
+	responseURL string
+}
+
+// An interactionHandler runs when a button with its action_id is clicked. The
+// message holding the button is replaced with the returned text, unless it's
+// empty. Slack wants action_ids to be unique within a message, anything after
+// a "." is ignored when looking up the handler.
+type interactionHandler func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, in interaction) string
+
+var interactions = map[string]interactionHandler{
+	"appeal_accept": doAppealAccept,
+	"appeal_reject": doAppealReject,
+}
+
+type interactivityPayload struct {
+	Type string `json:"type"`
+	User struct {
+		Id string `json:"id"`
+	} `json:"user"`
+	Actions []struct {
+		ActionId string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// interaction is the first action of a block_actions payload.
+func (p interactivityPayload) interaction() interaction {
+	return interaction{userID: p.User.Id, actionID: p.Actions[0].ActionId, value: p.Actions[0].Value, responseURL: p.ResponseURL}
+}
+
+func startHTTP(config Config, db *sql.DB, ws *websocket.Conn, botID string) {
+	if config.HTTPListen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/slack/interactivity", verifySlackRequest(config.SigningSecret, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p interactivityPayload
+		err := json.Unmarshal([]byte(r.FormValue("payload")), &p)
+		if err != nil || p.Type != "block_actions" || len(p.Actions) == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		// Slack wants an answer within 3 seconds, the work happens after.
+		w.WriteHeader(http.StatusOK)
+		go handleInteraction(config, db, ws, p.interaction())
+	})))
+	if config.EventsAPI {
+		mux.Handle("/slack/events", verifySlackRequest(config.SigningSecret, eventsHandler(config, db, ws, botID)))
+	}
+	if len(config.APIKeys) > 0 {
+		mux.Handle("/api/submit", apiSubmitHandler(config, db, ws))
+	}
+	if config.TeamTokens {
+		startTeamAPI(mux, config, db, ws)
+	}
+	go func() {
+		log.Fatal(http.ListenAndServe(config.HTTPListen, mux))
+	}()
+}
+
+func handleInteraction(config Config, db *sql.DB, ws *websocket.Conn, in interaction) {
+	ctx := withCorrelationID(context.Background(), newCorrelationID())
+	handler, ok := interactions[strings.SplitN(in.actionID, ".", 2)[0]]
+	if !ok {
+		logf(ctx, "handleInteraction: unknown action %s", in.actionID)
+		return
+	}
+	text := handler(ctx, config, db, ws, in)
+	if text == "" || in.responseURL == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{"replace_original": true, "text": text})
+	resp, err := http.Post(in.responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logf(ctx, "handleInteraction: %s", err)
+		return
+	}
+	resp.Body.Close()
+}
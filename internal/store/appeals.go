@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Queries for appeals of rejected guesses, see appeals.go in the bot.
+
+const rejectedGuess = "SELECT id, event, level FROM logs WHERE ref=? AND team_id=? AND event LIKE 'incorrect:%'"
+
+type RejectedGuess struct {
+	LogID int
+	Event string
+	Level int
+}
+
+// RejectedGuess returns the team's wrong guess with the receipt (its ref), or
+// sql.ErrNoRows.
+func (q *Queries) RejectedGuess(ctx context.Context, receipt string, teamID int) (RejectedGuess, error) {
+	var g RejectedGuess
+	err := q.db.QueryRowContext(ctx, rejectedGuess, receipt, teamID).Scan(&g.LogID, &g.Event, &g.Level)
+	return g, err
+}
+
+const createAppeal = "INSERT INTO appeals (log_id, user, reason, status, ref) VALUES (?, ?, ?, 'open', ?)"
+
+// CreateAppeal opens an appeal and returns its id. A guess can only be
+// appealed once: a second appeal is a duplicate key error.
+func (q *Queries) CreateAppeal(ctx context.Context, logID int, user string, reason string, ref string) (int64, error) {
+	return q.db.InsertID(ctx, createAppeal, logID, user, reason, ref)
+}
+
+const (
+	closeAppeal = "UPDATE appeals SET status=?, decided_by=? WHERE id=? AND status='open'"
+	appeal      = "SELECT user, log_id FROM appeals WHERE id=?"
+)
+
+// DecideAppeal closes an open appeal, returning who made it and the log entry
+// it's about. ok is false if the appeal was already decided.
+func (q *Queries) DecideAppeal(ctx context.Context, appealID int64, status string, admin string) (user string, logID int, ok bool, err error) {
+	res, err := q.db.ExecContext(ctx, closeAppeal, status, admin, appealID)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return "", 0, false, err
+	}
+	err = q.db.QueryRowContext(ctx, appeal, appealID).Scan(&user, &logID)
+	return user, logID, err == nil, err
+}
+
+const logEntry = "SELECT team_id, UNIX_TIMESTAMP(ts) FROM logs WHERE id=?"
+
+// LogEntry returns the team of a log entry and when it was logged.
+func (q *Queries) LogEntry(ctx context.Context, logID int) (int, time.Time, error) {
+	var teamID int
+	var ts int64
+	err := q.db.QueryRowContext(ctx, logEntry, logID).Scan(&teamID, &ts)
+	return teamID, time.Unix(ts, 0), err
+}
+
+const setLogEvent = "UPDATE logs SET event=? WHERE id=?"
+
+// SetLogEvent rewrites what a log entry recorded, e.g. a wrong guess turned
+// into a solve by an appeal.
+func (q *Queries) SetLogEvent(ctx context.Context, logID int, event string) error {
+	_, err := q.db.ExecContext(ctx, setLogEvent, event, logID)
+	return err
+}
+
+const setSolveTime = "UPDATE scoreboard SET ts=? WHERE team_id=? AND event=?"
+
+// SetSolveTime sets when the team found event, e.g. to the time of the
+// guess an appeal turned into a solve, as "admin rebuild" would.
+func (q *Queries) SetSolveTime(ctx context.Context, teamID int, event string, at time.Time) error {
+	_, err := q.db.ExecContext(ctx, setSolveTime, at.UTC(), teamID, event)
+	return err
+}
@@ -0,0 +1,360 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// The bot runs on MySQL, Postgres or SQLite (a single file, for small events
+// which don't want to run a database server). Queries are written for MySQL
+// and go through the Dialect's Rewrite, like they go through Prefix, which
+// translates the few MySQL-isms they use:
+// - INSERT IGNORE and ON DUPLICATE KEY UPDATE (the key must be in
+//   conflictKeys)
+// - UNIX_TIMESTAMP(), DATE_FORMAT(), NOW(), LEAST()
+// - SELECT ... FOR UPDATE, which SQLite doesn't need as transactions there
+//   lock the whole database
+// - ? placeholders, and user as a column name (reserved in Postgres)
+// Anything else must be portable: INSERT INTO t (a, b) VALUES (?, ?) rather
+// than INSERT INTO t SET a=?, b=?, no implicit boolean to integer casts...
+// Rewrite returns an error for the MySQL-isms it knows it can't translate
+// (see untranslatable), rather than leaving them for the database to reject.
+
+// Dialect is what differs between the databases the bot supports.
+type Dialect interface {
+	// Driver is the database/sql driver name.
+	Driver() string
+	// Rewrite translates a query written for MySQL.
+	Rewrite(query string) (string, error)
+	// Schema translates a CREATE TABLE statement written for MySQL. Its
+	// keys can turn into separate CREATE INDEX statements.
+	Schema(stmt string) []string
+	// TablesQuery lists the tables of the database.
+	TablesQuery() string
+	// Returning is true if the id of an inserted row must be fetched with
+	// RETURNING id, as sql.Result.LastInsertId isn't supported.
+	Returning() bool
+	// IsDuplicateKey tells if err is a primary or unique key violation.
+	IsDuplicateKey(err error) bool
+}
+
+// DialectFor returns the dialect for the database config setting, "" being
+// MySQL.
+func DialectFor(name string) (Dialect, error) {
+	switch name {
+	case "", "mysql":
+		return MySQL{}, nil
+	case "postgres":
+		return Postgres{}, nil
+	case "sqlite":
+		return SQLite{}, nil
+	}
+	return nil, fmt.Errorf("unknown database %q (mysql, postgres or sqlite)", name)
+}
+
+// conflictKeys are the keys the ON DUPLICATE KEY UPDATE queries can hit, per
+// table. ON CONFLICT has to name them.
+var conflictKeys = map[string]string{
+	"users":       "user",
+	"features":    "name",
+	"bot_state":   "name",
+	"observers":   "user",
+	"preferences": "user, kind",
+	"handicaps":   "team_id",
+}
+
+type MySQL struct{}
+
+func (MySQL) Driver() string { return "mysql" }
+
+func (MySQL) Rewrite(query string) (string, error) { return query, nil }
+
+func (MySQL) Schema(stmt string) []string { return []string{stmt} }
+
+func (MySQL) TablesQuery() string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+}
+
+func (MySQL) Returning() bool { return false }
+
+func (MySQL) IsDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+type Postgres struct{}
+
+func (Postgres) Driver() string { return "postgres" }
+
+func (Postgres) Rewrite(query string) (string, error) {
+	err := untranslatable(query)
+	if err != nil {
+		return "", err
+	}
+	if insertIgnore.MatchString(query) {
+		query = insertIgnore.ReplaceAllString(query, "INSERT INTO") + " ON CONFLICT DO NOTHING"
+	}
+	query, err = onConflict(query)
+	if err != nil {
+		return "", err
+	}
+	query, err = rewriteCalls(query, unixTimestampCall, 1, func(args []string) string {
+		return "CAST(EXTRACT(EPOCH FROM " + args[0] + ") AS BIGINT)"
+	})
+	if err != nil {
+		return "", err
+	}
+	query, err = rewriteCalls(query, dateFormatCall, 2, func(args []string) string {
+		return "to_char(" + args[0] + ", " + postgresDateFormat.Replace(args[1]) + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	n := 0
+	return outsideLiterals(query, func(s string) string {
+		s = userColumn.ReplaceAllString(s, `"user"`)
+		return placeholder.ReplaceAllStringFunc(s, func(string) string {
+			n++
+			return fmt.Sprintf("$%d", n)
+		})
+	}), nil
+}
+
+func (Postgres) Schema(stmt string) []string {
+	return splitKeys(stmt, "serial primary key", "timestamp")
+}
+
+func (Postgres) TablesQuery() string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()"
+}
+
+func (Postgres) Returning() bool { return true }
+
+func (Postgres) IsDuplicateKey(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+type SQLite struct{}
+
+func (SQLite) Driver() string { return "sqlite3" }
+
+func (SQLite) Rewrite(query string) (string, error) {
+	err := untranslatable(query)
+	if err != nil {
+		return "", err
+	}
+	query = insertIgnore.ReplaceAllString(query, "INSERT OR IGNORE INTO")
+	query, err = onConflict(query)
+	if err != nil {
+		return "", err
+	}
+	query = forUpdate.ReplaceAllString(query, "")
+	query, err = rewriteCalls(query, unixTimestampCall, 1, func(args []string) string {
+		return "CAST(strftime('%s', " + args[0] + ") AS INTEGER)"
+	})
+	if err != nil {
+		return "", err
+	}
+	query, err = rewriteCalls(query, dateFormatCall, 2, func(args []string) string {
+		return "strftime(" + sqliteDateFormat.Replace(args[1]) + ", " + args[0] + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	query, err = rewriteCalls(query, nowCall, 0, func([]string) string { return "CURRENT_TIMESTAMP" })
+	if err != nil {
+		return "", err
+	}
+	return rewriteCalls(query, leastCall, -1, func(args []string) string { return "MIN(" + strings.Join(args, ", ") + ")" })
+}
+
+func (SQLite) Schema(stmt string) []string {
+	return splitKeys(stmt, "integer primary key autoincrement", "datetime")
+}
+
+func (SQLite) TablesQuery() string {
+	return "SELECT name FROM sqlite_master WHERE type='table'"
+}
+
+func (SQLite) Returning() bool { return false }
+
+func (SQLite) IsDuplicateKey(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+var (
+	insertIgnore = regexp.MustCompile(`(?i)^INSERT IGNORE INTO`)
+	upsert       = regexp.MustCompile(`(?is)^(INSERT INTO (\w+) .*) ON DUPLICATE KEY UPDATE (.*)$`)
+	duplicateKey = regexp.MustCompile(`(?i)\bON DUPLICATE KEY\b`)
+	insertSet    = regexp.MustCompile(`(?i)^\s*INSERT\s+(IGNORE\s+)?INTO\s+\w+\s+SET\b`)
+	valuesRef    = regexp.MustCompile(`(?i)\bVALUES\((\w+)\)`)
+	forUpdate    = regexp.MustCompile(`(?i)\s+FOR UPDATE\s*$`)
+	userColumn   = regexp.MustCompile(`\buser\b`)
+	placeholder  = regexp.MustCompile(`\?`)
+
+	unixTimestampCall = regexp.MustCompile(`(?i)\bUNIX_TIMESTAMP\(`)
+	dateFormatCall    = regexp.MustCompile(`(?i)\bDATE_FORMAT\(`)
+	nowCall           = regexp.MustCompile(`(?i)\bNOW\(`)
+	leastCall         = regexp.MustCompile(`(?i)\bLEAST\(`)
+
+	// MySQL functions Postgres or SQLite don't have, which Rewrite doesn't
+	// translate.
+	mysqlOnlyCall = regexp.MustCompile(`(?i)\b(IF|IFNULL|GROUP_CONCAT|FROM_UNIXTIME|STR_TO_DATE|TIMESTAMPDIFF|DATE_ADD|DATE_SUB|CURDATE)\(`)
+)
+
+// MySQL's DATE_FORMAT uses strftime's codes, except for minutes and seconds.
+var (
+	postgresDateFormat = strings.NewReplacer("%Y", "YYYY", "%m", "MM", "%d", "DD", "%H", "HH24", "%i", "MI", "%s", "SS")
+	sqliteDateFormat   = strings.NewReplacer("%i", "%M", "%s", "%S")
+)
+
+// untranslatable returns an error if query uses MySQL syntax Rewrite can't
+// translate.
+func untranslatable(query string) error {
+	var problem string
+	outsideLiterals(query, func(s string) string {
+		if m := mysqlOnlyCall.FindStringSubmatch(s); m != nil && problem == "" {
+			problem = m[1] + "()"
+		}
+		return s
+	})
+	if problem == "" && insertSet.MatchString(query) {
+		problem = "INSERT ... SET"
+	}
+	if problem != "" {
+		return fmt.Errorf("can't translate %s in %q", problem, query)
+	}
+	return nil
+}
+
+// onConflict turns ON DUPLICATE KEY UPDATE into ON CONFLICT (key) DO UPDATE.
+func onConflict(query string) (string, error) {
+	m := upsert.FindStringSubmatch(query)
+	if m == nil {
+		if duplicateKey.MatchString(query) {
+			return "", fmt.Errorf("can't translate ON DUPLICATE KEY in %q, only INSERT INTO ... ON DUPLICATE KEY UPDATE", query)
+		}
+		return query, nil
+	}
+	keys, ok := conflictKeys[m[2]]
+	if !ok {
+		return "", fmt.Errorf("can't translate ON DUPLICATE KEY UPDATE on %s, add its key to conflictKeys", m[2])
+	}
+	return m[1] + " ON CONFLICT (" + keys + ") DO UPDATE SET " + valuesRef.ReplaceAllString(m[3], "excluded.$1"), nil
+}
+
+// outsideLiterals applies f to the parts of query which aren't in quotes.
+func outsideLiterals(query string, f func(string) string) string {
+	var b strings.Builder
+	start := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote == 0 && (c == '\'' || c == '"'):
+			b.WriteString(f(query[start:i]))
+			quote = c
+			start = i
+		case quote != 0 && c == quote:
+			b.WriteString(query[start : i+1])
+			quote = 0
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		b.WriteString(query[start:])
+	} else {
+		b.WriteString(f(query[start:]))
+	}
+	return b.String()
+}
+
+// rewriteCalls replaces the function calls matched by call (up to the opening
+// parenthesis) with what f returns for their arguments, of which there must be
+// nargs (any number if negative).
+func rewriteCalls(query string, call *regexp.Regexp, nargs int, f func(args []string) string) (string, error) {
+	for {
+		loc := call.FindStringIndex(query)
+		if loc == nil {
+			return query, nil
+		}
+		name := query[loc[0] : loc[1]-1]
+		args, end := callArgs(query, loc[1])
+		if end < 0 {
+			return "", fmt.Errorf("can't translate %s() in %q: no closing parenthesis", name, query)
+		}
+		if nargs >= 0 && len(args) != nargs {
+			return "", fmt.Errorf("can't translate %s() in %q: %d arguments, expected %d", name, query, len(args), nargs)
+		}
+		query = query[:loc[0]] + f(args) + query[end+1:]
+	}
+}
+
+// callArgs splits the arguments of a call whose opening parenthesis is just
+// before start, and returns the index of the closing one (-1 if missing).
+func callArgs(query string, start int) ([]string, int) {
+	args := []string{}
+	depth := 0
+	var quote byte
+	argStart := start
+	for i := start; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ')':
+			if arg := strings.TrimSpace(query[argStart:i]); arg != "" {
+				args = append(args, arg)
+			}
+			return args, i
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(query[argStart:i]))
+			argStart = i + 1
+		}
+	}
+	return nil, -1
+}
+
+var createTable = regexp.MustCompile(`(?is)^CREATE TABLE IF NOT EXISTS (\w+) \((.*)\)$`)
+var indexKey = regexp.MustCompile(`(?i)^key \((.*)\)$`)
+
+// splitKeys translates the MySQL-only parts of a CREATE TABLE: auto_increment
+// ids, datetime columns and the keys declared in the table, which become
+// CREATE INDEX statements (named after the table, which may be prefixed).
+func splitKeys(stmt string, autoIncrement string, datetime string) []string {
+	m := createTable.FindStringSubmatch(stmt)
+	if m == nil {
+		return []string{stmt}
+	}
+	table := m[1]
+	columns := []string{}
+	indexes := []string{}
+	parts, _ := callArgs(m[2]+")", 0)
+	for _, part := range parts {
+		if k := indexKey.FindStringSubmatch(part); k != nil {
+			name := table + "_" + strings.NewReplacer(",", "_", " ", "").Replace(k[1])
+			indexes = append(indexes, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, k[1]))
+			continue
+		}
+		part = strings.Replace(part, "int not null auto_increment primary key", autoIncrement, 1)
+		part = strings.Replace(part, "unique key (", "unique (", 1)
+		part = strings.Replace(part, " datetime", " "+datetime, 1)
+		columns = append(columns, part)
+	}
+	return append([]string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(columns, ", "))}, indexes...)
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	for _, c := range []struct {
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{Postgres{}, "SELECT id FROM users WHERE user=? AND team=?", `SELECT id FROM users WHERE "user"=$1 AND team=$2`},
+		{Postgres{}, "SELECT 'user?' FROM logs WHERE user=?", `SELECT 'user?' FROM logs WHERE "user"=$1`},
+		{Postgres{}, "INSERT IGNORE INTO scoreboard (team_id, event) VALUES (?, ?)", "INSERT INTO scoreboard (team_id, event) VALUES ($1, $2) ON CONFLICT DO NOTHING"},
+		{Postgres{}, "INSERT INTO handicaps (team_id, multiplier) VALUES (?, ?) ON DUPLICATE KEY UPDATE multiplier=VALUES(multiplier)", "INSERT INTO handicaps (team_id, multiplier) VALUES ($1, $2) ON CONFLICT (team_id) DO UPDATE SET multiplier=excluded.multiplier"},
+		{Postgres{}, "SELECT UNIX_TIMESTAMP(MAX(ts)) FROM logs", "SELECT CAST(EXTRACT(EPOCH FROM MAX(ts)) AS BIGINT) FROM logs"},
+		{Postgres{}, "SELECT DATE_FORMAT(ts, '%Y-%m-%d %H:%i') FROM logs", "SELECT to_char(ts, 'YYYY-MM-DD HH24:MI') FROM logs"},
+		{SQLite{}, "INSERT IGNORE INTO users (user, team) VALUES (?, ?)", "INSERT OR IGNORE INTO users (user, team) VALUES (?, ?)"},
+		{SQLite{}, "SELECT count FROM attempts WHERE team_id=? FOR UPDATE", "SELECT count FROM attempts WHERE team_id=?"},
+		{SQLite{}, "UPDATE logs SET ts=LEAST(ts, ?), seen=NOW()", "UPDATE logs SET ts=MIN(ts, ?), seen=CURRENT_TIMESTAMP"},
+		{MySQL{}, "INSERT INTO t SET a=? ON DUPLICATE KEY UPDATE a=?", "INSERT INTO t SET a=? ON DUPLICATE KEY UPDATE a=?"},
+	} {
+		got, err := c.dialect.Rewrite(c.query)
+		if err != nil {
+			t.Errorf("%s: %q: %s", c.dialect.Driver(), c.query, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: %q\ngot  %q\nwant %q", c.dialect.Driver(), c.query, got, c.want)
+		}
+	}
+}
+
+func TestRewriteUntranslatable(t *testing.T) {
+	for _, query := range []string{
+		// Not in conflictKeys.
+		"INSERT INTO logs (user, event) VALUES (?, ?) ON DUPLICATE KEY UPDATE event=VALUES(event)",
+		"INSERT INTO users SET user=?, team=?",
+		"INSERT INTO users (user, team) SELECT user, team FROM old ON DUPLICATE KEY",
+		"SELECT IFNULL(name, '') FROM teams",
+		"SELECT GROUP_CONCAT(name) FROM teams",
+		"SELECT UNIX_TIMESTAMP(ts FROM logs",
+		"SELECT DATE_FORMAT(ts) FROM logs",
+	} {
+		for _, d := range []Dialect{Postgres{}, SQLite{}} {
+			if got, err := d.Rewrite(query); err == nil {
+				t.Errorf("%s: %q gave %q, expected an error", d.Driver(), query, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+)
+
+type Handicap struct {
+	TeamID   int
+	TeamName string
+	// Percentage applied to the team's points, 100 for none.
+	Multiplier int
+	HeadStart  int
+}
+
+const setHandicap = "INSERT INTO handicaps (team_id, multiplier, head_start) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE multiplier=VALUES(multiplier), head_start=VALUES(head_start)"
+
+func (q *Queries) SetHandicap(ctx context.Context, teamID int, multiplier int, headStart int) error {
+	_, err := q.db.ExecContext(ctx, setHandicap, teamID, multiplier, headStart)
+	return err
+}
+
+const removeHandicap = "DELETE FROM handicaps WHERE team_id=?"
+
+func (q *Queries) RemoveHandicap(ctx context.Context, teamID int) error {
+	_, err := q.db.ExecContext(ctx, removeHandicap, teamID)
+	return err
+}
+
+const (
+	handicaps       = "SELECT handicaps.team_id, teams.name, handicaps.multiplier, handicaps.head_start FROM handicaps JOIN teams ON teams.id = handicaps.team_id ORDER BY teams.name"
+	scoredHandicaps = "SELECT handicaps.team_id, teams.name, handicaps.multiplier, handicaps.head_start FROM handicaps JOIN teams ON teams.id = handicaps.team_id WHERE handicaps.team_id < ?"
+)
+
+// Handicaps lists all the handicaps, by team name.
+func (q *Queries) Handicaps(ctx context.Context) ([]Handicap, error) {
+	return q.handicaps(ctx, handicaps)
+}
+
+// ScoredHandicaps leaves out the test teams.
+func (q *Queries) ScoredHandicaps(ctx context.Context) ([]Handicap, error) {
+	return q.handicaps(ctx, scoredHandicaps, TestTeamID)
+}
+
+func (q *Queries) handicaps(ctx context.Context, query string, args ...interface{}) ([]Handicap, error) {
+	rows, err := q.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Handicap
+	for rows.Next() {
+		var h Handicap
+		err = rows.Scan(&h.TeamID, &h.TeamName, &h.Multiplier, &h.HeadStart)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, h)
+	}
+	return items, rows.Err()
+}
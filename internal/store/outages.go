@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Queries for the outages table, see outages.go in the bot.
+
+// CountedAttempt selects the logs rows which use up a try: wrong guesses on a
+// level while its challenge was broken don't.
+const CountedAttempt = "NOT (logs.event LIKE 'incorrect:%' AND EXISTS (SELECT 1 FROM outages WHERE outages.level = logs.level AND logs.ts >= outages.started AND (outages.ended IS NULL OR logs.ts < outages.ended)))"
+
+const startOutage = "INSERT INTO outages (challenge_id, level, started, reason) VALUES (?, ?, NOW(), ?)"
+
+func (q *Queries) StartOutage(ctx context.Context, challengeID int, level int, reason string) error {
+	_, err := q.db.ExecContext(ctx, startOutage, challengeID, level, reason)
+	return err
+}
+
+const endOutage = "UPDATE outages SET ended=NOW() WHERE challenge_id=? AND ended IS NULL"
+
+func (q *Queries) EndOutage(ctx context.Context, challengeID int) error {
+	_, err := q.db.ExecContext(ctx, endOutage, challengeID)
+	return err
+}
+
+const recordOutage = "INSERT INTO outages (challenge_id, level, started, ended, reason) VALUES (?, ?, ?, ?, ?)"
+
+// RecordOutage records an outage which is over.
+func (q *Queries) RecordOutage(ctx context.Context, challengeID int, level int, from time.Time, until time.Time, reason string) error {
+	_, err := q.db.ExecContext(ctx, recordOutage, challengeID, level, from.UTC(), until.UTC(), reason)
+	return err
+}
+
+const levelAttempts = "SELECT team_id, count FROM attempts WHERE level=?"
+
+// LevelAttempts returns each team's attempt counter for level.
+func (q *Queries) LevelAttempts(ctx context.Context, level int) (map[int]int, error) {
+	rows, err := q.db.QueryContext(ctx, levelAttempts, level)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[int]int{}
+	for rows.Next() {
+		var teamID, count int
+		err = rows.Scan(&teamID, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[teamID] = count
+	}
+	return counts, rows.Err()
+}
+
+const (
+	deleteLevelAttempts = "DELETE FROM attempts WHERE level=?"
+	countLevelAttempts  = "INSERT INTO attempts (team_id, level, count) SELECT team_id, level, COUNT(*) FROM logs WHERE team_id IS NOT NULL AND level=? AND " + CountedAttempt + " GROUP BY team_id, level"
+)
+
+// RecountAttempts rebuilds the level's attempt counters from the logs.
+func (q *Queries) RecountAttempts(ctx context.Context, level int) error {
+	_, err := q.db.ExecContext(ctx, deleteLevelAttempts, level)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, countLevelAttempts, level)
+	return err
+}
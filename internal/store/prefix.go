@@ -0,0 +1,44 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Several events (or test and prod) can share a MySQL database by giving
+// each a table prefix: with prefix "ctf24_", the teams table is ctf24_teams.
+// Queries are written with the plain table names and go through Prefix,
+// which aliases the prefixed tables in FROM and JOIN clauses so that
+// qualified columns (teams.name) keep working.
+
+// Tables lists every table the bot uses.
+var Tables = []string{
+	"teams", "users", "logs", "features", "bot_state", "observers", "preferences", "outbox", "attempts",
+	"scoreboard", "audit", "awards", "appeals", "handicaps", "easter_eggs", "outages", "roles",
+	"schema_migrations", "registrations", "team_tokens",
+}
+
+var tableRef = regexp.MustCompile(`(?i)\b(DELETE\s+FROM|FROM|JOIN|INTO|UPDATE|EXISTS|ALTER\s+TABLE)\s+(` + strings.Join(Tables, "|") + `)\b`)
+
+var validPrefix = regexp.MustCompile(`^[A-Za-z0-9_]*$`)
+
+// ValidPrefix checks a prefix can be pasted into queries.
+func ValidPrefix(prefix string) bool {
+	return validPrefix.MatchString(prefix)
+}
+
+// Prefix rewrites the table names in query.
+func Prefix(query string, prefix string) string {
+	if prefix == "" {
+		return query
+	}
+	return tableRef.ReplaceAllStringFunc(query, func(ref string) string {
+		m := tableRef.FindStringSubmatch(ref)
+		keyword, table := m[1], m[2]
+		prefixed := keyword + " " + prefix + table
+		if strings.EqualFold(keyword, "FROM") || strings.EqualFold(keyword, "JOIN") {
+			prefixed += " AS " + table
+		}
+		return prefixed
+	})
+}
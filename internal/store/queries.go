@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+const userTeam = "SELECT team FROM users WHERE user=?"
+
+// UserTeam returns the user's team ID, or sql.ErrNoRows.
+func (q *Queries) UserTeam(ctx context.Context, user string) (int, error) {
+	var team int
+	err := q.db.QueryRowContext(ctx, userTeam, user).Scan(&team)
+	return team, err
+}
+
+const teamLogUser = "SELECT COALESCE(user, 'someone') FROM logs WHERE team_id=? LIMIT 1"
+
+// TeamLogUser returns a user who logged something for the team ("someone" if
+// they were purged), or sql.ErrNoRows.
+func (q *Queries) TeamLogUser(ctx context.Context, teamID int) (string, error) {
+	var user string
+	err := q.db.QueryRowContext(ctx, teamLogUser, teamID).Scan(&user)
+	return user, err
+}
+
+const createTeam = "INSERT INTO teams (id, name) VALUES (?, ?)"
+
+func (q *Queries) CreateTeam(ctx context.Context, id int, name string) error {
+	_, err := q.db.ExecContext(ctx, createTeam, id, name)
+	return err
+}
+
+const userTeamName = "SELECT teams.name, teams.id FROM teams JOIN users ON teams.id = users.team WHERE users.user=?"
+
+type UserTeamNameRow struct {
+	Name string
+	ID   int
+}
+
+// UserTeamName returns the user's team once it started, or sql.ErrNoRows.
+func (q *Queries) UserTeamName(ctx context.Context, user string) (UserTeamNameRow, error) {
+	var row UserTeamNameRow
+	err := q.db.QueryRowContext(ctx, userTeamName, user).Scan(&row.Name, &row.ID)
+	return row, err
+}
+
+const insertLog = "INSERT INTO logs (user, event, level, team_id, ref, msg_ts) VALUES (?, ?, ?, ?, ?, ?)"
+
+// InsertLogParams leaves level and team_id NULL for events which aren't about
+// a level (e.g. start), and msg_ts NULL for messages without a timestamp.
+type InsertLogParams struct {
+	User   string
+	Event  string
+	Level  sql.NullInt64
+	TeamID sql.NullInt64
+	Ref    string
+	MsgTs  sql.NullString
+}
+
+func (q *Queries) InsertLog(ctx context.Context, arg InsertLogParams) error {
+	_, err := q.db.ExecContext(ctx, insertLog, arg.User, arg.Event, arg.Level, arg.TeamID, arg.Ref, arg.MsgTs)
+	return err
+}
+
+const loggedEvent = "SELECT event FROM logs WHERE user=? AND msg_ts=?"
+
+// LoggedEvent returns the event the user logged with msg_ts, or
+// sql.ErrNoRows.
+func (q *Queries) LoggedEvent(ctx context.Context, user string, msgTs string) (string, error) {
+	var event string
+	err := q.db.QueryRowContext(ctx, loggedEvent, user, msgTs).Scan(&event)
+	return event, err
+}
+
+const countTeamEvents = "SELECT COUNT(*) FROM logs WHERE team_id=? AND level=? AND event=?"
+
+// CountTeamEvents counts how often the team logged event for level, e.g. the
+// same incorrect guess.
+func (q *Queries) CountTeamEvents(ctx context.Context, teamID int, level int, event string) (int, error) {
+	var count int
+	err := q.db.QueryRowContext(ctx, countTeamEvents, teamID, level, event).Scan(&count)
+	return count, err
+}
+
+const countSolves = "SELECT COUNT(*) FROM logs WHERE event=? AND team_id < ?"
+
+// CountSolves counts the solves of event, test teams excluded.
+func (q *Queries) CountSolves(ctx context.Context, event string) (int, error) {
+	var count int
+	err := q.db.QueryRowContext(ctx, countSolves, event, TestTeamID).Scan(&count)
+	return count, err
+}
+
+const teamByName = "SELECT id FROM teams WHERE name=?"
+
+// TeamByName returns the ID of the team, or sql.ErrNoRows.
+func (q *Queries) TeamByName(ctx context.Context, name string) (int, error) {
+	var id int
+	err := q.db.QueryRowContext(ctx, teamByName, name).Scan(&id)
+	return id, err
+}
+
+const teamName = "SELECT name FROM teams WHERE id=?"
+
+// TeamName returns the name of the team, or sql.ErrNoRows.
+func (q *Queries) TeamName(ctx context.Context, id int) (string, error) {
+	var name string
+	err := q.db.QueryRowContext(ctx, teamName, id).Scan(&name)
+	return name, err
+}
+
+const countTeams = "SELECT COUNT(*) FROM teams WHERE id < ?"
+
+// CountTeams counts the teams, test teams excluded.
+func (q *Queries) CountTeams(ctx context.Context) (int, error) {
+	var count int
+	err := q.db.QueryRowContext(ctx, countTeams, TestTeamID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Queries for players forming teams from Slack, see registration.go in the
+// bot.
+
+const userOnTeam = "SELECT team FROM users WHERE user=?"
+
+// UserOnTeam tells if the user is on a team. Users added without a team
+// aren't.
+func (q *Queries) UserOnTeam(ctx context.Context, user string) (bool, error) {
+	var team sql.NullInt64
+	err := q.db.QueryRowContext(ctx, userOnTeam, user).Scan(&team)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return team.Valid, err
+}
+
+const teamSize = "SELECT COUNT(*) FROM users WHERE team=?"
+
+func (q *Queries) TeamSize(ctx context.Context, teamID int) (int, error) {
+	var n int
+	err := q.db.QueryRowContext(ctx, teamSize, teamID).Scan(&n)
+	return n, err
+}
+
+const (
+	maxTeamID     = "SELECT MAX(id) FROM teams WHERE id < ?"
+	maxUserTeamID = "SELECT MAX(team) FROM users WHERE team < ?"
+)
+
+// NextTeamID returns the ID after the highest team ID in teams or users,
+// test teams excluded.
+func (q *Queries) NextTeamID(ctx context.Context) (int, error) {
+	var teams, users sql.NullInt64
+	err := q.db.QueryRowContext(ctx, maxTeamID, TestTeamID).Scan(&teams)
+	if err != nil {
+		return 0, err
+	}
+	err = q.db.QueryRowContext(ctx, maxUserTeamID, TestTeamID).Scan(&users)
+	if err != nil {
+		return 0, err
+	}
+	id := int(teams.Int64) + 1
+	if int(users.Int64) >= id {
+		id = int(users.Int64) + 1
+	}
+	return id, nil
+}
+
+const setUserTeam = "INSERT INTO users (user, team) VALUES (?, ?) ON DUPLICATE KEY UPDATE team=VALUES(team)"
+
+// SetUserTeam puts the user on the team, adding them to users if needed.
+func (q *Queries) SetUserTeam(ctx context.Context, user string, teamID int) error {
+	_, err := q.db.ExecContext(ctx, setUserTeam, user, teamID)
+	return err
+}
+
+const (
+	countTeamsNamed      = "SELECT COUNT(*) FROM teams WHERE name=?"
+	countPendingTeamName = "SELECT COUNT(*) FROM registrations WHERE kind='register' AND status='pending' AND team_name=?"
+)
+
+// TeamNameTaken tells if a team is called name, or waits for approval to be.
+func (q *Queries) TeamNameTaken(ctx context.Context, name string) (bool, error) {
+	for _, query := range []string{countTeamsNamed, countPendingTeamName} {
+		var n int
+		err := q.db.QueryRowContext(ctx, query, name).Scan(&n)
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+	}
+	return false, nil
+}
+
+const createRegistration = "INSERT INTO registrations (user, kind, team_id, team_name, status) VALUES (?, ?, ?, ?, 'pending')"
+
+// CreateRegistration stores a pending invitation or request and returns its
+// id.
+func (q *Queries) CreateRegistration(ctx context.Context, user string, kind string, teamID int, teamName string) (int64, error) {
+	return q.db.InsertID(ctx, createRegistration, user, kind, teamID, teamName)
+}
+
+const pendingInvite = "SELECT id FROM registrations WHERE user=? AND kind='invite' AND team_id=? AND status='pending' LIMIT 1"
+
+// PendingInvite returns the id of an invitation of the user to the team, or
+// sql.ErrNoRows.
+func (q *Queries) PendingInvite(ctx context.Context, user string, teamID int) (int64, error) {
+	var id int64
+	err := q.db.QueryRowContext(ctx, pendingInvite, user, teamID).Scan(&id)
+	return id, err
+}
+
+const setRegistrationStatus = "UPDATE registrations SET status=? WHERE id=?"
+
+func (q *Queries) SetRegistrationStatus(ctx context.Context, id int64, status string) error {
+	_, err := q.db.ExecContext(ctx, setRegistrationStatus, status, id)
+	return err
+}
+
+// PendingRegistration is a request waiting for an organizer. TeamName is the
+// team to create for "register", Team the team to join for "join".
+type PendingRegistration struct {
+	ID       int64
+	User     string
+	Kind     string
+	TeamID   int
+	TeamName string
+	Team     string
+}
+
+const pendingRegistrations = "SELECT registrations.id, registrations.user, registrations.kind, registrations.team_name, COALESCE(teams.name, '') FROM registrations LEFT JOIN teams ON teams.id = registrations.team_id WHERE registrations.status='pending' AND registrations.kind <> 'invite' ORDER BY registrations.id"
+
+func (q *Queries) PendingRegistrations(ctx context.Context) ([]PendingRegistration, error) {
+	rows, err := q.db.QueryContext(ctx, pendingRegistrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	regs := []PendingRegistration{}
+	for rows.Next() {
+		var r PendingRegistration
+		err = rows.Scan(&r.ID, &r.User, &r.Kind, &r.TeamName, &r.Team)
+		if err != nil {
+			return nil, err
+		}
+		regs = append(regs, r)
+	}
+	return regs, rows.Err()
+}
+
+const lockPendingRegistration = "SELECT user, kind, team_id, team_name FROM registrations WHERE id=? AND status='pending' AND kind <> 'invite' FOR UPDATE"
+
+// LockPendingRegistration returns the request, locked until the end of the
+// transaction, or sql.ErrNoRows if it isn't pending. Team isn't set.
+func (q *Queries) LockPendingRegistration(ctx context.Context, id int64) (PendingRegistration, error) {
+	r := PendingRegistration{ID: id}
+	err := q.db.QueryRowContext(ctx, lockPendingRegistration, id).Scan(&r.User, &r.Kind, &r.TeamID, &r.TeamName)
+	return r, err
+}
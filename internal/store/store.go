@@ -0,0 +1,41 @@
+// Package store holds the bot's queries: the core tables (users, teams,
+// logs), what "mydata" returns, registrations, outages, appeals and
+// handicaps. Each query is a method with typed parameters and results, so
+// callers don't repeat the SQL or the Scan. The SQL is still hand-written and
+// only checked when it runs, a column or type mismatch is a runtime error.
+// New queries belong here rather than in dbQuery/dbExec calls in the bot.
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is what the queries run on: a *sql.DB or *sql.Tx, through the bot's
+// tracing and dialect translation.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) Row
+	// InsertID runs an INSERT into a table with an auto_increment id and
+	// returns the new row's id.
+	InsertID(ctx context.Context, query string, args ...interface{}) (int64, error)
+}
+
+// Row is a *sql.Row, or the error which kept the query from running (e.g. one
+// the dialect couldn't translate).
+type Row interface {
+	Scan(dest ...interface{}) error
+}
+
+type Queries struct {
+	db DBTX
+}
+
+func New(db DBTX) *Queries {
+	return &Queries{db: db}
+}
+
+// Teams with an ID of 666 or more are organizers' test teams, they don't
+// count towards scores or solve counts.
+const TestTeamID = 666
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+)
+
+// Team API tokens let a team's own tools use the HTTP API. A request with a
+// token only ever gets the TeamQueries of the token's team, whose queries
+// all filter on that team: a token can't read another team's data.
+
+const createTeamToken = "INSERT INTO team_tokens (team_id, token_hash, user) VALUES (?, ?, ?)"
+
+// CreateTeamToken stores a token by its hex SHA-256, the token itself is
+// only shown to its creator.
+func (q *Queries) CreateTeamToken(ctx context.Context, teamID int, hash string, user string) error {
+	_, err := q.db.ExecContext(ctx, createTeamToken, teamID, hash, user)
+	return err
+}
+
+const revokeTeamTokens = "UPDATE team_tokens SET revoked=true WHERE team_id=? AND revoked=false"
+
+// RevokeTeamTokens revokes all the team's tokens and returns how many there
+// were.
+func (q *Queries) RevokeTeamTokens(ctx context.Context, teamID int) (int64, error) {
+	res, err := q.db.ExecContext(ctx, revokeTeamTokens, teamID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const teamToken = "SELECT id, team_id FROM team_tokens WHERE token_hash=? AND revoked=false"
+
+// ForToken returns the queries of the team owning the token (by its hex
+// SHA-256), or sql.ErrNoRows.
+func (q *Queries) ForToken(ctx context.Context, hash string) (*TeamQueries, error) {
+	t := &TeamQueries{q: q}
+	err := q.db.QueryRowContext(ctx, teamToken, hash).Scan(&t.tokenID, &t.teamID)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type TeamQueries struct {
+	q       *Queries
+	teamID  int
+	tokenID int
+}
+
+func (t *TeamQueries) TeamID() int  { return t.teamID }
+func (t *TeamQueries) TokenID() int { return t.tokenID }
+
+// Name returns the team's name, or sql.ErrNoRows if it hasn't started.
+func (t *TeamQueries) Name(ctx context.Context) (string, error) {
+	return t.q.TeamName(ctx, t.teamID)
+}
+
+type TeamSolve struct {
+	Event string `json:"event"`
+	Ts    string `json:"ts"`
+}
+
+const teamSolves = "SELECT event, ts FROM scoreboard WHERE team_id=? ORDER BY ts, event"
+
+func (t *TeamQueries) Solves(ctx context.Context) ([]TeamSolve, error) {
+	rows, err := t.q.db.QueryContext(ctx, teamSolves, t.teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	solves := []TeamSolve{}
+	for rows.Next() {
+		var s TeamSolve
+		err = rows.Scan(&s.Event, &s.Ts)
+		if err != nil {
+			return nil, err
+		}
+		solves = append(solves, s)
+	}
+	return solves, rows.Err()
+}
+
+type TeamAttempts struct {
+	Level int `json:"level"`
+	Count int `json:"count"`
+}
+
+const teamAttempts = "SELECT level, count FROM attempts WHERE team_id=? ORDER BY level"
+
+func (t *TeamQueries) Attempts(ctx context.Context) ([]TeamAttempts, error) {
+	rows, err := t.q.db.QueryContext(ctx, teamAttempts, t.teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attempts := []TeamAttempts{}
+	for rows.Next() {
+		var a TeamAttempts
+		err = rows.Scan(&a.Level, &a.Count)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
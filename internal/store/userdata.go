@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Queries for "mydata", which gives a user everything stored about them.
+
+type UserLog struct {
+	Event string `json:"event"`
+	Level *int   `json:"level,omitempty"`
+	Ts    string `json:"ts"`
+}
+
+const userLogs = "SELECT event, level, ts FROM logs WHERE user=? ORDER BY id"
+
+// UserLogs returns the user's submissions and other logged events.
+func (q *Queries) UserLogs(ctx context.Context, user string) ([]UserLog, error) {
+	rows, err := q.db.QueryContext(ctx, userLogs, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	logs := []UserLog{}
+	for rows.Next() {
+		var l UserLog
+		var level sql.NullInt64
+		err = rows.Scan(&l.Event, &level, &l.Ts)
+		if err != nil {
+			return nil, err
+		}
+		if level.Valid {
+			n := int(level.Int64)
+			l.Level = &n
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+type Preference struct {
+	Kind    string `json:"kind"`
+	Enabled bool   `json:"enabled"`
+}
+
+const userPreferences = "SELECT kind, enabled FROM preferences WHERE user=? ORDER BY kind"
+
+func (q *Queries) UserPreferences(ctx context.Context, user string) ([]Preference, error) {
+	rows, err := q.db.QueryContext(ctx, userPreferences, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	prefs := []Preference{}
+	for rows.Next() {
+		var p Preference
+		err = rows.Scan(&p.Kind, &p.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, rows.Err()
+}
+
+const userRoles = "SELECT role FROM roles WHERE user=? ORDER BY role"
+
+func (q *Queries) UserRoles(ctx context.Context, user string) ([]string, error) {
+	return q.strings(ctx, userRoles, user)
+}
+
+const isObserver = "SELECT COUNT(*) FROM observers WHERE user=?"
+
+func (q *Queries) IsObserver(ctx context.Context, user string) (bool, error) {
+	var count int
+	err := q.db.QueryRowContext(ctx, isObserver, user).Scan(&count)
+	return count > 0, err
+}
+
+type Registration struct {
+	Kind     string `json:"kind"`
+	TeamID   int    `json:"team_id,omitempty"`
+	TeamName string `json:"team_name,omitempty"`
+	Status   string `json:"status"`
+	Ts       string `json:"ts"`
+}
+
+const userRegistrations = "SELECT kind, team_id, team_name, status, ts FROM registrations WHERE user=? ORDER BY id"
+
+// UserRegistrations returns the user's invitations and registration requests.
+func (q *Queries) UserRegistrations(ctx context.Context, user string) ([]Registration, error) {
+	rows, err := q.db.QueryContext(ctx, userRegistrations, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	regs := []Registration{}
+	for rows.Next() {
+		var r Registration
+		err = rows.Scan(&r.Kind, &r.TeamID, &r.TeamName, &r.Status, &r.Ts)
+		if err != nil {
+			return nil, err
+		}
+		regs = append(regs, r)
+	}
+	return regs, rows.Err()
+}
+
+type TeamToken struct {
+	TeamID  int    `json:"team_id"`
+	Revoked bool   `json:"revoked"`
+	Ts      string `json:"ts"`
+}
+
+const userTeamTokens = "SELECT team_id, revoked, ts FROM team_tokens WHERE user=? ORDER BY id"
+
+// UserTeamTokens returns the API tokens the user created, without the
+// tokens.
+func (q *Queries) UserTeamTokens(ctx context.Context, user string) ([]TeamToken, error) {
+	rows, err := q.db.QueryContext(ctx, userTeamTokens, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []TeamToken{}
+	for rows.Next() {
+		var t TeamToken
+		err = rows.Scan(&t.TeamID, &t.Revoked, &t.Ts)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+type AuditMessage struct {
+	Channel  string `json:"channel"`
+	Text     string `json:"text"`
+	Received string `json:"received"`
+}
+
+const auditMessages = "SELECT channel, text, received FROM audit WHERE user=? ORDER BY id"
+
+// AuditMessages returns the raw messages of a Slack user ID.
+func (q *Queries) AuditMessages(ctx context.Context, userID string) ([]AuditMessage, error) {
+	rows, err := q.db.QueryContext(ctx, auditMessages, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []AuditMessage{}
+	for rows.Next() {
+		var m AuditMessage
+		err = rows.Scan(&m.Channel, &m.Text, &m.Received)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+const userAppeals = "SELECT reason FROM appeals WHERE user=? ORDER BY id"
+
+func (q *Queries) UserAppeals(ctx context.Context, user string) ([]string, error) {
+	return q.strings(ctx, userAppeals, user)
+}
+
+func (q *Queries) strings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var v string
+		err = rows.Scan(&v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+)
+
+// When a team starts, its members are invited to the public channel so they
+// see announcements and the countdown. Needs the channels:manage (or
+// groups:write for a private channel) scope.
+
+type responseConversationMembers struct {
+	Members          []string `json:"members"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+func channelMembers(ctx context.Context, config Config, channel string) (map[string]bool, error) {
+	members := map[string]bool{}
+	cursor := ""
+	for {
+		var resp responseConversationMembers
+		err := traceSlack(ctx, "conversations.members", func() error {
+			return callSlackAPI(config.SlackApiToken, "conversations.members", url.Values{"channel": {channel}, "limit": {"1000"}, "cursor": {cursor}}, &resp)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range resp.Members {
+			members[id] = true
+		}
+		cursor = resp.ResponseMetadata.NextCursor
+		if cursor == "" {
+			return members, nil
+		}
+	}
+}
+
+// inviteTeam invites the team's members who aren't in the public channel yet.
+func inviteTeam(ctx context.Context, config Config, db *sql.DB, teamID int) {
+	channel := getPublicChannel()
+	if !featureEnabled(db, "invites") || channel == "" {
+		return
+	}
+	usernames, err := teamMembers(ctx, db, teamID)
+	if err != nil {
+		logf(ctx, "inviteTeam: %s", err)
+		return
+	}
+	members, err := channelMembers(ctx, config, channel)
+	if err != nil {
+		logf(ctx, "inviteTeam: %s", err)
+		return
+	}
+
+	ids := []string{}
+	for _, username := range usernames {
+		id, err := resolveUsername(ctx, config, username)
+		if err != nil {
+			logf(ctx, "inviteTeam: %s", err)
+			continue
+		}
+		if !members[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	err = traceSlack(ctx, "conversations.invite", func() error {
+		return callSlackAPI(config.SlackApiToken, "conversations.invite", url.Values{"channel": {channel}, "users": {strings.Join(ids, ",")}}, nil)
+	})
+	if err != nil {
+		logf(ctx, "inviteTeam: %s", err)
+		return
+	}
+	logf(ctx, "inviteTeam: invited %d members of team %d", len(ids), teamID)
+}
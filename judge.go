@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// Judges (the judge role) can award discretionary points, e.g. for a creative
+// solution or a presentation round. Awards are stored in the awards table,
+// count towards the ranking and are shown separately on the scoreboard.
+
+var judgeCommands = []command{
+	{"award", 3, permJudge, doJudgeAward},
+}
+
+func doJudge(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if !runCommand(ctx, judgeCommands, config, db, ws, m, args) {
+		postError(ctx, ws, m.Channel, "usage: judge award <team> <points> <reason>", m.User)
+	}
+}
+
+// findTeam looks a team up by ID or by name.
+func findTeam(ctx context.Context, db *sql.DB, team string) (int, string, error) {
+	if id, err := strconv.Atoi(team); err == nil {
+		name, err := queries(db).TeamName(ctx, id)
+		return id, name, err
+	}
+	id, err := queries(db).TeamByName(ctx, team)
+	return id, team, err
+}
+
+// judge award <team> <points> <reason>. The team is everything before the
+// first number, so team names can have spaces.
+func doJudgeAward(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	i := 1
+	points := 0
+	for ; i < len(args)-1; i++ {
+		n, err := strconv.Atoi(args[i])
+		if err == nil {
+			points = n
+			break
+		}
+	}
+	if i == len(args)-1 || points == 0 {
+		postError(ctx, ws, m.Channel, "usage: judge award <team> <points> <reason>", m.User)
+		return
+	}
+	reason := strings.Join(args[i+1:], " ")
+
+	teamID, teamName, err := findTeam(ctx, db, strings.Join(args[:i], " "))
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, I don't know team %s.", escapeText(strings.Join(args[:i], " "))), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	_, err = dbExec(ctx, db, "INSERT INTO awards (team_id, points, reason, judge, ref) VALUES (?, ?, ?, ?, ?)", teamID, points, reason, u.username, correlationID(ctx))
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doJudgeAward: %s awarded %d points to team %d: %s", u.username, points, teamID, reason)
+	label := teamLabel(config, teamID, teamName)
+	postText(ws, m.Channel, fmt.Sprintf("Awarded %d points to team %s.", points, label))
+	announce(config, db, ws, fmt.Sprintf("The judges awarded %d points to team %s: %s", points, publicTeamLabel(config, teamID, teamName, time.Now()), escapeText(reason)))
+	checkLeadChange(ctx, config, db, ws)
+}
+
+// addAwards adds the points awarded before until (unless it's zero).
+func addAwards(ctx context.Context, db *sql.DB, tally *scoreTally, until time.Time) error {
+	query := "SELECT awards.team_id, teams.name, SUM(awards.points) FROM awards JOIN teams ON teams.id = awards.team_id WHERE awards.team_id < ?"
+	args := []interface{}{store.TestTeamID}
+	if !until.IsZero() {
+		query += " AND awards.ts < ?"
+		args = append(args, until.UTC())
+	}
+	query += " GROUP BY awards.team_id, teams.name"
+	rows, err := dbQuery(ctx, db, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var teamID, points int
+		var teamName string
+		err = rows.Scan(&teamID, &teamName, &points)
+		if err != nil {
+			return err
+		}
+		tally.team(teamID, teamName).awarded += points
+	}
+	return rows.Err()
+}
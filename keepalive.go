@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Without deadlines, a half-open websocket blocks getMessage forever. With
+// config.WebsocketTimeoutSeconds, the bot sends an RTM ping every third of the
+// timeout; Slack answers with a pong, so a connection which stays silent for
+// the whole timeout is dead and the bot reconnects. Writes get the same
+// deadline.
+
+var wsTimeout time.Duration
+
+func setWebsocketTimeout(config Config) {
+	wsTimeout = time.Duration(config.WebsocketTimeoutSeconds) * time.Second
+}
+
+type rtmPing struct {
+	Id   uint64 `json:"id"`
+	Type string `json:"type"`
+}
+
+// sendFrame writes v to the websocket as JSON, within wsTimeout.
+func sendFrame(ws *websocket.Conn, v interface{}) error {
+	if wsTimeout > 0 {
+		ws.SetWriteDeadline(time.Now().Add(wsTimeout))
+	}
+	recordTrafficJSON("rtm out", v)
+	return websocket.JSON.Send(ws, v)
+}
+
+// startPinging keeps the connection busy enough for the read deadline.
+// It pings the current connection, see reconnect.go.
+func startPinging() {
+	if wsTimeout <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(wsTimeout / 3)
+		for range ticker.C {
+			ws := currentRTMConn()
+			if ws == nil {
+				continue
+			}
+			err := sendFrame(ws, rtmPing{Id: atomic.AddUint64(&counter, 1), Type: "ping"})
+			if err != nil {
+				logf(context.Background(), "startPinging: %s", err)
+			}
+		}
+	}()
+}
+
+// isTimeout is true if err is a missed deadline, i.e. the connection is dead.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
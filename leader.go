@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Lead changes are announced at most once every
+// config.LeadChangeThrottleMinutes. A change which happens during that window
+// is announced when it expires, unless the lead flipped back in the meantime.
+
+type leaderState struct {
+	lock          sync.Mutex
+	announced     int // team ID, 0 if no team leads
+	lastAnnounced time.Time
+}
+
+var leader leaderState
+
+func leadChangeThrottle(config Config) time.Duration {
+	if config.LeadChangeThrottleMinutes <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(config.LeadChangeThrottleMinutes) * time.Minute
+}
+
+// currentLeader returns the team which is strictly ahead of everyone else,
+// or ok=false if there's a tie at the top or nobody scored.
+func currentLeader(scores []teamScores) (teamScores, bool) {
+	if len(scores) == 0 || scores[0].numFlags() == 0 {
+		return teamScores{}, false
+	}
+	if len(scores) > 1 && !ScoreList(scores).Less(1, 0) {
+		return teamScores{}, false
+	}
+	return scores[0], true
+}
+
+// loadLeader records whoever leads when the bot starts, which isn't a lead
+// change. On an empty board there's nobody, so the first team to take the
+// lead is announced.
+func loadLeader(ctx context.Context, config Config, db *sql.DB) error {
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		return err
+	}
+	leader.lock.Lock()
+	defer leader.lock.Unlock()
+	if top, ok := currentLeader(scores); ok {
+		leader.announced = top.teamID
+	}
+	return nil
+}
+
+// checkLeadChange is called after every correct flag and by the lead-change
+// job, which takes care of throttled announcements.
+func checkLeadChange(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		logf(ctx, "checkLeadChange: %s", err)
+		return
+	}
+	top, ok := currentLeader(scores)
+	if !ok {
+		return
+	}
+
+	leader.lock.Lock()
+	defer leader.lock.Unlock()
+
+	if top.teamID == leader.announced || time.Since(leader.lastAnnounced) < leadChangeThrottle(config) {
+		return
+	}
+	leader.announced = top.teamID
+	leader.lastAnnounced = time.Now()
+
+	label := publicTeamLabel(config, top.teamID, top.teamName, time.Now())
+	logf(ctx, "checkLeadChange: %s takes the lead", top.teamName)
+	announce(config, db, ws, renderLeadChange(label))
+	noteMajorEvent(fmt.Sprintf("Team %s takes the lead with %d flags", label, top.numFlags()))
+	go notifyPlayers(ctx, config, db, ws, "lead-changes", fmt.Sprintf("Team %s takes the lead with %d flags!", label, top.numFlags()))
+}
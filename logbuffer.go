@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// When hundreds of teams brute-force at once, inserting every incorrect guess
+// on its own keeps MySQL busy. With config.BatchIncorrectGuesses, incorrect
+// guesses for levels without an attempt limit or cooldown are buffered and
+// written in one multi-row insert every second, or right before a correct
+// flag is recorded so the logs stay in order. Other levels always write
+// synchronously since their rules depend on the count and the last try.
+
+type bufferedLog struct {
+	username string
+	event    string
+	level    int
+	teamID   int
+	ref      string
+	msgTs    string
+}
+
+var logBuffer []bufferedLog
+var logBufferLock sync.Mutex
+
+// logFlushLock serializes flushes, so that once flushLogBuffer returns every
+// guess buffered before the call is written. logBufferLock is only held to
+// swap the buffer, guesses keep coming in while a flush writes.
+var logFlushLock sync.Mutex
+
+func bufferIncorrectGuess(l bufferedLog) {
+	logBufferLock.Lock()
+	defer logBufferLock.Unlock()
+	logBuffer = append(logBuffer, l)
+}
+
+func flushLogBuffer(ctx context.Context, db *sql.DB) error {
+	logFlushLock.Lock()
+	defer logFlushLock.Unlock()
+	logBufferLock.Lock()
+	batch := logBuffer
+	logBuffer = nil
+	logBufferLock.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	written := 0
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		rows, err := dedupeLogs(ctx, tx, batch)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		type teamLevel struct{ teamID, level int }
+		attempts := map[teamLevel]int{}
+		placeholders := []string{}
+		args := []interface{}{}
+		for _, l := range rows {
+			attempts[teamLevel{l.teamID, l.level}]++
+			placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?)")
+			args = append(args, l.username, l.event, l.level, l.teamID, l.ref, msgTsValue(l.msgTs))
+		}
+		// Counters must exist before the logs are inserted, see lockAttempts.
+		for tl := range attempts {
+			_, err := lockAttempts(ctx, tx, tl.teamID, tl.level)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = dbExec(ctx, tx, "INSERT INTO logs (user, event, level, team_id, ref, msg_ts) VALUES "+strings.Join(placeholders, ", "), args...)
+		if err != nil {
+			return err
+		}
+		for tl, n := range attempts {
+			_, err = dbExec(ctx, tx, "UPDATE attempts SET count=count+? WHERE team_id=? AND level=?", n, tl.teamID, tl.level)
+			if err != nil {
+				return err
+			}
+		}
+		written = len(rows)
+		return nil
+	})
+	if err != nil {
+		// Keep the guesses for the next flush, ahead of the newer ones.
+		logBufferLock.Lock()
+		logBuffer = append(batch, logBuffer...)
+		logBufferLock.Unlock()
+		return err
+	}
+	logf(ctx, "flushLogBuffer: wrote %d incorrect guesses, skipped %d redelivered", written, len(batch)-written)
+	return nil
+}
+
+// dedupeLogs drops redelivered messages (same user and msg_ts) from batch,
+// whether they were redelivered while buffered or after being written.
+func dedupeLogs(ctx context.Context, tx *sql.Tx, batch []bufferedLog) ([]bufferedLog, error) {
+	type userTs struct{ username, msgTs string }
+	seen := map[userTs]bool{}
+	placeholders := []string{}
+	args := []interface{}{}
+	for _, l := range batch {
+		if l.msgTs != "" {
+			placeholders = append(placeholders, "?")
+			args = append(args, l.msgTs)
+		}
+	}
+	if len(args) > 0 {
+		rows, err := dbQuery(ctx, tx, "SELECT user, msg_ts FROM logs WHERE msg_ts IN ("+strings.Join(placeholders, ", ")+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var k userTs
+			err = rows.Scan(&k.username, &k.msgTs)
+			if err != nil {
+				return nil, err
+			}
+			seen[k] = true
+		}
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+	deduped := []bufferedLog{}
+	for _, l := range batch {
+		k := userTs{l.username, l.msgTs}
+		if l.msgTs != "" && seen[k] {
+			continue
+		}
+		seen[k] = true
+		deduped = append(deduped, l)
+	}
+	return deduped, nil
+}
+
+// flushLogBufferJob runs every second.
+func flushLogBufferJob(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if !config.BatchIncorrectGuesses {
+		return
+	}
+	err := flushLogBuffer(ctx, db)
+	if err != nil {
+		logf(ctx, "flushLogBuffer: %s", err)
+	}
+}
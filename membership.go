@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"golang.org/x/net/websocket"
+)
+
+// People joining the public channel get a DM with the help. If the bot is
+// removed from the public channel, announcements are turned off (they would
+// all fail) and the admins are told; they're turned back on when the bot is
+// invited again.
+
+const pausedByRemoval = "announcements-paused-by-removal"
+
+var membershipEvents = map[string]bool{
+	"member_joined_channel": true,
+	"member_left_channel":   true,
+	"channel_left":          true,
+	"group_left":            true,
+}
+
+func handleMembership(config Config, db *sql.DB, ws *websocket.Conn, m Message, botID string) {
+	if m.Channel != getPublicChannel() {
+		return
+	}
+	ctx := withCorrelationID(context.Background(), newCorrelationID())
+	switch {
+	case m.Type == "member_joined_channel" && m.User == botID:
+		rejoinedPublicChannel(ctx, config, db, ws)
+	case m.Type == "member_joined_channel":
+		welcomeMember(ctx, config, ws, m.User)
+	case m.Type == "member_left_channel" && m.User == botID, m.Type == "channel_left", m.Type == "group_left":
+		leftPublicChannel(ctx, config, db, ws)
+	}
+}
+
+func welcomeMember(ctx context.Context, config Config, ws *websocket.Conn, userToken string) {
+	u, err := resolveUser(ctx, config, userToken)
+	if err != nil {
+		logf(ctx, "welcomeMember: %s", err)
+		return
+	}
+	if u.privateChannel == "" {
+		logf(ctx, "welcomeMember: can't DM %s", u.username)
+		return
+	}
+	logf(ctx, "welcomeMember: %s", u.username)
+	err = dmWhenAwake(ctx, config, ws, u.username, tr(config, u, "welcome", "Welcome! Here is what I can do for you:")+"\n"+renderHelp(config, u))
+	if err != nil {
+		logf(ctx, "welcomeMember: %s", err)
+	}
+}
+
+func leftPublicChannel(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	logf(ctx, "leftPublicChannel: removed from %s", getPublicChannel())
+	if featureEnabled(db, "announcements") {
+		err := setFeature(ctx, db, "announcements", false)
+		if err == nil {
+			err = setBotState(ctx, db, pausedByRemoval, "1")
+		}
+		if err != nil {
+			logf(ctx, "leftPublicChannel: %s", err)
+		}
+	}
+	dmAdmins(ctx, config, db, ws, "I was removed from the public channel, so announcements are paused. Invite me back to resume them.")
+}
+
+func rejoinedPublicChannel(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	paused, err := getBotState(ctx, db, pausedByRemoval)
+	if err != nil {
+		logf(ctx, "rejoinedPublicChannel: %s", err)
+		return
+	}
+	if paused != "1" {
+		return
+	}
+	err = setBotState(ctx, db, pausedByRemoval, "")
+	if err == nil {
+		err = setFeature(ctx, db, "announcements", true)
+	}
+	if err != nil {
+		logf(ctx, "rejoinedPublicChannel: %s", err)
+		return
+	}
+	logf(ctx, "rejoinedPublicChannel: announcements resumed")
+	dmAdmins(ctx, config, db, ws, "I'm back in the public channel, announcements are resumed.")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+)
+
+// Schema changes are migrations: numbered lists of statements, written for
+// MySQL and translated by the dialect like schema. schema_migrations records
+// which ones were applied. The bot applies pending migrations on startup, so
+// a fresh database is set up on the first run; -migrate applies them and
+// exits. A migration which shipped must never change: add a new one (CREATE
+// TABLE or ALTER TABLE statements, which get the table prefix).
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+var migrations = []migration{
+	// CREATE TABLE IF NOT EXISTS, so databases set up before migrations
+	// existed just record it.
+	{1, "initial schema", schema},
+	{2, "registrations", []string{
+		"CREATE TABLE IF NOT EXISTS registrations (id int not null auto_increment primary key, user varchar(50) not null, kind varchar(10) not null, team_id int not null, team_name varchar(255) not null, status varchar(10) not null, ts datetime default now(), key (user))",
+	}},
+	{3, "team tokens", []string{
+		"CREATE TABLE IF NOT EXISTS team_tokens (id int not null auto_increment primary key, team_id int not null, token_hash char(64) not null, user varchar(50) not null, revoked bool not null default false, ts datetime default now(), unique key (token_hash), key (user))",
+	}},
+}
+
+const migrationsTable = "CREATE TABLE IF NOT EXISTS schema_migrations (version int not null primary key, name varchar(255) not null, applied datetime default now())"
+
+// execSchema runs a statement of a migration.
+func execSchema(ctx context.Context, db *sql.DB, stmt string) error {
+	// Prefixed first: CREATE INDEX names the table in ways Prefix doesn't
+	// rewrite.
+	for _, s := range dialect.Schema(store.Prefix(stmt, tablePrefix)) {
+		_, err := dbExec(ctx, db, s)
+		if err != nil {
+			return fmt.Errorf("%s: %s", s, err)
+		}
+	}
+	return nil
+}
+
+// pendingMigrations returns the migrations which weren't applied, in order.
+// It creates schema_migrations if needed.
+func pendingMigrations(ctx context.Context, db *sql.DB) ([]migration, error) {
+	rows, err := dbQuery(ctx, db, "SELECT version FROM schema_migrations")
+	if err != nil {
+		// Most likely a new database. Checking first means a database user
+		// without CREATE rights can run the bot once the schema is there.
+		err = execSchema(ctx, db, migrationsTable)
+		if err != nil {
+			return nil, err
+		}
+		rows, err = dbQuery(ctx, db, "SELECT version FROM schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	pending := []migration{}
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrate applies the pending migrations and returns how many there were.
+// MySQL commits each DDL statement, so a failed migration may be half
+// applied; its statements should be safe to run again.
+func migrate(ctx context.Context, db *sql.DB) (int, error) {
+	pending, err := pendingMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	for i, m := range pending {
+		for _, stmt := range m.statements {
+			err = execSchema(ctx, db, stmt)
+			if err != nil {
+				return i, fmt.Errorf("migration %d (%s): %s", m.version, m.name, err)
+			}
+		}
+		_, err = dbExec(ctx, db, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name)
+		if err != nil && !isDuplicateKey(err) {
+			return i, err
+		}
+		logf(ctx, "migrate: applied migration %d (%s)", m.version, m.name)
+	}
+	return len(pending), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// mydata DMs the caller a JSON file with everything the bot stores about
+// them. See also "admin purge-user" in privacy.go.
+
+type myData struct {
+	Username      string               `json:"username"`
+	SlackID       string               `json:"slack_id"`
+	Team          *int                 `json:"team,omitempty"`
+	TeamName      string               `json:"team_name,omitempty"`
+	Roles         []string             `json:"roles"`
+	Preferences   []store.Preference   `json:"preferences"`
+	Observer      bool                 `json:"observer"`
+	Logs          []store.UserLog      `json:"logs"`
+	Appeals       []string             `json:"appeals"`
+	Registrations []store.Registration `json:"registrations"`
+	TeamTokens    []store.TeamToken    `json:"team_tokens"`
+	Messages      []store.AuditMessage `json:"messages"`
+}
+
+func collectMyData(ctx context.Context, db *sql.DB, u user, id string) (myData, error) {
+	q := queries(db)
+	d := myData{Username: u.username, SlackID: id}
+	team, err := q.UserTeam(ctx, u.username)
+	switch {
+	case err == nil:
+		d.Team = &team
+		d.TeamName, err = q.TeamName(ctx, team)
+		if err != nil && err != sql.ErrNoRows {
+			return d, err
+		}
+	case err != sql.ErrNoRows:
+		return d, err
+	}
+	if d.Roles, err = q.UserRoles(ctx, u.username); err != nil {
+		return d, err
+	}
+	if d.Preferences, err = q.UserPreferences(ctx, u.username); err != nil {
+		return d, err
+	}
+	if d.Observer, err = q.IsObserver(ctx, u.username); err != nil {
+		return d, err
+	}
+	if d.Logs, err = q.UserLogs(ctx, u.username); err != nil {
+		return d, err
+	}
+	if d.Appeals, err = q.UserAppeals(ctx, u.username); err != nil {
+		return d, err
+	}
+	if d.Registrations, err = q.UserRegistrations(ctx, u.username); err != nil {
+		return d, err
+	}
+	if d.TeamTokens, err = q.UserTeamTokens(ctx, u.username); err != nil {
+		return d, err
+	}
+	d.Messages, err = q.AuditMessages(ctx, id)
+	return d, err
+}
+
+// mydata
+func doMyData(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	d, err := collectMyData(ctx, db, u, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	err = uploadFile(config, u.privateChannel, "mydata.json", "json", string(data))
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doMyData: sent %s their data", u.username)
+	if m.Channel != u.privateChannel {
+		postText(ws, m.Channel, "I sent you your data in a private message.")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Every proactive DM the bot sends (i.e. not a reply to a command) has a
+// kind, and goes through notifyUser which checks the user's preferences. The
+// preferences table only holds overrides of the defaults below.
+var notificationDefaults = map[string]bool{
+	"teammate-solves":    true,
+	"teammate-hints":     true,
+	"unlocks":            true,
+	"challenge-releases": true,
+	"nudges":             true,
+	"lead-changes":       false,
+	"ceremony":           true,
+	"outage-refunds":     true,
+}
+
+func wantsNotification(ctx context.Context, db *sql.DB, username string, kind string) (bool, error) {
+	var enabled bool
+	err := dbQueryRow(ctx, db, "SELECT enabled FROM preferences WHERE user=? AND kind=?", username, kind).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return notificationDefaults[kind], nil
+	}
+	return enabled, err
+}
+
+// notifyUser DMs username, unless they turned off this kind of notification.
+// Nothing is sent during quiet hours.
+func notifyUser(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, username string, kind string, text string) {
+	if inQuietHours(config, time.Now()) {
+		return
+	}
+	ok, err := wantsNotification(ctx, db, username, kind)
+	if err != nil {
+		logf(ctx, "notifyUser: %s", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	err = dmUsername(ctx, config, ws, username, text)
+	if err != nil {
+		logf(ctx, "notifyUser: %s: %s", username, err)
+	}
+}
+
+// notifyTeam notifies every member of a team except the one who caused the
+// notification (pass "" to notify everyone).
+func notifyTeam(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, teamID int, except string, kind string, text string) {
+	members, err := teamMembers(ctx, db, teamID)
+	if err != nil {
+		logf(ctx, "notifyTeam: %s", err)
+		return
+	}
+	for _, username := range members {
+		if username != except {
+			notifyUser(ctx, config, db, ws, username, kind, text)
+		}
+	}
+}
+
+// notifyPlayers notifies every user in the users table.
+func notifyPlayers(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, kind string, text string) {
+	rows, err := dbQuery(ctx, db, "SELECT user FROM users")
+	if err != nil {
+		logf(ctx, "notifyPlayers: %s", err)
+		return
+	}
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		err = rows.Scan(&username)
+		if err != nil {
+			logf(ctx, "notifyPlayers: %s", err)
+			rows.Close()
+			return
+		}
+		usernames = append(usernames, username)
+	}
+	rows.Close()
+
+	for _, username := range usernames {
+		notifyUser(ctx, config, db, ws, username, kind, text)
+	}
+}
+
+// notify [<kind> on|off]
+func doNotify(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	if len(args) == 0 {
+		kinds := []string{}
+		for kind := range notificationDefaults {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		lines := []string{}
+		for _, kind := range kinds {
+			ok, err := wantsNotification(ctx, db, u.username, kind)
+			if err != nil {
+				postInternalError(ctx, ws, m.Channel, err, m.User)
+				return
+			}
+			state := "off"
+			if ok {
+				state = "on"
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", kind, state))
+		}
+		postText(ws, m.Channel, strings.Join(lines, "\n"))
+		return
+	}
+
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		postError(ctx, ws, m.Channel, "usage: notify [<kind> on|off]", m.User)
+		return
+	}
+	kind := args[0]
+	if _, ok := notificationDefaults[kind]; !ok {
+		postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, I don't know about %s notifications.", escapeText(kind)), m.User)
+		return
+	}
+	enabled := args[1] == "on"
+	_, err = dbExec(ctx, db, "INSERT INTO preferences (user, kind, enabled) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE enabled=?", u.username, kind, enabled, enabled)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	postText(ws, m.Channel, fmt.Sprintf("Ok, %s notifications are %s.", kind, args[1]))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Observers (managers, judges, ...) aren't on a team but want to follow the
+// event. Major events are collected here and DMed to every observer as a
+// digest by the observer-digest job.
+
+var observerDigest []string
+var observerDigestLock sync.Mutex
+
+// noteMajorEvent queues text for the next observer digest.
+func noteMajorEvent(text string) {
+	observerDigestLock.Lock()
+	defer observerDigestLock.Unlock()
+	observerDigest = append(observerDigest, fmt.Sprintf("%s %s", time.Now().Format("15:04"), text))
+}
+
+// observe [off]
+func doObserve(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	if len(args) >= 1 && args[0] == "off" {
+		_, err = dbExec(ctx, db, "DELETE FROM observers WHERE user=?", u.username)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		postText(ws, m.Channel, "Ok, I'll stop sending you updates.")
+		return
+	}
+
+	_, err = dbExec(ctx, db, "INSERT INTO observers (user, channel) VALUES (?, ?) ON DUPLICATE KEY UPDATE channel=?", u.username, u.privateChannel, u.privateChannel)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doObserve: %s is now observing", u.username)
+	postText(ws, m.Channel, "Ok, I'll DM you a digest of first bloods, lead changes and the final results. Say `observe off` to stop.")
+}
+
+// sendObserverDigest runs as a job. It also queues the final results once
+// the event is over.
+func sendObserverDigest(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if currentEventState(config, db, time.Now()) == eventFinished {
+		sent, err := getBotState(ctx, db, "final_results_noted")
+		if err != nil {
+			logf(ctx, "sendObserverDigest: %s", err)
+		} else if sent == "" {
+			noteFinalResults(ctx, config, db)
+		}
+	}
+
+	if inQuietHours(config, time.Now()) {
+		return
+	}
+
+	observerDigestLock.Lock()
+	digest := observerDigest
+	observerDigest = nil
+	observerDigestLock.Unlock()
+	if len(digest) == 0 {
+		return
+	}
+
+	rows, err := dbQuery(ctx, db, "SELECT channel FROM observers")
+	if err != nil {
+		logf(ctx, "sendObserverDigest: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	text := "Since my last update:\n" + strings.Join(digest, "\n")
+	n := 0
+	for rows.Next() {
+		var channel string
+		err = rows.Scan(&channel)
+		if err != nil {
+			logf(ctx, "sendObserverDigest: %s", err)
+			return
+		}
+		postText(ws, channel, text)
+		n++
+	}
+	logf(ctx, "sendObserverDigest: sent %d events to %d observers", len(digest), n)
+}
+
+func noteFinalResults(ctx context.Context, config Config, db *sql.DB) {
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		logf(ctx, "noteFinalResults: %s", err)
+		return
+	}
+	lines := []string{"The CTF is over! Final results:"}
+	for i, s := range toStandings(config, scores) {
+		if i == 3 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", s.rank, s.withDecoration(fmt.Sprintf("Team %s (%s)", s.teamName, s.summary()))))
+	}
+	noteMajorEvent(strings.Join(lines, "\n"))
+	err = setBotState(ctx, db, "final_results_noted", "1")
+	if err != nil {
+		logf(ctx, "noteFinalResults: %s", err)
+	}
+}
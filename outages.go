@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The outages table records when a challenge was broken: automatically (see
+// servicechecks.go) or with "admin outage". Wrong guesses on its level during
+// an outage don't count as tries, both when counting them live and when
+// rebuilding the attempts table (see store.CountedAttempt).
+
+func startOutage(ctx context.Context, db *sql.DB, c Challenge, reason string) error {
+	return queries(db).StartOutage(ctx, c.ID, c.Level, reason)
+}
+
+func endOutage(ctx context.Context, db *sql.DB, c Challenge) error {
+	return queries(db).EndOutage(ctx, c.ID)
+}
+
+// admin outage <challenge id> <from> <until>
+func doAdminOutage(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	usage := "usage: admin outage <challenge id> <from> <until> (times like 2016-07-08T18:00:00Z)"
+	id, err := strconv.Atoi(args[0])
+	if err != nil || len(args) != 3 {
+		postError(ctx, ws, m.Channel, usage, m.User)
+		return
+	}
+	c, ok := challengeByEvent(fmt.Sprintf("flag %d", id))
+	if !ok {
+		postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, there is no challenge %d.", id), m.User)
+		return
+	}
+	from, err1 := time.Parse(time.RFC3339, args[1])
+	until, err2 := time.Parse(time.RFC3339, args[2])
+	if err1 != nil || err2 != nil || !from.Before(until) {
+		postError(ctx, ws, m.Channel, usage, m.User)
+		return
+	}
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	// Recount the level's attempts with the outage in place, the difference
+	// is what each team gets back.
+	refunds := map[int]int{}
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		q := queries(tx)
+		err := q.RecordOutage(ctx, c.ID, c.Level, from, until, "marked by "+u.username)
+		if err != nil {
+			return err
+		}
+		before, err := q.LevelAttempts(ctx, c.Level)
+		if err != nil {
+			return err
+		}
+		err = q.RecountAttempts(ctx, c.Level)
+		if err != nil {
+			return err
+		}
+		after, err := q.LevelAttempts(ctx, c.Level)
+		if err != nil {
+			return err
+		}
+		for teamID, count := range before {
+			if n := count - after[teamID]; n > 0 {
+				refunds[teamID] = n
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminOutage: %s marked challenge %d broken from %s to %s, %d teams refunded", u.username, c.ID, from, until, len(refunds))
+	postText(ws, m.Channel, fmt.Sprintf("Recorded the outage of %s. %d teams got tries back.", c.Title, len(refunds)))
+
+	for teamID, n := range refunds {
+		notifyTeam(ctx, config, db, ws, teamID, "", "outage-refunds", fmt.Sprintf("%s was broken for a while, so your team's %d wrong guesses on level %d in that time don't count: you got those tries back.", c.Title, n, c.Level))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// Messages which must not get lost if the bot restarts mid-command (solve
+// announcements, the reply telling a team their flag was accepted) are first
+// written to the outbox table, in the same transaction as the logs row they
+// relate to. They are marked as posted once sent; on startup,
+// reconcileOutbox sends whatever is left.
+
+const (
+	outboxReply    = "reply"    // text to channel
+	outboxAnnounce = "announce" // text to the public channel
+	outboxSolve    = "solve"    // see announceSolve, text is the team label
+	// text to channel, only shown to the user whose ID is in event. For
+	// users the bot can't DM, see DMUnavailable.
+	outboxEphemeral = "ephemeral"
+)
+
+type outboxItem struct {
+	id      int64
+	kind    string
+	channel string
+	text    string
+	event   string
+}
+
+// queueOutbox stores items, setting their ids. db is usually a transaction.
+func queueOutbox(ctx context.Context, db sqlConn, items []outboxItem) error {
+	for i := range items {
+		var err error
+		items[i].id, err = dbInsertID(ctx, db, "INSERT INTO outbox (kind, channel, text, event, ref) VALUES (?, ?, ?, ?, ?)",
+			items[i].kind, items[i].channel, items[i].text, items[i].event, correlationID(ctx))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordEvent inserts the log entry, updates the projections and queues
+// outbox. It must be called in a
+// transaction, see withTx.
+func recordEvent(ctx context.Context, tx *sql.Tx, outbox []outboxItem, log store.InsertLogParams) error {
+	err := queries(tx).InsertLog(ctx, log)
+	if err != nil {
+		return err
+	}
+	err = applyToProjections(ctx, tx, log)
+	if err != nil {
+		return err
+	}
+	return queueOutbox(ctx, tx, outbox)
+}
+
+func markPosted(ctx context.Context, db *sql.DB, id int64) {
+	_, err := dbExec(ctx, db, "UPDATE outbox SET posted=true WHERE id=?", id)
+	if err != nil {
+		logf(ctx, "markPosted: %s", err)
+	}
+}
+
+func deliverOutbox(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, items []outboxItem) {
+	for _, item := range items {
+		switch item.kind {
+		case outboxReply:
+			postText(ws, item.channel, item.text)
+		case outboxAnnounce:
+			announce(config, db, ws, item.text)
+		case outboxEphemeral:
+			err := postEphemeral(ctx, config, item.channel, item.event, item.text)
+			if err != nil {
+				logf(ctx, "deliverOutbox: %s", err)
+			}
+		case outboxSolve:
+			// Marked as posted by announceSolve, which may hold it for a digest.
+			announceSolve(ctx, config, db, ws, item.text, item.event, item.id)
+			updateTicker(ctx, config, db, ws, item.event)
+			continue
+		default:
+			logf(ctx, "deliverOutbox: unknown kind %s", item.kind)
+		}
+		markPosted(ctx, db, item.id)
+	}
+}
+
+// reconcileOutbox sends everything which was queued but never posted.
+func reconcileOutbox(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	rows, err := dbQuery(ctx, db, "SELECT id, kind, channel, text, event FROM outbox WHERE posted=false ORDER BY id")
+	if err != nil {
+		logf(ctx, "reconcileOutbox: %s", err)
+		return
+	}
+	items := []outboxItem{}
+	for rows.Next() {
+		var item outboxItem
+		err = rows.Scan(&item.id, &item.kind, &item.channel, &item.text, &item.event)
+		if err != nil {
+			logf(ctx, "reconcileOutbox: %s", err)
+			rows.Close()
+			return
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	if len(items) > 0 {
+		logf(ctx, "reconcileOutbox: sending %d messages queued before the restart", len(items))
+		deliverOutbox(ctx, config, db, ws, items)
+	}
+}
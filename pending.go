@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/net/websocket"
+)
+
+// When MySQL is unreachable, a flag which matches a challenge isn't lost: it's
+// appended to config.PendingFile (synced to disk before the user is told it
+// was received) and replayed through doValidate once the database is back.
+// Replays keep the original correlation ID and are judged, and logged, as of
+// the time the flag was received. Incorrect guesses aren't queued, the user
+// can just try again later.
+
+type pendingSubmission struct {
+	UserToken string    `json:"user_token"`
+	Channel   string    `json:"channel"`
+	MsgTs     string    `json:"msg_ts"`
+	Level     string    `json:"level"`
+	Flag      string    `json:"flag"`
+	Upload    bool      `json:"upload,omitempty"`
+	Ref       string    `json:"ref"`
+	Received  time.Time `json:"received"`
+}
+
+var pendingLock sync.Mutex
+
+type replayKey struct{}
+
+// withReplayOf marks ctx as replaying a submission received at t.
+func withReplayOf(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, replayKey{}, t)
+}
+
+func replayedAt(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(replayKey{}).(time.Time)
+	return t, ok
+}
+
+// submittedAt is when the message being handled was received.
+func submittedAt(ctx context.Context) time.Time {
+	if t, ok := replayedAt(ctx); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// dbUnavailable tells connection failures apart from errors a retry won't fix.
+func dbUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// queuePending saves the submission if err means the database is down and the
+// flag looks correct. It returns false if the caller should report err.
+func queuePending(ctx context.Context, config Config, ws *websocket.Conn, u user, userToken string, channel string, msgTs string, sLevel string, flag string, upload bool, err error) bool {
+	if config.PendingFile == "" || !dbUnavailable(err) {
+		return false
+	}
+	level, convErr := strconv.Atoi(sLevel)
+	if convErr != nil {
+		return false
+	}
+	_, ok := matchChallenge(level, flag, upload, submittedAt(ctx))
+	if !ok {
+		return false
+	}
+	s := pendingSubmission{
+		UserToken: userToken,
+		Channel:   channel,
+		MsgTs:     msgTs,
+		Level:     sLevel,
+		Flag:      flag,
+		Upload:    upload,
+		Ref:       correlationID(ctx),
+		Received:  submittedAt(ctx),
+	}
+	saveErr := appendPending(config.PendingFile, s)
+	if saveErr != nil {
+		logf(ctx, "queuePending: %s", saveErr)
+		return false
+	}
+	logf(ctx, "queuePending: database unavailable (%s), queued level %s for %s", err, sLevel, u.username)
+	if _, replaying := replayedAt(ctx); !replaying {
+		postText(ws, channel, tr(config, u, "validate.pending", "Received, pending confirmation: I can't reach the scoreboard right now, I'll confirm as soon as it's back. (receipt %s)", s.Ref))
+	}
+	return true
+}
+
+func appendPending(filename string, s pendingSubmission) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func readPending(filename string) ([]pendingSubmission, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var submissions []pendingSubmission
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s pendingSubmission
+		// A line cut short by a crash is skipped, it was never acknowledged.
+		if json.Unmarshal(scanner.Bytes(), &s) != nil {
+			continue
+		}
+		submissions = append(submissions, s)
+	}
+	return submissions, scanner.Err()
+}
+
+// replayPending runs every minute. The queue is moved aside before replaying,
+// so submissions failing again are queued afresh; if the bot dies halfway, the
+// next run replays the whole batch again and alreadyHandled skips what was
+// already recorded.
+func replayPending(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if config.PendingFile == "" {
+		return
+	}
+	replayFile := config.PendingFile + ".replay"
+	if _, err := os.Stat(replayFile); os.IsNotExist(err) {
+		if _, err := os.Stat(config.PendingFile); os.IsNotExist(err) {
+			return
+		}
+		if err := db.PingContext(ctx); err != nil {
+			return
+		}
+		pendingLock.Lock()
+		err = os.Rename(config.PendingFile, replayFile)
+		pendingLock.Unlock()
+		if err != nil {
+			logf(ctx, "replayPending: %s", err)
+			return
+		}
+	}
+
+	submissions, err := readPending(replayFile)
+	if err != nil {
+		logf(ctx, "replayPending: %s", err)
+		return
+	}
+	for _, s := range submissions {
+		replayCtx := withReplayOf(withCorrelationID(context.Background(), s.Ref), s.Received)
+		logf(replayCtx, "replayPending: submission from %s received %s", s.UserToken, s.Received.Format(time.RFC3339))
+		doValidate(replayCtx, config, db, ws, s.UserToken, s.Channel, s.MsgTs, s.Level, s.Flag, s.Upload)
+	}
+	err = os.Remove(replayFile)
+	if err != nil {
+		logf(ctx, "replayPending: %s", err)
+	}
+	logf(ctx, "replayPending: replayed %d submissions", len(submissions))
+}
+
+// backdateEvent moves a replayed solve back to when it was received.
+func backdateEvent(ctx context.Context, tx *sql.Tx, entry store.InsertLogParams, at time.Time) error {
+	_, err := dbExec(ctx, tx, "UPDATE logs SET ts=? WHERE user=? AND ref=? AND event=?", at.UTC(), entry.User, entry.Ref, entry.Event)
+	if err != nil {
+		return err
+	}
+	_, err = dbExec(ctx, tx, "UPDATE scoreboard SET ts=LEAST(ts, ?) WHERE team_id=? AND event=?", at.UTC(), entry.TeamID, entry.Event)
+	return err
+}
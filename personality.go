@@ -0,0 +1,40 @@
+package main
+
+// A personality pack rewords the bot's replies, on top of the message keys
+// used for translations (see i18n.go): a user's language catalog wins, then
+// the event's personality, then the plain English in the code. Pick one with
+// personality in config.json; playful (the bot's original voice) is the
+// default.
+
+var personalities = map[string]map[string]string{
+	"professional": {},
+	"playful": {
+		"validate.public":        "shush!",
+		"validate.level-too-low": "you give us too much credit for starting puzzle enumeration from 0; humans designed this, not chat bots",
+		"validate.no-such-level": "woaaaaah nelly! there's no such thing as puzzle %d!",
+		"validate.no-tries-left": "you've exhausted your %d tries! no points 4 u",
+	},
+	"pirate": {
+		"error.no-team":          "arr, ye be sailin' with no crew I know of.",
+		"error.unknown-command":  "arr, I can't make heads or tails of that.",
+		"error.not-allowed":      "avast! that be for the captain's eyes only.",
+		"start.already-started":  "belay that, %s of yer crew already set sail!",
+		"start.link":             "Here be yer treasure map: %s",
+		"validate.public":        "hush, matey! whisper yer flags in private.",
+		"validate.paused":        "the ship be anchored, no flags be taken right now.",
+		"validate.bad-level":     "%s be no puzzle number I know of",
+		"validate.level-too-low": "puzzles be numbered from 1, ye landlubber.",
+		"validate.no-such-level": "shiver me timbers! there be no puzzle %d!",
+		"validate.no-tries-left": "ye've used all %d tries, walk the plank!",
+		"validate.duplicate":     "ye (or a shipmate) already tried that one",
+		"validate.correct":       "Yo ho ho, ye found %s!",
+		"validate.incorrect":     "Arr, that be fool's gold.",
+	},
+}
+
+func personalityFor(config Config) map[string]string {
+	if p, ok := personalities[config.Personality]; ok {
+		return p
+	}
+	return personalities["playful"]
+}
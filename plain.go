@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Plain mode is a per-user preference (stored in the preferences table, kind
+// "plain") for screen readers: replies to the user avoid emoji, medals and
+// table art, e.g. the scoreboard uses plainRenderer whatever
+// scoreboard_style is.
+
+func prefersPlain(ctx context.Context, db *sql.DB, username string) (bool, error) {
+	var enabled bool
+	err := dbQueryRow(ctx, db, "SELECT enabled FROM preferences WHERE user=? AND kind='plain'", username).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// userPrefersPlain is prefersPlain for a Slack user ID.
+func userPrefersPlain(ctx context.Context, config Config, db *sql.DB, userToken string) (bool, error) {
+	u, err := resolveUser(ctx, config, userToken)
+	if err != nil {
+		return false, err
+	}
+	return prefersPlain(ctx, db, u.username)
+}
+
+// plain [on|off]
+func doPlain(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if len(args) == 0 {
+		ok, err := prefersPlain(ctx, db, u.username)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		state := "off"
+		if ok {
+			state = "on"
+		}
+		postText(ws, m.Channel, fmt.Sprintf("Plain mode is %s.", state))
+		return
+	}
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		postError(ctx, ws, m.Channel, "usage: plain [on|off]", m.User)
+		return
+	}
+	enabled := args[0] == "on"
+	_, err = dbExec(ctx, db, "INSERT INTO preferences (user, kind, enabled) VALUES (?, 'plain', ?) ON DUPLICATE KEY UPDATE enabled=?", u.username, enabled, enabled)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	postText(ws, m.Channel, fmt.Sprintf("Ok, plain mode is %s.", args[0]))
+}
+
+// plainRenderer writes one sentence per team, without decorations.
+type plainRenderer struct{}
+
+func (plainRenderer) render(page []standing) (string, []interface{}) {
+	lines := []string{}
+	for _, s := range page {
+		lines = append(lines, fmt.Sprintf("Rank %d: team %s, with %s.", s.rank, s.teamName, s.summary()))
+	}
+	return strings.Join(lines, "\n"), nil
+}
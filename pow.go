@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Challenges with proof_of_work make every guess on their level cost some
+// CPU, which throttles scripts brute-forcing a small flag space without
+// capping honest players' tries. "pow <level>" gives the player a random
+// challenge; they find a number n such that the SHA-256 of challenge + n
+// starts with proof_of_work zero bits, and add pow:<n> to their guess:
+//
+//	validate 3 flag{1234} pow:81234
+//
+// A challenge is good for one guess, for powTTL. Challenges are kept in
+// memory, per user and level: after a restart, players ask for a new one.
+
+const powTTL = 10 * time.Minute
+
+// Bits above this would take players hours.
+const maxProofOfWork = 32
+
+type powKey struct {
+	username string
+	level    int
+}
+
+type powChallenge struct {
+	challenge string
+	bits      int
+	expires   time.Time
+}
+
+var powChallenges = map[powKey]powChallenge{}
+var powLock sync.Mutex
+
+// issuePoW returns a new challenge for the user and level, replacing the
+// previous one.
+func issuePoW(username string, level int, bits int, now time.Time) (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	challenge := hex.EncodeToString(b)
+	powLock.Lock()
+	defer powLock.Unlock()
+	for k, c := range powChallenges {
+		if now.After(c.expires) {
+			delete(powChallenges, k)
+		}
+	}
+	powChallenges[powKey{username, level}] = powChallenge{challenge, bits, now.Add(powTTL)}
+	return challenge, nil
+}
+
+// checkPoW tells if nonce solves the user's challenge for the level, which
+// is used up either way.
+func checkPoW(username string, level int, nonce string, now time.Time) bool {
+	powLock.Lock()
+	c, ok := powChallenges[powKey{username, level}]
+	delete(powChallenges, powKey{username, level})
+	powLock.Unlock()
+	return ok && now.Before(c.expires) && nonce != "" && leadingZeroBits(sha256.Sum256([]byte(c.challenge+nonce))) >= c.bits
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// splitPoW separates "<flag> pow:<n>" into the flag and n ("" if missing).
+func splitPoW(flag string) (string, string) {
+	i := strings.LastIndex(flag, " pow:")
+	if i < 0 {
+		return flag, ""
+	}
+	return strings.TrimSpace(flag[:i]), flag[i+len(" pow:"):]
+}
+
+// powScript finds n for a challenge, for players without their own tooling.
+func powScript(challenge string, bits int) string {
+	return fmt.Sprintf(`python3 -c "import hashlib, itertools; print(next(n for n in itertools.count() if int.from_bytes(hashlib.sha256(b'%s' + str(n).encode()).digest(), 'big') >> %d == 0))"`, challenge, 256-bits)
+}
+
+// pow <level>
+func doPoW(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	level, err := parseLevel(args[0])
+	if err != nil || level > maxLevel() {
+		postError(ctx, ws, m.Channel, tr(config, u, "validate.bad-level", "%s is not a valid puzzle number", escapeText(args[0])), m.User)
+		return
+	}
+	bits := levelRules(level).pow
+	if bits == 0 {
+		postText(ws, m.Channel, tr(config, u, "pow.not-needed", "Level %d doesn't need a proof of work, just validate your flag.", level))
+		return
+	}
+	challenge, err := issuePoW(u.username, level, bits, time.Now())
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doPoW: %s got %s for level %d", u.username, challenge, level)
+	postText(ws, m.Channel, tr(config, u, "pow.issued", "Find a number n such that the SHA-256 of `%s` followed by n starts with %d zero bits, for example with\n```%s```\nthen send `validate %d <flag> pow:<n>` within %s. It is good for one guess.", challenge, bits, powScript(challenge, bits), level, formatWait(powTTL)))
+}
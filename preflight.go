@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// "admin preflight" is the rehearsal checklist: what the bot checks when it
+// starts, again, plus what the event itself needs (challenges ready for
+// players, releases inside the event window, someone to run it). Unlike
+// "admin doctor", every line passes or fails.
+
+type preflightCheck struct {
+	name string
+	run  func(ctx context.Context, config Config, db *sql.DB) error
+}
+
+var preflightChecks = []preflightCheck{
+	{"Database reachable", func(ctx context.Context, config Config, db *sql.DB) error {
+		return db.PingContext(ctx)
+	}},
+	{"Schema up to date", preflightSchema},
+	{"Slack token and scopes", preflightSlack},
+	{"Configuration", func(ctx context.Context, config Config, db *sql.DB) error {
+		return problemsError(doctorConfig(config, nil))
+	}},
+	{"Scoring self-test", preflightScoring},
+	{"Challenges have a flag and a description", preflightChallenges},
+	{"Releases fall inside the event window", preflightReleases},
+	{"Admins", preflightAdmins},
+	{"Public channel reachable", preflightChannel},
+}
+
+// problemsError joins problems into one error, nil if there are none.
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func preflightSchema(ctx context.Context, config Config, db *sql.DB) error {
+	missing, err := missingTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s, run -migrate", strings.Join(missing, ", "))
+	}
+	pending, err := pendingMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d migrations pending, run -migrate", len(pending))
+	}
+	return nil
+}
+
+func preflightSlack(ctx context.Context, config Config, db *sql.DB) error {
+	scopes, err := slackScopes(ctx, config)
+	if err != nil {
+		return err
+	}
+	if len(scopes) == 0 {
+		// Legacy tokens don't report them.
+		return nil
+	}
+	missing := []string{}
+	for _, s := range scopeUses {
+		if !scopes[s.scope] {
+			missing = append(missing, s.scope)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// preflightScoring checks the scoring invariants (see properties.go) on a
+// few random event logs.
+func preflightScoring(ctx context.Context, config Config, db *sql.DB) error {
+	seed := time.Now().UnixNano()
+	for i := int64(0); i < 20; i++ {
+		l := randomPropertyLog(seed + i)
+		if len(l.rows) == 0 {
+			continue
+		}
+		if problem := checkScoringProperties(l, rand.New(rand.NewSource(seed+i))); problem != "" {
+			return fmt.Errorf("%s (seed %d)", problem, seed+i)
+		}
+	}
+	return nil
+}
+
+func preflightChallenges(ctx context.Context, config Config, db *sql.DB) error {
+	if len(currentChallenges()) == 0 {
+		return errors.New("no challenges are defined")
+	}
+	problems := []string{}
+	for _, c := range currentChallenges() {
+		if c.Flag == "" && len(c.Flags) == 0 && c.FlagHash == "" && c.Upload == nil {
+			problems = append(problems, fmt.Sprintf("challenge %d has no flag", c.ID))
+		}
+		if strings.TrimSpace(c.Description) == "" {
+			problems = append(problems, fmt.Sprintf("challenge %d has no description", c.ID))
+		}
+	}
+	return problemsError(problems)
+}
+
+func preflightReleases(ctx context.Context, config Config, db *sql.DB) error {
+	if config.CtfStart.IsZero() || config.CtfEnd.IsZero() {
+		return errors.New("ctf_start and ctf_end aren't both set")
+	}
+	problems := []string{}
+	for _, c := range currentChallenges() {
+		if c.Release.IsZero() {
+			continue
+		}
+		if c.Release.Before(config.CtfStart) || !c.Release.Before(config.CtfEnd) {
+			problems = append(problems, fmt.Sprintf("challenge %d is released at %s", c.ID, c.Release.UTC().Format(time.RFC3339)))
+		}
+	}
+	return problemsError(problems)
+}
+
+func preflightAdmins(ctx context.Context, config Config, db *sql.DB) error {
+	admins, err := roleAdmins(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(admins) == 0 && len(config.AdminUserIDs) == 0 {
+		return errors.New("nobody has the admin role and admin_user_ids is empty")
+	}
+	return nil
+}
+
+func preflightChannel(ctx context.Context, config Config, db *sql.DB) error {
+	channel := getPublicChannel()
+	if channel == "" {
+		return errors.New("public_channel isn't resolved")
+	}
+	var resp struct {
+		Channel struct {
+			IsMember   bool `json:"is_member"`
+			IsArchived bool `json:"is_archived"`
+		} `json:"channel"`
+	}
+	err := traceSlack(ctx, "conversations.info", func() error {
+		return callSlackAPI(config.SlackApiToken, "conversations.info", url.Values{"channel": {channel}}, &resp)
+	})
+	switch {
+	case err != nil:
+		return err
+	case resp.Channel.IsArchived:
+		return errors.New("the channel is archived")
+	case !resp.Channel.IsMember:
+		return errors.New("the bot isn't in the channel")
+	}
+	return nil
+}
+
+// admin preflight
+func doAdminPreflight(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	lines := []string{}
+	passed := 0
+	for _, check := range preflightChecks {
+		err := check.run(ctx, config, db)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf(":x: %s: %s", check.name, err))
+			continue
+		}
+		passed++
+		lines = append(lines, ":white_check_mark: "+check.name)
+	}
+	logf(ctx, "doAdminPreflight: %d/%d checks passed", passed, len(preflightChecks))
+	header := fmt.Sprintf("Preflight: %d/%d checks passed", passed, len(preflightChecks))
+	postText(ws, m.Channel, header+"\n"+strings.Join(lines, "\n"))
+}
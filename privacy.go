@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The bot stores personal identifiers: Slack usernames and IDs, and what
+// people typed. With retention_days, everything tied to a player is purged
+// that many days after ctf_end (roles are kept, so organizers can still run
+// the bot). "admin purge-user" does the same for one user, for deletion
+// requests. Scores survive: logs keep their team, only the user is removed.
+
+// Each statement takes the username, or applies to everyone when the WHERE
+// clause is dropped (see purgeStatements).
+var purgeUserStatements = []string{
+	"DELETE FROM users WHERE user=?",
+	"DELETE FROM preferences WHERE user=?",
+	"DELETE FROM observers WHERE user=?",
+	"DELETE FROM registrations WHERE user=?",
+	"UPDATE team_tokens SET user='' WHERE user=?",
+	"UPDATE logs SET user=NULL WHERE user=?",
+	"UPDATE appeals SET user='', reason='' WHERE user=?",
+	"UPDATE easter_eggs SET user='' WHERE user=?",
+}
+
+func purgeStatements() []string {
+	stmts := []string{"DELETE FROM audit"}
+	for _, stmt := range purgeUserStatements {
+		stmts = append(stmts, strings.TrimSuffix(stmt, " WHERE user=?"))
+	}
+	return stmts
+}
+
+// forgetUsers drops the cached Slack users.
+func forgetUsers() {
+	userCacheLock.Lock()
+	userCache = make(map[string]user)
+	userCacheLock.Unlock()
+	userIDCacheLock.Lock()
+	userIDCache = nil
+	userIDCacheLock.Unlock()
+}
+
+// purgeUser deletes what's stored about username. id is their Slack user ID,
+// for the audit table, or "" if unknown.
+func purgeUser(ctx context.Context, db *sql.DB, username string, id string) error {
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range purgeUserStatements {
+			_, err := dbExec(ctx, tx, stmt, username)
+			if err != nil {
+				return err
+			}
+		}
+		if id == "" {
+			return nil
+		}
+		_, err := dbExec(ctx, tx, "DELETE FROM audit WHERE user=?", id)
+		return err
+	})
+	if err == nil {
+		forgetUsers()
+	}
+	return err
+}
+
+// admin purge-user <username>
+func doAdminPurgeUser(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	username := strings.TrimPrefix(args[0], "@")
+	id, err := resolveUsername(ctx, config, username)
+	if err != nil {
+		// They may have left the workspace, their audit rows stay.
+		logf(ctx, "doAdminPurgeUser: %s", err)
+		id = ""
+	}
+	err = purgeUser(ctx, db, username, id)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminPurgeUser: %s purged %s", m.User, username)
+	text := fmt.Sprintf("Deleted what I stored about %s.", escapeText(username))
+	if id == "" {
+		text += " I couldn't find their Slack ID, so their raw messages are kept until the audit retention runs out."
+	}
+	postText(ws, m.Channel, text)
+}
+
+// purgePersonalData is a job, which runs once retention_days after ctf_end.
+func purgePersonalData(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if config.RetentionDays <= 0 || config.CtfEnd.IsZero() {
+		return
+	}
+	if time.Now().Before(config.CtfEnd.AddDate(0, 0, config.RetentionDays)) {
+		return
+	}
+	done, err := getBotState(ctx, db, "personal_data_purged")
+	if err != nil {
+		logf(ctx, "purgePersonalData: %s", err)
+		return
+	}
+	if done != "" {
+		return
+	}
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range purgeStatements() {
+			_, err := dbExec(ctx, tx, stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logf(ctx, "purgePersonalData: %s", err)
+		return
+	}
+	forgetUsers()
+	err = setBotState(ctx, db, "personal_data_purged", time.Now().Format(time.RFC3339))
+	if err != nil {
+		logf(ctx, "purgePersonalData: %s", err)
+	}
+	logf(ctx, "purgePersonalData: done")
+}
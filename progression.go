@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// With config.Progression, a team only sees the challenges of a level once it
+// solved one of the level before (the first level is open from the start):
+// "challenges" leaves the others out and "validate" refuses them. The first
+// solve on a level DMs the solver and their teammates the challenges it
+// unlocked, with their url: a Go template like puzzle_link, so each level can
+// have its own link.
+
+// previousLevel returns the highest level below level, 0 if there is none.
+func previousLevel(level int) int {
+	previous := 0
+	for _, c := range currentChallenges() {
+		if c.Level < level && c.Level > previous {
+			previous = c.Level
+		}
+	}
+	return previous
+}
+
+// solvedLevels returns the levels of the challenges in solved (events).
+func solvedLevels(solved map[string]bool) map[int]bool {
+	levels := map[int]bool{}
+	for event := range solved {
+		if c, ok := challengeByEvent(event); ok {
+			levels[c.Level] = true
+		}
+	}
+	return levels
+}
+
+// levelUnlocked tells if a team which solved solved (events) can see level.
+func levelUnlocked(config Config, level int, solved map[string]bool) bool {
+	if !config.Progression {
+		return true
+	}
+	previous := previousLevel(level)
+	return previous == 0 || solvedLevels(solved)[previous]
+}
+
+// unlockedBy returns the released challenges a team gets by solving c, if
+// that's its first solve on c's level.
+func unlockedBy(config Config, c Challenge, solved map[string]bool, now time.Time) []Challenge {
+	if !config.Progression || solvedLevels(solved)[c.Level] {
+		return nil
+	}
+	unlocked := []Challenge{}
+	for _, next := range currentChallenges() {
+		if next.released(now) && previousLevel(next.Level) == c.Level {
+			unlocked = append(unlocked, next)
+		}
+	}
+	return unlocked
+}
+
+// challengeLink renders the challenge's url for a team, "" if it has none.
+func challengeLink(config Config, c Challenge, teamID int, teamName string) (string, error) {
+	if c.URL == "" {
+		return "", nil
+	}
+	return renderLink(config, "url", c.URL, teamID, teamName)
+}
+
+// describeUnlocked is the DM telling a team about the challenges it unlocked.
+func describeUnlocked(ctx context.Context, config Config, u user, cs []Challenge, teamID int, teamName string) string {
+	parts := []string{tr(config, u, "progression.unlocked", "You unlocked level %d!", cs[0].Level)}
+	for _, c := range cs {
+		parts = append(parts, describeChallengeFor(ctx, config, c, teamID, teamName))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// describeChallengeFor is describeChallenge followed by the team's link to
+// it, for DMs.
+func describeChallengeFor(ctx context.Context, config Config, c Challenge, teamID int, teamName string) string {
+	text := describeChallenge(c)
+	link, err := challengeLink(config, c, teamID, teamName)
+	if err != nil {
+		logf(ctx, "describeChallengeFor: challenge %d: %s", c.ID, err)
+		return text
+	}
+	if link != "" {
+		text += fmt.Sprintf("\n%s", link)
+	}
+	return text
+}
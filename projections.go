@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// The logs table is the source of truth. Everything else derived from it is a
+// projection, updated as events are recorded and rebuildable from scratch:
+// - scoreboard: one row per flag found by a team, read by computeScores.
+// - attempts: tries per team and level, see attempts.go.
+// Projections are rebuilt on startup and with "admin rebuild", e.g. after
+// fixing a scoring bug or editing logs by hand.
+
+// applyToProjections must be called in the transaction which inserts log.
+func applyToProjections(ctx context.Context, tx *sql.Tx, log store.InsertLogParams) error {
+	if !strings.HasPrefix(log.Event, "flag ") || !log.TeamID.Valid {
+		return nil
+	}
+	_, err := dbExec(ctx, tx, "INSERT IGNORE INTO scoreboard (team_id, event) VALUES (?, ?)", log.TeamID, log.Event)
+	return err
+}
+
+var rebuildStatements = []string{
+	"DELETE FROM scoreboard",
+	"INSERT INTO scoreboard (team_id, event, ts) SELECT team_id, event, MIN(ts) FROM logs WHERE event LIKE 'flag %' AND team_id IS NOT NULL GROUP BY team_id, event",
+	"DELETE FROM attempts",
+	"INSERT INTO attempts (team_id, level, count) SELECT team_id, level, COUNT(*) FROM logs WHERE team_id IS NOT NULL AND level IS NOT NULL AND " + store.CountedAttempt + " GROUP BY team_id, level",
+}
+
+func rebuildProjections(ctx context.Context, db *sql.DB) error {
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range rebuildStatements {
+			_, err := dbExec(ctx, tx, stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// admin rebuild
+func doAdminRebuild(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	err := rebuildProjections(ctx, db)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminRebuild: %s rebuilt the projections", m.User)
+	postText(ws, m.Channel, "Rebuilt the scoreboard and attempt counts from the logs.")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// checkScoringProperties checks invariants of the scoring engine
+// (scoreTally) on random event logs from generateFixture, with random points
+// and combos. TestScoringProperties runs it on many logs, "admin preflight"
+// on a few.
+//
+// The properties:
+// - the same log always gives the same standings, ties included
+// - one more solve never lowers a team's score, nor changes anyone else's
+// - voiding (removing) a solve never raises a team's score
+// - standings frozen at some time don't change as later events come in
+
+type propertyLog struct {
+	challenges []Challenge
+	combo      ComboConfig
+	rows       []fixtureRow
+}
+
+func randomPropertyLog(seed int64) propertyLog {
+	r := rand.New(rand.NewSource(seed))
+	challenges := []Challenge{}
+	for level := 1; level <= 8; level++ {
+		challenges = append(challenges, Challenge{ID: level, Level: level, Points: r.Intn(500) + 1})
+	}
+	combo := ComboConfig{}
+	if r.Intn(2) == 0 {
+		combo = ComboConfig{WindowMinutes: r.Intn(60) + 1, Bonus: r.Intn(50) + 1}
+	}
+	rows := generateFixture(fixtureParams{
+		Seed:     seed,
+		Teams:    r.Intn(30) + 2,
+		Levels:   8,
+		Start:    time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC),
+		Duration: time.Duration(r.Intn(12)+1) * time.Hour,
+	})
+	return propertyLog{challenges, combo, rows}
+}
+
+func (l propertyLog) tally(rows []fixtureRow) *scoreTally {
+	t := newScoreTally(l.challenges, l.combo)
+	for _, row := range rows {
+		t.add(row.TeamID, row.TeamName, row.Event, row.Ts)
+	}
+	return t
+}
+
+// standingsKey is what the scoreboard shows, in order.
+func standingsKey(scores []teamScores) string {
+	key := ""
+	for _, s := range scores {
+		key += fmt.Sprintf("%d:%d/%d ", s.teamID, s.total(), s.numFlags())
+	}
+	return key
+}
+
+func totals(scores []teamScores) map[int]int {
+	m := map[int]int{}
+	for _, s := range scores {
+		m[s.teamID] = s.total()
+	}
+	return m
+}
+
+func isSolve(row fixtureRow) bool {
+	var id int
+	_, err := fmt.Sscanf(row.Event, "flag %d", &id)
+	return err == nil
+}
+
+// checkScoringProperties returns the first property which doesn't hold for
+// the log, or "".
+func checkScoringProperties(l propertyLog, r *rand.Rand) string {
+	scores := l.tally(l.rows).scores()
+	if standingsKey(scores) != standingsKey(l.tally(l.rows).scores()) {
+		return "the same log gave different standings"
+	}
+	before := totals(scores)
+
+	// One more solve, after everything else.
+	teamID := r.Intn(len(scores)) + 1
+	extra := fixtureRow{TeamID: teamID, TeamName: fmt.Sprintf("team %d", teamID), Event: fmt.Sprintf("flag %d", r.Intn(8)+1), Ts: l.rows[len(l.rows)-1].Ts.Add(time.Minute)}
+	after := totals(l.tally(append(append([]fixtureRow{}, l.rows...), extra)).scores())
+	for id, total := range before {
+		if id == teamID && after[id] < total {
+			return fmt.Sprintf("team %d's score went from %d to %d after solving %s", id, total, after[id], extra.Event)
+		}
+		if id != teamID && after[id] != total {
+			return fmt.Sprintf("team %d's score went from %d to %d when team %d solved %s", id, total, after[id], teamID, extra.Event)
+		}
+	}
+
+	// Void a random solve.
+	solves := []int{}
+	for i, row := range l.rows {
+		if isSolve(row) {
+			solves = append(solves, i)
+		}
+	}
+	if len(solves) > 0 {
+		i := solves[r.Intn(len(solves))]
+		voided := append(append([]fixtureRow{}, l.rows[:i]...), l.rows[i+1:]...)
+		after := totals(l.tally(voided).scores())
+		for id, total := range after {
+			if total > before[id] {
+				return fmt.Sprintf("team %d's score went from %d to %d when %s was voided", id, before[id], total, l.rows[i].Event)
+			}
+		}
+	}
+
+	// Freeze halfway through: the snapshot taken then must not move as the
+	// tally goes on, and must match a tally of the events before the freeze.
+	freeze := len(l.rows) / 2
+	t := l.tally(l.rows[:freeze])
+	frozen := t.scores()
+	key := standingsKey(frozen)
+	for _, row := range l.rows[freeze:] {
+		t.add(row.TeamID, row.TeamName, row.Event, row.Ts)
+	}
+	t.scores()
+	if standingsKey(frozen) != key {
+		return "frozen standings changed as later events came in"
+	}
+	if key != standingsKey(l.tally(l.rows[:freeze]).scores()) {
+		return "frozen standings differ from a tally of the events before the freeze"
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+// Run
+//
+//	go test -run TestScoringProperties
+//
+// before and after changing how scores are computed. A failure prints the
+// seed of the log, -seed <seed> checks that log again.
+
+var propertiesSeed = flag.Int64("seed", 0, "only check the scoring properties on the event log of this seed")
+
+func checkSeed(t *testing.T, seed int64) bool {
+	l := randomPropertyLog(seed)
+	if len(l.rows) == 0 {
+		return true
+	}
+	if problem := checkScoringProperties(l, rand.New(rand.NewSource(seed))); problem != "" {
+		t.Errorf("%s (seed %d)", problem, seed)
+		return false
+	}
+	return true
+}
+
+func TestScoringProperties(t *testing.T) {
+	if *propertiesSeed != 0 {
+		checkSeed(t, *propertiesSeed)
+		return
+	}
+	err := quick.Check(func(seed int64) bool { return checkSeed(t, seed) }, &quick.Config{MaxCount: 200})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// puzzle_link is a Go template, rendered for each team by start, like the url
+// of challenges (see progression.go). A plain URL renders as itself. .TeamToken lets the puzzle site give each team its own
+// instance (and tell teams apart) without a list of tokens to keep in sync:
+// it's an HMAC of the team ID, so the site can recompute it from the same
+// puzzle_link_secret.
+type puzzleLinkData struct {
+	TeamID    int
+	TeamName  string // query-escaped
+	TeamToken string
+}
+
+func teamToken(config Config, teamID int) (string, error) {
+	if config.PuzzleLinkSecret == "" {
+		return "", fmt.Errorf("a link uses .TeamToken but puzzle_link_secret isn't set")
+	}
+	mac := hmac.New(sha256.New, []byte(config.PuzzleLinkSecret))
+	mac.Write([]byte(strconv.Itoa(teamID)))
+	return hex.EncodeToString(mac.Sum(nil))[:32], nil
+}
+
+func puzzleLink(config Config, teamID int, teamName string) (string, error) {
+	return renderLink(config, "puzzle_link", config.PuzzleLink, teamID, teamName)
+}
+
+func renderLink(config Config, name string, link string, teamID int, teamName string) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(link)
+	if err != nil {
+		return "", err
+	}
+	data := puzzleLinkData{TeamID: teamID, TeamName: url.QueryEscape(teamName)}
+	if strings.Contains(link, ".TeamToken") {
+		data.TeamToken, err = teamToken(config, teamID)
+		if err != nil {
+			return "", err
+		}
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// During quiet hours the bot doesn't send proactive DMs and holds back public
+// digests, welcomes and invites (they are posted once quiet hours are over). Submissions are still
+// accepted and logged.
+type QuietHoursConfig struct {
+	Start    string `json:"start"`    // e.g. 23:00
+	End      string `json:"end"`      // e.g. 07:00
+	Timezone string `json:"timezone"` // e.g. Europe/Paris, defaults to UTC
+}
+
+// minutes since midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// loadTimezone returns UTC for "".
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+func inQuietHours(config Config, now time.Time) bool {
+	q := config.QuietHours
+	if q.Start == "" || q.End == "" {
+		return false
+	}
+	start, err := parseClock(q.Start)
+	if err != nil {
+		log.Printf("inQuietHours: %s", err)
+		return false
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		log.Printf("inQuietHours: %s", err)
+		return false
+	}
+	loc, err := loadTimezone(q.Timezone)
+	if err != nil {
+		log.Printf("inQuietHours: %s", err)
+		return false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// Spans midnight.
+	return minute >= start || minute < end
+}
+
+// DMs which must not be lost, such as invites and welcomes, are held during
+// quiet hours instead, and sent once they are over.
+type heldDM struct {
+	username string
+	text     string
+}
+
+var heldDMs []heldDM
+var heldDMsLock sync.Mutex
+
+// dmWhenAwake DMs username now, or once quiet hours are over.
+func dmWhenAwake(ctx context.Context, config Config, ws *websocket.Conn, username string, text string) error {
+	if inQuietHours(config, time.Now()) {
+		heldDMsLock.Lock()
+		heldDMs = append(heldDMs, heldDM{username: username, text: text})
+		heldDMsLock.Unlock()
+		logf(ctx, "dmWhenAwake: holding a DM to %s until quiet hours are over", username)
+		return nil
+	}
+	return dmUsername(ctx, config, ws, username, text)
+}
+
+// sendHeldDMs is a job.
+func sendHeldDMs(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if inQuietHours(config, time.Now()) {
+		return
+	}
+	heldDMsLock.Lock()
+	held := heldDMs
+	heldDMs = nil
+	heldDMsLock.Unlock()
+	for _, dm := range held {
+		err := dmUsername(ctx, config, ws, dm.username, dm.text)
+		if err != nil {
+			logf(ctx, "sendHeldDMs: %s: %s", dm.username, err)
+		}
+	}
+}
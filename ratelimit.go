@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// On top of a level's max_attempts and cooldown_seconds, config.RateLimit
+// throttles scripted guessing: each team has a token bucket per level holding
+// burst guesses, refilled at per_minute. A guess finding the bucket empty is
+// turned down without counting as a try or being logged. A team throttled
+// alert_after times in a row is likely running a script: it's logged and the
+// admins get a DM (at most every rateLimitAlertEvery per team). The buckets
+// are in memory, a restart fills them up.
+
+type RateLimitConfig struct {
+	// Guesses in a row, 0 disables the limiter. per_minute must be set
+	// with it.
+	Burst     int     `json:"burst"`
+	PerMinute float64 `json:"per_minute"`
+	// Default 5.
+	AlertAfter int `json:"alert_after"`
+}
+
+const rateLimitAlertEvery = 10 * time.Minute
+
+func (c RateLimitConfig) alertAfter() int {
+	if c.AlertAfter <= 0 {
+		return 5
+	}
+	return c.AlertAfter
+}
+
+type rateLimitKey struct {
+	teamID int
+	level  int
+}
+
+type guessBucket struct {
+	tokens    float64
+	updated   time.Time
+	throttled int
+	alerted   time.Time
+}
+
+var guessBuckets = map[rateLimitKey]*guessBucket{}
+var guessBucketsLock sync.Mutex
+
+// takeGuessToken uses one of the team's guesses on level. If there is none
+// left, it returns how long until there is, and whether the admins should be
+// told about the burst.
+func takeGuessToken(config RateLimitConfig, teamID int, level int, now time.Time) (ok bool, wait time.Duration, alert bool) {
+	if config.Burst <= 0 {
+		return true, 0, false
+	}
+	guessBucketsLock.Lock()
+	defer guessBucketsLock.Unlock()
+	key := rateLimitKey{teamID, level}
+	b, found := guessBuckets[key]
+	if !found {
+		b = &guessBucket{tokens: float64(config.Burst), updated: now}
+		guessBuckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Minutes() * config.PerMinute
+	if b.tokens > float64(config.Burst) {
+		b.tokens = float64(config.Burst)
+	}
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.throttled = 0
+		return true, 0, false
+	}
+	b.throttled++
+	wait = time.Duration((1 - b.tokens) / config.PerMinute * float64(time.Minute))
+	if b.throttled >= config.alertAfter() && now.Sub(b.alerted) >= rateLimitAlertEvery {
+		b.alerted = now
+		alert = true
+	}
+	return false, wait, alert
+}
+
+// throttleGuess is false if the guess must be turned down, in which case the
+// user was told.
+func throttleGuess(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, u user, userToken string, channel string, teamID int, team string, level int) bool {
+	ok, wait, alert := takeGuessToken(config.RateLimit, teamID, level, time.Now())
+	if ok {
+		return true
+	}
+	if alert {
+		logf(ctx, "throttleGuess: suspicious burst from team %d on level %d (%s)", teamID, level, u.username)
+		dmAdmins(ctx, config, db, ws, fmt.Sprintf(":rotating_light: Team %s had %d guesses in a row on level %d turned down by the rate limit, the last one from %s. Scripted guessing?", teamLabel(config, teamID, team), config.RateLimit.alertAfter(), level, u.username))
+	}
+	postError(ctx, ws, channel, tr(config, u, "validate.rate-limited", "slow down! Wait %s before guessing level %d again.", formatWait(wait), level), userToken)
+	return false
+}
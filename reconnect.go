@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The RTM websocket breaks now and then (network blips, Slack moving the bot
+// to another server). The main loop then reconnects with a backoff of up to
+// 30 seconds, like runSocketMode, and resolves the bot's ID and
+// public_channel again, as they may have changed meanwhile.
+//
+// Handlers keep passing around the connection the bot started with; it only
+// says the bot uses RTM. postMessage writes to the current connection, and
+// while there is none, messages wait in rtmBuffer (or go through the Web API
+// when webAPIFallbackToken is set) and are sent once the bot is back.
+
+var rtmConn *websocket.Conn
+var rtmBuffer []Message
+var rtmLock sync.Mutex
+
+// Past this many messages waiting for a connection, the oldest are dropped.
+const maxRTMBuffer = 500
+
+func setRTMConn(ws *websocket.Conn) {
+	rtmLock.Lock()
+	defer rtmLock.Unlock()
+	rtmConn = ws
+}
+
+// currentRTMConn returns the connection to write to, nil while reconnecting.
+func currentRTMConn() *websocket.Conn {
+	rtmLock.Lock()
+	defer rtmLock.Unlock()
+	return rtmConn
+}
+
+func bufferRTM(m Message) {
+	rtmLock.Lock()
+	defer rtmLock.Unlock()
+	if len(rtmBuffer) >= maxRTMBuffer {
+		log.Printf("bufferRTM: buffer full, dropping a message to %s", rtmBuffer[0].Channel)
+		rtmBuffer = rtmBuffer[1:]
+	}
+	rtmBuffer = append(rtmBuffer, m)
+}
+
+// resumeRTM sends the messages which waited for a connection, in order, then
+// makes ws the current connection.
+func resumeRTM(ws *websocket.Conn) error {
+	rtmLock.Lock()
+	defer rtmLock.Unlock()
+	if len(rtmBuffer) > 0 {
+		log.Printf("resumeRTM: sending %d messages written while disconnected", len(rtmBuffer))
+	}
+	for len(rtmBuffer) > 0 {
+		err := sendFrame(ws, rtmBuffer[0])
+		if err != nil {
+			return err
+		}
+		rtmBuffer = rtmBuffer[1:]
+	}
+	rtmConn = ws
+	return nil
+}
+
+// connectionLost tells a broken connection apart from a frame which didn't
+// parse.
+func connectionLost(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr)
+}
+
+// reconnectRTM replaces the broken connection ws, retrying until it works,
+// and returns the new one and the bot's ID.
+func reconnectRTM(ctx context.Context, config Config, ws *websocket.Conn, botID string) (*websocket.Conn, string) {
+	setRTMConn(nil)
+	ws.Close()
+	backoff := time.Second
+	for {
+		newWS, id, err := slackConnect(config.SlackApiToken)
+		if err == nil {
+			err = resumeRTM(newWS)
+			if err != nil {
+				newWS.Close()
+			}
+		}
+		if err != nil {
+			logf(ctx, "reconnectRTM: %s, retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		slackConnectedAt = time.Now()
+		logf(ctx, "reconnectRTM: connected")
+		if id != botID {
+			logf(ctx, "reconnectRTM: the bot is now %s (was %s)", id, botID)
+		}
+
+		// Renames while disconnected were missed.
+		forgetChannelIDs()
+		channel, err := resolveChannel(config)
+		if err != nil {
+			logf(ctx, "reconnectRTM: keeping public channel %s: %s", getPublicChannel(), err)
+		} else if channel != getPublicChannel() {
+			logf(ctx, "reconnectRTM: public channel is now %s (was %s)", channel, getPublicChannel())
+			setPublicChannel(channel)
+		}
+		return newWS, id
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// With registration.enabled, players form teams from Slack instead of the
+// organizers filling the users table:
+// - register <team name> creates a team with the player on it
+// - invite @user, by a team member, lets someone join
+// - join <team> accepts an invitation
+// Then "start" (without a name) starts the team as usual. Teams get the
+// next ID after the existing ones, below the test team.
+//
+// With registration.approval, register and join wait for "admin registrations
+// approve <id>". The registrations table holds invitations and requests
+// waiting for approval, see RegistrationConfig.
+
+type RegistrationConfig struct {
+	Enabled bool `json:"enabled"`
+	// Players per team, 0 for no limit.
+	MaxTeamSize int  `json:"max_team_size"`
+	Approval    bool `json:"approval"`
+}
+
+const (
+	registrationInvite   = "invite"   // team_id invited user
+	registrationJoin     = "join"     // user asked to join team_id
+	registrationRegister = "register" // user asked to create team_name
+)
+
+// checkTeamSize returns a UserError if the team is full.
+func checkTeamSize(ctx context.Context, config Config, u user, db sqlConn, teamID int, teamName string) error {
+	if config.Registration.MaxTeamSize == 0 {
+		return nil
+	}
+	n, err := queries(db).TeamSize(ctx, teamID)
+	if err != nil {
+		return err
+	}
+	if n >= config.Registration.MaxTeamSize {
+		return &UserError{tr(config, u, "join.full", "team %s is full (%d players).", escapeText(teamName), config.Registration.MaxTeamSize)}
+	}
+	return nil
+}
+
+// registeredTeam creates a team called name with username on it. Errors are
+// in u's language.
+func registeredTeam(ctx context.Context, config Config, u user, tx *sql.Tx, username string, name string) (int, error) {
+	id, err := queries(tx).NextTeamID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if id >= store.TestTeamID {
+		return 0, &UserError{tr(config, u, "register.no-room", "sorry, there is no room for more teams, ask an organizer.")}
+	}
+	err = queries(tx).CreateTeam(ctx, id, name)
+	if err != nil {
+		return 0, err
+	}
+	return id, queries(tx).SetUserTeam(ctx, username, id)
+}
+
+// checkNewPlayer returns a UserError unless registration is on and the user
+// isn't on a team yet.
+func checkNewPlayer(ctx context.Context, config Config, u user, db sqlConn) error {
+	if !config.Registration.Enabled {
+		return &UserError{tr(config, u, "register.off", "sorry, teams are set up by the organizers.")}
+	}
+	on, err := queries(db).UserOnTeam(ctx, u.username)
+	if err != nil {
+		return err
+	}
+	if on {
+		return &UserError{tr(config, u, "register.on-team", "you are already on a team.")}
+	}
+	return nil
+}
+
+// register <team name>
+func doRegister(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	name := strings.Join(args, " ")
+	err = validateTeamName(config, u, name)
+	if err != nil {
+		postError(ctx, ws, m.Channel, err.Error(), m.User)
+		return
+	}
+	var requestID int64
+	var teamID int
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		err := checkNewPlayer(ctx, config, u, tx)
+		if err != nil {
+			return err
+		}
+		taken, err := queries(tx).TeamNameTaken(ctx, name)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return &UserError{tr(config, u, "register.taken", "there is already a team called %s.", escapeText(name))}
+		}
+		if config.Registration.Approval {
+			requestID, err = queries(tx).CreateRegistration(ctx, u.username, registrationRegister, 0, name)
+			return err
+		}
+		teamID, err = registeredTeam(ctx, config, u, tx, u.username, name)
+		return err
+	})
+	if isDuplicateKey(err) {
+		// Someone registered at the same time and got the same ID.
+		err = &UserError{tr(config, u, "register.retry", "sorry, please try again.")}
+	}
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	if requestID != 0 {
+		logf(ctx, "doRegister: %s asked to register %s (request %d)", u.username, name, requestID)
+		dmAdmins(ctx, config, db, ws, fmt.Sprintf("%s wants to register team %s: `admin registrations approve %d` or `admin registrations reject %d`.", u.username, escapeText(name), requestID, requestID))
+		postText(ws, m.Channel, tr(config, u, "register.pending", "Thanks! An organizer will look at team %s soon.", escapeText(name)))
+		return
+	}
+	logf(ctx, "doRegister: %s registered %s (team %d)", u.username, name, teamID)
+	postText(ws, m.Channel, tr(config, u, "register.done", "Team %s is registered. Invite your teammates with `invite @user`, then `start` when you're ready.", teamLabel(config, teamID, name)))
+}
+
+// invite <user>
+func doInvite(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if !config.Registration.Enabled {
+		reportError(ctx, config, ws, m.Channel, u, checkNewPlayer(ctx, config, u, db), m.User)
+		return
+	}
+	row, err := userTeam(ctx, db, u.username)
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	invitee, err := userArg(ctx, config, args[0])
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		on, err := queries(tx).UserOnTeam(ctx, invitee)
+		if err != nil {
+			return err
+		}
+		if on {
+			return &UserError{tr(config, u, "invite.on-team", "%s is already on a team.", escapeText(invitee))}
+		}
+		err = checkTeamSize(ctx, config, u, tx, row.ID, row.Name)
+		if err != nil {
+			return err
+		}
+		_, err = queries(tx).CreateRegistration(ctx, invitee, registrationInvite, row.ID, "")
+		return err
+	})
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	logf(ctx, "doInvite: %s invited %s to team %d", u.username, invitee, row.ID)
+	err = dmWhenAwake(ctx, config, ws, invitee, tr(config, userNamed(ctx, config, invitee), "invite.dm", "%s invited you to team %s. Reply `join %s` to accept.", u.username, teamLabel(config, row.ID, row.Name), escapeText(row.Name)))
+	if err != nil {
+		logf(ctx, "doInvite: %s", err)
+		postText(ws, m.Channel, tr(config, u, "invite.no-dm", "Invited %s, but I couldn't tell them: ask them to send me `join %s`.", escapeText(invitee), escapeText(row.Name)))
+		return
+	}
+	postText(ws, m.Channel, tr(config, u, "invite.sent", "Invited %s, who can now join your team.", escapeText(invitee)))
+}
+
+// join <team>
+func doJoin(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	teamID, teamName, err := findTeam(ctx, db, strings.Join(args, " "))
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, tr(config, u, "join.unknown-team", "sorry, I don't know team %s.", escapeText(strings.Join(args, " "))), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	var requestID int64
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		err := checkNewPlayer(ctx, config, u, tx)
+		if err != nil {
+			return err
+		}
+		inviteID, err := queries(tx).PendingInvite(ctx, u.username, teamID)
+		if err == sql.ErrNoRows {
+			return &UserError{tr(config, u, "join.no-invite", "ask someone on team %s to invite you first.", escapeText(teamName))}
+		}
+		if err != nil {
+			return err
+		}
+		err = checkTeamSize(ctx, config, u, tx, teamID, teamName)
+		if err != nil {
+			return err
+		}
+		err = queries(tx).SetRegistrationStatus(ctx, inviteID, "used")
+		if err != nil {
+			return err
+		}
+		if config.Registration.Approval {
+			requestID, err = queries(tx).CreateRegistration(ctx, u.username, registrationJoin, teamID, "")
+			return err
+		}
+		return queries(tx).SetUserTeam(ctx, u.username, teamID)
+	})
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	if requestID != 0 {
+		logf(ctx, "doJoin: %s asked to join team %d (request %d)", u.username, teamID, requestID)
+		dmAdmins(ctx, config, db, ws, fmt.Sprintf("%s wants to join team %s: `admin registrations approve %d` or `admin registrations reject %d`.", u.username, escapeText(teamName), requestID, requestID))
+		postText(ws, m.Channel, tr(config, u, "join.pending", "Thanks! An organizer will add you to team %s soon.", escapeText(teamName)))
+		return
+	}
+	logf(ctx, "doJoin: %s joined team %d", u.username, teamID)
+	postText(ws, m.Channel, tr(config, u, "join.done", "Welcome to team %s!", teamLabel(config, teamID, teamName)))
+}
+
+// admin registrations [approve|reject <id>]
+func doAdminRegistrations(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if len(args) == 2 && (args[0] == "approve" || args[0] == "reject") {
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			postError(ctx, ws, m.Channel, "usage: admin registrations [approve|reject <id>]", m.User)
+			return
+		}
+		decideRegistration(ctx, config, db, ws, m, id, args[0] == "approve")
+		return
+	}
+	if len(args) != 0 {
+		postError(ctx, ws, m.Channel, "usage: admin registrations [approve|reject <id>]", m.User)
+		return
+	}
+
+	regs, err := queries(db).PendingRegistrations(ctx)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	lines := []string{}
+	for _, r := range regs {
+		if r.Kind == registrationRegister {
+			lines = append(lines, fmt.Sprintf("%d. %s wants to register team %s", r.ID, r.User, escapeText(r.TeamName)))
+		} else {
+			lines = append(lines, fmt.Sprintf("%d. %s wants to join team %s", r.ID, r.User, escapeText(r.Team)))
+		}
+	}
+	if len(lines) == 0 {
+		postText(ws, m.Channel, "No registrations are waiting.")
+		return
+	}
+	postText(ws, m.Channel, strings.Join(lines, "\n"))
+}
+
+func decideRegistration(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, id int64, approve bool) {
+	var username, kind, teamName string
+	var teamID int
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		r, err := queries(tx).LockPendingRegistration(ctx, id)
+		username, kind, teamID, teamName = r.User, r.Kind, r.TeamID, r.TeamName
+		if err == sql.ErrNoRows {
+			return &UserError{fmt.Sprintf("sorry, there is no pending registration %d.", id)}
+		}
+		if err != nil {
+			return err
+		}
+		status := "rejected"
+		if approve {
+			status = "approved"
+			on, err := queries(tx).UserOnTeam(ctx, username)
+			if err != nil {
+				return err
+			}
+			if on {
+				return &UserError{fmt.Sprintf("%s is already on a team, reject it.", username)}
+			}
+			switch kind {
+			case registrationRegister:
+				teamID, err = registeredTeam(ctx, config, user{}, tx, username, teamName)
+			case registrationJoin:
+				teamName, err = queries(tx).TeamName(ctx, teamID)
+				if err == nil {
+					err = checkTeamSize(ctx, config, user{}, tx, teamID, teamName)
+				}
+				if err == nil {
+					err = queries(tx).SetUserTeam(ctx, username, teamID)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return queries(tx).SetRegistrationStatus(ctx, id, status)
+	})
+	if isDuplicateKey(err) {
+		err = &UserError{"sorry, a team with that name or ID was created meanwhile, reject it."}
+	}
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, user{}, err, m.User)
+		return
+	}
+	logf(ctx, "decideRegistration: %s decided %d (approve: %t)", m.User, id, approve)
+	player := userNamed(ctx, config, username)
+	var text string
+	switch {
+	case !approve:
+		text = tr(config, player, "register.rejected", "Sorry, an organizer turned down your request.")
+	case kind == registrationRegister:
+		text = tr(config, player, "register.done", "Team %s is registered. Invite your teammates with `invite @user`, then `start` when you're ready.", teamLabel(config, teamID, teamName))
+	default:
+		text = tr(config, player, "join.done", "Welcome to team %s!", teamLabel(config, teamID, teamName))
+	}
+	err = dmUsername(ctx, config, ws, username, text)
+	if err != nil {
+		logf(ctx, "decideRegistration: %s", err)
+	}
+	if approve {
+		postText(ws, m.Channel, fmt.Sprintf("Approved, %s was told.", username))
+	} else {
+		postText(ws, m.Channel, fmt.Sprintf("Rejected, %s was told.", username))
+	}
+}
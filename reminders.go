@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// With ctf_start and ctf_end set, the bot reminds the public channel an hour
+// and ten minutes before the start, at the start and at the end. Each
+// reminder is posted once, even across restarts (bot_state has
+// "reminder_<name>"), and is dropped if the bot was down when it was due.
+// Players are nudged by DM too for the last ones before the start, and told
+// when a challenge is released ("release_<id>").
+
+type eventReminder struct {
+	name string
+	at   func(config Config) time.Time
+	text func(config Config, now time.Time) string
+	// Also DMed to the players who want "nudges".
+	nudge bool
+}
+
+// A reminder more than this late isn't posted.
+const reminderGrace = 5 * time.Minute
+
+func startsIn(config Config, now time.Time) string {
+	return fmt.Sprintf(":hourglass_flowing_sand: The CTF starts in %s!", formatDuration(config.CtfStart.Sub(now)))
+}
+
+var eventReminders = []eventReminder{
+	{"start-1h", func(config Config) time.Time { return beforeTime(config.CtfStart, time.Hour) }, startsIn, false},
+	{"start-10m", func(config Config) time.Time { return beforeTime(config.CtfStart, 10*time.Minute) }, startsIn, true},
+	{"start", func(config Config) time.Time { return config.CtfStart }, func(config Config, now time.Time) string {
+		return ":checkered_flag: The CTF has started, good luck! Say `challenges` to see the puzzles."
+	}, true},
+	{"end", func(config Config) time.Time { return config.CtfEnd }, func(config Config, now time.Time) string {
+		return ":stopwatch: Time's up! Submissions are closed."
+	}, false},
+}
+
+// beforeTime is t minus d, or zero if t isn't set.
+func beforeTime(t time.Time, d time.Duration) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(-d)
+}
+
+// postEventReminders is a job.
+func postEventReminders(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	now := time.Now()
+	for _, r := range eventReminders {
+		at := r.at(config)
+		if at.IsZero() || now.Before(at) || now.After(at.Add(reminderGrace)) {
+			continue
+		}
+		key := "reminder_" + r.name
+		done, err := getBotState(ctx, db, key)
+		if err != nil {
+			logf(ctx, "postEventReminders: %s", err)
+			return
+		}
+		if done != "" {
+			continue
+		}
+		// Mark it done first, like the ceremony.
+		err = setBotState(ctx, db, key, now.Format(time.RFC3339))
+		if err != nil {
+			logf(ctx, "postEventReminders: %s", err)
+			return
+		}
+		logf(ctx, "postEventReminders: %s", r.name)
+		text := r.text(config, now)
+		announce(config, db, ws, text)
+		if r.nudge {
+			notifyPlayers(ctx, config, db, ws, "nudges", text)
+		}
+	}
+}
+
+// postReleaseNotices is a job. It tells the players who want
+// "challenge-releases" about the challenges whose release time just passed.
+func postReleaseNotices(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	now := time.Now()
+	for _, c := range releasedBetween(now.Add(-reminderGrace), now.Add(time.Second)) {
+		key := fmt.Sprintf("release_%d", c.ID)
+		done, err := getBotState(ctx, db, key)
+		if err != nil {
+			logf(ctx, "postReleaseNotices: %s", err)
+			return
+		}
+		if done != "" {
+			continue
+		}
+		err = setBotState(ctx, db, key, now.Format(time.RFC3339))
+		if err != nil {
+			logf(ctx, "postReleaseNotices: %s", err)
+			return
+		}
+		logf(ctx, "postReleaseNotices: challenge %d", c.ID)
+		notifyPlayers(ctx, config, db, ws, "challenge-releases", fmt.Sprintf("New challenge: %s (level %d). Say `challenges` to see it.", c.Title, c.Level))
+	}
+}
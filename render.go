@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The functions below turn structured data into what the bot posts, without
+// looking at the database, Slack or the clock. Callers gather the data and
+// post the result; golden_test.go checks the output against testdata/golden so
+// that formatting changes are deliberate. The scoreboard renderers are in
+// scoreboard.go.
+
+// renderStatus is the bot's status for state: text, emoji and presence.
+func renderStatus(config Config, state eventState, now time.Time) (text string, emoji string, presence string) {
+	switch state {
+	case eventUpcoming:
+		return "CTF starts in " + formatDuration(config.CtfStart.Sub(now)), ":hourglass_flowing_sand:", "away"
+	case eventPaused:
+		return "paused", ":double_vertical_bar:", "away"
+	case eventFinished:
+		return "finished", ":checkered_flag:", "away"
+	}
+	if config.CtfEnd.IsZero() {
+		return "CTF live", ":large_green_circle:", "auto"
+	}
+	return "CTF live — " + formatDuration(config.CtfEnd.Sub(now)) + " left", ":large_green_circle:", "auto"
+}
+
+func renderHelp(config Config, u user) string {
+	return tr(config, u, "help", `start _team name_: sets your team's name and PMs you a link to a puzzle. This starts your clock. Registered teams just say start.
+register _team name_: creates a team with you on it, when the organizers let players form teams
+invite _@user_: lets someone join your team, by sending join _team_
+validate _level_ _flag_: tells you if a flag for a level is correct (message or invite me to a private channel first!). Levels which take a file: send it with validate _level_ as the message.
+scores: tells you the current top scores (beta)
+challenges: lists the challenges released so far
+taunt _team_: posts a friendly taunt aimed at another team in the public channel
+hint _level_: gives your team the next hint for a level, which may cost points
+pow _level_: gives you a proof of work to add to your guesses, on levels which need one
+suggest: picks a challenge for your team to try next
+token create|revoke: captains get an API token for their team's own tools, or revoke them all
+appeal _receipt_ _reason_: asks the organizers to look at a guess which was rejected
+notify _kind_ on|off: choose which DMs you get (teammate-solves, teammate-hints, unlocks, challenge-releases, nudges, lead-changes, ceremony, outage-refunds); notify alone lists them
+observe: DMs you a digest of major events, for people who aren't playing (observe off to stop)
+mydata: DMs you a file with everything I store about you
+plain on|off: simple sentences instead of emoji and tables, e.g. for screen readers`)
+}
+
+// renderEntered announces a new team. mention is its user group, or "".
+func renderEntered(label string, mention string) string {
+	text := fmt.Sprintf("Team %s has entered the competition!", label)
+	if mention != "" {
+		text += " Welcome " + mention + "!"
+	}
+	return text
+}
+
+// renderSolve is the solve announcement when there are no celebrations.
+func renderSolve(label string, event string) string {
+	return fmt.Sprintf("Team %s found %s!", label, event)
+}
+
+func renderLeadChange(label string) string {
+	return fmt.Sprintf("Team %s takes the lead!", label)
+}
+
+// renderDigest summarizes solves (team label to count) over the last
+// minutes, most solves first, then in teams order.
+func renderDigest(minutes int, teams []string, solves map[string]int) string {
+	teams = append([]string{}, teams...)
+	sort.SliceStable(teams, func(i, j int) bool {
+		return solves[teams[i]] > solves[teams[j]]
+	})
+	parts := []string{}
+	for _, team := range teams {
+		parts = append(parts, fmt.Sprintf("Team %s solved %d", team, solves[team]))
+	}
+	return fmt.Sprintf("In the last %d minutes: %s", minutes, strings.Join(parts, ", "))
+}
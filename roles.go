@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"golang.org/x/net/websocket"
+)
+
+// Roles are stored in the roles table, a user can have several of them.
+// Users without any row are players, which keeps existing deployments (where
+// only the users table is populated) working.
+type role string
+
+const (
+	rolePlayer          role = "player"
+	roleCaptain         role = "captain"
+	roleChallengeAuthor role = "challenge-author"
+	roleAdmin           role = "admin"
+	roleObserver        role = "observer"
+	roleJudge           role = "judge"
+)
+
+// Commands require a permission rather than a role, so that granting a role
+// more powers doesn't require touching every command.
+type permission string
+
+const (
+	permNone             permission = ""
+	permPlay             permission = "play"
+	permViewScores       permission = "view-scores"
+	permManageTeam       permission = "manage-team"
+	permManageChallenges permission = "manage-challenges"
+	permAdmin            permission = "admin"
+	permJudge            permission = "judge"
+)
+
+var rolePermissions = map[role][]permission{
+	rolePlayer:          {permPlay, permViewScores},
+	roleCaptain:         {permPlay, permViewScores, permManageTeam},
+	roleChallengeAuthor: {permViewScores, permManageChallenges},
+	roleAdmin:           {permPlay, permViewScores, permManageTeam, permManageChallenges, permAdmin, permJudge},
+	roleObserver:        {permViewScores},
+	roleJudge:           {permViewScores, permJudge},
+}
+
+func userRoles(ctx context.Context, db *sql.DB, username string) ([]role, error) {
+	rows, err := dbQuery(ctx, db, "SELECT role FROM roles WHERE user=?", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []role{}
+	for rows.Next() {
+		var r string
+		err = rows.Scan(&r)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role(r))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		roles = append(roles, rolePlayer)
+	}
+	return roles, nil
+}
+
+// configAdmin is true for the users listed in admin_user_ids, who are admins
+// whatever the roles table says, e.g. to set up roles in the first place.
+func configAdmin(config Config, userID string) bool {
+	for _, id := range config.AdminUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPermission(ctx context.Context, db *sql.DB, username string, perm permission) (bool, error) {
+	if perm == permNone {
+		return true, nil
+	}
+	roles, err := userRoles(ctx, db, username)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range roles {
+		perms, ok := rolePermissions[r]
+		if !ok {
+			logf(ctx, "hasPermission: %s has unknown role %s", username, r)
+			continue
+		}
+		for _, p := range perms {
+			if p == perm {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// adminUsernames returns the users with the admin role and the ones listed in
+// admin_user_ids, each once.
+func adminUsernames(ctx context.Context, config Config, db *sql.DB) ([]string, error) {
+	admins, err := roleAdmins(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, username := range admins {
+		seen[username] = true
+	}
+	for _, id := range config.AdminUserIDs {
+		u, err := resolveUser(ctx, config, id)
+		if err != nil {
+			logf(ctx, "adminUsernames: %s: %s", id, err)
+			continue
+		}
+		if !seen[u.username] {
+			seen[u.username] = true
+			admins = append(admins, u.username)
+		}
+	}
+	return admins, nil
+}
+
+// roleAdmins returns the users with the admin role.
+func roleAdmins(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := dbQuery(ctx, db, "SELECT user FROM roles WHERE role=?", string(roleAdmin))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	admins := []string{}
+	for rows.Next() {
+		var username string
+		err = rows.Scan(&username)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, username)
+	}
+	return admins, rows.Err()
+}
+
+// dmAdmins sends text to every admin.
+func dmAdmins(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, text string) {
+	admins, err := adminUsernames(ctx, config, db)
+	if err != nil {
+		logf(ctx, "dmAdmins: %s", err)
+		return
+	}
+	for _, username := range admins {
+		err = dmUsername(ctx, config, ws, username, text)
+		if err != nil {
+			logf(ctx, "dmAdmins: %s: %s", username, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Anything a user typed (team names, guesses) must go through escapeText
+// before it's put in a message. Slack parses <...> as mentions and links,
+// so "<!channel>" in a team name would ping everyone whenever the bot
+// announces the team; the bare @here/@channel/@everyone forms are defused
+// with a zero-width space.
+
+var slackEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	"@here", "@\u200bhere",
+	"@channel", "@\u200bchannel",
+	"@everyone", "@\u200beveryone",
+)
+
+func escapeText(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// Slack truncates messages longer than this.
+const maxMessageLength = 40000
+
+// splitMessage cuts text into messages of at most maxMessageLength bytes,
+// preferably at line breaks.
+func splitMessage(text string) []string {
+	parts := []string{}
+	for len(text) > maxMessageLength {
+		cut := strings.LastIndex(text[:maxMessageLength], "\n")
+		if cut <= 0 {
+			cut = maxMessageLength
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		parts = append(parts, text[:cut])
+		text = strings.TrimPrefix(text[cut:], "\n")
+	}
+	return append(parts, text)
+}
+
+const maxTeamNameLength = 40
+
+// validateTeamName rejects team names which would be a nuisance in public
+// announcements even once escaped: mentions, markup, invisible or control
+// characters (e.g. right-to-left overrides) and very long names.
+func validateTeamName(config Config, u user, name string) error {
+	switch {
+	case name == "":
+		return &UserError{tr(config, u, "team-name.empty", "sorry, your team needs a name.")}
+	case utf8.RuneCountInString(name) > maxTeamNameLength:
+		return &UserError{tr(config, u, "team-name.too-long", "sorry, team names can be at most %d characters long.", maxTeamNameLength)}
+	case strings.ContainsAny(name, "<>@`"):
+		return &UserError{tr(config, u, "team-name.markup", "sorry, team names can't contain <, >, @ or `.")}
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return &UserError{tr(config, u, "team-name.invisible", "sorry, team names can't contain invisible characters.")}
+		}
+	}
+	return nil
+}
+
+// Flags longer than this are rejected before they get near the database.
+const maxFlagLength = 200
+
+// Slack rewrites what users type: URLs and email addresses become
+// <http://...|label> links, and &, < and > become entities. Code spans are
+// the user's own formatting, but a flag in backticks is still the flag.
+var slackLinkPattern = regexp.MustCompile(`<((?:https?://|mailto:)[^<>|]*)(?:\|([^<>]*))?>`)
+
+var slackEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// normalizeFlag turns a validate argument back into what the user typed. The
+// result is never longer than text.
+func normalizeFlag(text string) string {
+	text = strings.TrimSpace(text)
+	text = slackLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		m := slackLinkPattern.FindStringSubmatch(link)
+		if m[2] != "" {
+			return m[2]
+		}
+		return strings.TrimPrefix(m[1], "mailto:")
+	})
+	for _, fence := range []string{"```", "`"} {
+		if len(text) >= 2*len(fence) && strings.HasPrefix(text, fence) && strings.HasSuffix(text, fence) {
+			text = strings.TrimSpace(text[len(fence) : len(text)-len(fence)])
+			break
+		}
+	}
+	return slackEntities.Replace(text)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// A job is something the bot does on its own, every interval.
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn)
+}
+
+var jobs = []job{
+	{"status", time.Minute, updateBotStatus},
+	{"countdown", time.Minute, updateCountdown},
+	{"topic", time.Minute, updateTopic},
+	{"observer-digest", 15 * time.Minute, sendObserverDigest},
+	{"announcement-digest", time.Minute, postSolveDigest},
+	{"lead-change", time.Minute, checkLeadChange},
+	{"ceremony", time.Minute, runCeremony},
+	{"event-reminders", time.Minute, postEventReminders},
+	{"release-notices", time.Minute, postReleaseNotices},
+	{"daily-summary", time.Minute, postDailySummary},
+	{"flush-logs", time.Second, flushLogBufferJob},
+	{"purge-audit", time.Hour, purgeAudit},
+	{"purge-personal-data", time.Hour, purgePersonalData},
+	{"auto-hints", time.Minute, releaseAutoHints},
+	{"challenge-health", time.Hour, sendChallengeHealth},
+	{"service-checks", time.Minute, checkServices},
+	{"pending-submissions", time.Minute, replayPending},
+	{"held-dms", time.Minute, sendHeldDMs},
+	{"watchdog", time.Minute, checkWatchdog},
+}
+
+// startScheduler runs each job once right away and then every interval. Each
+// run gets its own correlation ID.
+func startScheduler(config Config, db *sql.DB, ws *websocket.Conn) {
+	for _, j := range jobs {
+		go func(j job) {
+			ticker := time.NewTicker(j.interval)
+			for {
+				ctx := withCorrelationID(context.Background(), newCorrelationID())
+				ctx, span := tracer().Start(ctx, "job."+j.name)
+				j.run(ctx, config, db, ws)
+				span.End()
+				<-ticker.C
+			}
+		}(j)
+	}
+}
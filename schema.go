@@ -0,0 +1,24 @@
+package main
+
+// schema creates every table the bot uses, as of the first migration (see
+// migrations.go). Keep it in sync with README.md and store.Tables. It's
+// written for MySQL, the dialect translates it for the other databases.
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS teams (id int not null auto_increment primary key, name varchar(255) not null)",
+	"CREATE TABLE IF NOT EXISTS users (user varchar(50) primary key, team int, key (team))",
+	"CREATE TABLE IF NOT EXISTS logs (id int not null auto_increment primary key, user varchar(50), event varchar(255), level int, team_id int, ref varchar(16), msg_ts varchar(20), ts datetime default now(), unique key (user, msg_ts), key (team_id, event))",
+	"CREATE TABLE IF NOT EXISTS features (name varchar(50) primary key, enabled bool not null)",
+	"CREATE TABLE IF NOT EXISTS bot_state (name varchar(50) primary key, value varchar(255) not null)",
+	"CREATE TABLE IF NOT EXISTS observers (user varchar(50) primary key, channel varchar(50) not null)",
+	"CREATE TABLE IF NOT EXISTS preferences (user varchar(50) not null, kind varchar(30) not null, enabled bool not null, primary key (user, kind))",
+	"CREATE TABLE IF NOT EXISTS outbox (id int not null auto_increment primary key, kind varchar(20) not null, channel varchar(50) not null, text text not null, event varchar(255) not null, ref varchar(16), posted bool not null default false, ts datetime default now(), key (posted))",
+	"CREATE TABLE IF NOT EXISTS attempts (team_id int not null, level int not null, count int not null, primary key (team_id, level))",
+	"CREATE TABLE IF NOT EXISTS scoreboard (team_id int not null, event varchar(255) not null, ts datetime default now(), primary key (team_id, event))",
+	"CREATE TABLE IF NOT EXISTS audit (id int not null auto_increment primary key, channel varchar(50) not null, user varchar(50) not null, msg_ts varchar(20) not null, text text not null, ref varchar(16), received datetime default now(), key (received))",
+	"CREATE TABLE IF NOT EXISTS awards (id int not null auto_increment primary key, team_id int not null, points int not null, reason text not null, judge varchar(50) not null, ref varchar(16), ts datetime default now(), key (team_id))",
+	"CREATE TABLE IF NOT EXISTS appeals (id int not null auto_increment primary key, log_id int not null, user varchar(50) not null, reason text not null, status varchar(10) not null, decided_by varchar(50), ref varchar(16), ts datetime default now(), unique key (log_id))",
+	"CREATE TABLE IF NOT EXISTS handicaps (team_id int not null primary key, multiplier int not null default 100, head_start int not null default 0)",
+	"CREATE TABLE IF NOT EXISTS easter_eggs (team_id int not null, phrase varchar(255) not null, user varchar(50) not null, ts datetime default now(), primary key (team_id, phrase))",
+	"CREATE TABLE IF NOT EXISTS outages (id int not null auto_increment primary key, challenge_id int not null, level int not null, started datetime not null, ended datetime, reason varchar(255) not null, key (level))",
+	"CREATE TABLE IF NOT EXISTS roles (user varchar(50) not null, role varchar(20) not null, primary key (user, role))",
+}
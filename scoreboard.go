@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A standing is one line of the scoreboard. All the renderers below work from
+// the same standings, computed once per scores command.
+type standing struct {
+	rank       int
+	decoration string
+	teamName   string
+	flags      []bool
+	numFlags   int
+	points     int
+	awarded    int
+	bonus      int
+	penalty    int
+	handicap   string
+	elapsed    time.Duration // 0 if unknown
+}
+
+// toStandings ranks scores. Each standing's flags has one entry per challenge,
+// in ID order. Teams are shown under their alias while aliases are active, see
+// aliases.go.
+func toStandings(config Config, scores []teamScores) []standing {
+	challenges := currentChallenges()
+	now := time.Now()
+	standings := make([]standing, 0, len(scores))
+	for i, s := range scores {
+		flags := make([]bool, len(challenges))
+		for j, c := range challenges {
+			flags[j] = s.flags[c.ID]
+		}
+		standings = append(standings, standing{
+			rank:       i + 1,
+			decoration: rankDecoration(config, i+1),
+			teamName:   publicTeamLabel(config, s.teamID, s.teamName, now),
+			flags:      flags,
+			numFlags:   s.numFlags(),
+			points:     s.points,
+			awarded:    s.awarded,
+			bonus:      s.bonus,
+			penalty:    s.penalty,
+			handicap:   s.handicap(),
+			elapsed:    s.elapsed(),
+		})
+	}
+	return standings
+}
+
+// rankDecoration returns the configured medal for a rank, or "".
+func rankDecoration(config Config, rank int) string {
+	if rank < 1 || rank > len(config.RankDecorations) {
+		return ""
+	}
+	return config.RankDecorations[rank-1]
+}
+
+// teamLabel is how a team is shown in scores and announcements: its name
+// (escaped, see sanitize.go) followed by its badge, if it has one.
+func teamLabel(config Config, teamID int, teamName string) string {
+	badge, ok := config.TeamBadges[teamID]
+	if !ok || badge == "" {
+		return escapeText(teamName)
+	}
+	return escapeText(teamName) + " " + badge
+}
+
+// summary is "3 flags", or "3 flags, 250 points" when challenges are worth
+// more than a point each, followed by combo bonuses, points awarded by judges,
+// points lost to hints, the team's handicap and the time it took, if any.
+func (s standing) summary() string {
+	text := fmt.Sprintf("%d flags", s.numFlags)
+	if s.points != s.numFlags {
+		text += fmt.Sprintf(", %d points", s.points)
+	}
+	if s.bonus != 0 {
+		text += fmt.Sprintf(" + %d combo", s.bonus)
+	}
+	if s.awarded != 0 {
+		text += fmt.Sprintf(" + %d awarded", s.awarded)
+	}
+	if s.penalty != 0 {
+		text += fmt.Sprintf(" - %d for hints", s.penalty)
+	}
+	if s.handicap != "" {
+		text += fmt.Sprintf(" (handicap %s)", s.handicap)
+	}
+	if s.elapsed != 0 {
+		text += fmt.Sprintf(" in %s", formatDuration(s.elapsed))
+	}
+	return text
+}
+
+// withDecoration prefixes text with the standing's decoration, if any.
+func (s standing) withDecoration(text string) string {
+	if s.decoration == "" {
+		return text
+	}
+	return s.decoration + " " + text
+}
+
+// A scoreboardRenderer formats one page of standings. Renderers which only
+// produce text return nil blocks.
+type scoreboardRenderer interface {
+	render(page []standing) (text string, blocks []interface{})
+}
+
+// Selected with scoreboard_style in config.json.
+var scoreboardRenderers = map[string]scoreboardRenderer{
+	"compact": compactRenderer{},
+	"emoji":   emojiRenderer{},
+	"table":   tableRenderer{},
+	"blocks":  blockKitRenderer{},
+}
+
+func scoreboardRendererFor(config Config) scoreboardRenderer {
+	r, ok := scoreboardRenderers[config.ScoreboardStyle]
+	if !ok {
+		return compactRenderer{}
+	}
+	return r
+}
+
+type compactRenderer struct{}
+
+func (compactRenderer) render(page []standing) (string, []interface{}) {
+	lines := []string{}
+	for _, s := range page {
+		lines = append(lines, fmt.Sprintf("# %d: %s", s.rank, s.withDecoration(fmt.Sprintf("Team '%s' found %s", s.teamName, s.summary()))))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// emojiRenderer shows one square per flag.
+type emojiRenderer struct{}
+
+func (emojiRenderer) render(page []standing) (string, []interface{}) {
+	lines := []string{}
+	for _, s := range page {
+		bar := ""
+		for _, found := range s.flags {
+			if found {
+				bar += ":large_green_square:"
+			} else {
+				bar += ":white_large_square:"
+			}
+		}
+		line := fmt.Sprintf("%d. %s %s", s.rank, bar, s.withDecoration("*"+s.teamName+"*"))
+		if s.elapsed != 0 {
+			line += " " + formatDuration(s.elapsed)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// tableRenderer lines everything up in a code block.
+type tableRenderer struct{}
+
+func (tableRenderer) render(page []standing) (string, []interface{}) {
+	width := len("Team")
+	for _, s := range page {
+		if len(s.teamName) > width {
+			width = len(s.teamName)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("```\n")
+	fmt.Fprintf(&b, "%4s  %-*s  %5s  %6s  ", "Rank", width, "Team", "Flags", "Time")
+	if len(page) > 0 {
+		for i := range page[0].flags {
+			fmt.Fprintf(&b, "%d", (i+1)%10)
+		}
+	}
+	b.WriteString("\n")
+	for _, s := range page {
+		elapsed := "-"
+		if s.elapsed != 0 {
+			elapsed = formatDuration(s.elapsed)
+		}
+		fmt.Fprintf(&b, "%4d  %-*s  %5d  %6s  ", s.rank, width, s.teamName, s.numFlags, elapsed)
+		for _, found := range s.flags {
+			if found {
+				b.WriteString("x")
+			} else {
+				b.WriteString(".")
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("```")
+	return b.String(), nil
+}
+
+// blockKitRenderer produces one section per team. It must be posted through
+// the Web API, see postBlocks.
+type blockKitRenderer struct{}
+
+func (blockKitRenderer) render(page []standing) (string, []interface{}) {
+	text, _ := compactRenderer{}.render(page)
+	blocks := []interface{}{}
+	for _, s := range page {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"fields": []interface{}{
+				map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%d.* %s", s.rank, s.withDecoration(s.teamName))},
+				map[string]string{"type": "mrkdwn", "text": s.summary()},
+			},
+		})
+	}
+	return text, blocks
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+type teamScores struct {
+	teamID   int
+	teamName string
+	// Challenge ID to found, see challenges.go.
+	flags  map[int]bool
+	points int
+	// Discretionary points from judges, see judge.go.
+	awarded int
+	// Combo bonuses, see combo.go.
+	bonus int
+	// Points lost to hints, see hints.go.
+	penalty int
+	// Handicap, see handicap.go. multiplier is a percentage of points, 0
+	// means no multiplier.
+	multiplier int
+	headStart  int
+	// When the team started (or ctf_start, if later) and found its last
+	// flag, zero if unknown.
+	started   time.Time
+	lastSolve time.Time
+}
+
+// ScoreList is things
+type ScoreList []teamScores
+
+func (s ScoreList) Len() int {
+	return len(s)
+}
+
+func (s ScoreList) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s teamScores) numFlags() int {
+	return len(s.flags)
+}
+
+func (s teamScores) total() int {
+	points := s.points
+	if s.multiplier != 0 {
+		points = points * s.multiplier / 100
+	}
+	return points + s.headStart + s.awarded + s.bonus - s.penalty
+}
+
+// Less breaks ties on points and flags by time: the team which got there
+// last ranks lower.
+func (s ScoreList) Less(i, j int) bool {
+	if s[i].total() != s[j].total() {
+		return s[i].total() < s[j].total()
+	}
+	if s[i].numFlags() != s[j].numFlags() {
+		return s[i].numFlags() < s[j].numFlags()
+	}
+	if s[i].lastSolve.IsZero() || s[j].lastSolve.IsZero() {
+		return s[i].lastSolve.IsZero() && !s[j].lastSolve.IsZero()
+	}
+	return s[i].lastSolve.After(s[j].lastSolve)
+}
+
+// elapsed is how long the team took to find its flags so far, 0 if unknown.
+func (s teamScores) elapsed() time.Duration {
+	if s.started.IsZero() || s.lastSolve.IsZero() || s.lastSolve.Before(s.started) {
+		return 0
+	}
+	return s.lastSolve.Sub(s.started)
+}
+
+// Large scoreboards are posted as several messages of at most this many
+// teams each. Slack allows 50 blocks per message, this leaves room for the
+// page header.
+const scoresPerMessage = 45
+
+// scorePager renders standings a page at a time and posts each page as its
+// own numbered message. Only one page is held in memory at a time.
+type scorePager struct {
+	config   Config
+	ws       *websocket.Conn
+	channel  string
+	renderer scoreboardRenderer
+	pages    int
+	page     int
+	pending  []standing
+}
+
+func newScorePager(config Config, ws *websocket.Conn, channel string, total int) *scorePager {
+	pages := (total + scoresPerMessage - 1) / scoresPerMessage
+	return &scorePager{config: config, ws: ws, channel: channel, renderer: scoreboardRendererFor(config), pages: pages}
+}
+
+func (p *scorePager) add(s standing) {
+	p.pending = append(p.pending, s)
+	if len(p.pending) == scoresPerMessage {
+		p.flush()
+	}
+}
+
+func (p *scorePager) flush() {
+	if len(p.pending) == 0 {
+		return
+	}
+	p.page++
+	text, blocks := p.renderer.render(p.pending)
+	p.pending = p.pending[:0]
+
+	header := ""
+	if p.pages > 1 {
+		header = fmt.Sprintf("Scores (%d/%d):", p.page, p.pages)
+	}
+	if blocks == nil {
+		if header != "" {
+			text = header + "\n" + text
+		}
+		postText(p.ws, p.channel, text)
+		return
+	}
+	if header != "" {
+		blocks = append([]interface{}{map[string]interface{}{
+			"type":     "context",
+			"elements": []interface{}{map[string]string{"type": "mrkdwn", "text": header}},
+		}}, blocks...)
+	}
+	err := postBlocks(p.config, p.channel, text, blocks)
+	if err != nil {
+		log.Printf("postBlocks: %s", err)
+		postText(p.ws, p.channel, text)
+	}
+}
+
+// computeScores reads the scoreboard projection (and team names), plus the
+// teams which are playing without a flag yet.
+func computeScores(ctx context.Context, config Config, db *sql.DB) ([]teamScores, error) {
+	return computeScoresBefore(ctx, config, db, time.Time{})
+}
+
+// computeScoresBefore only counts flags found before until, unless until is
+// zero.
+func computeScoresBefore(ctx context.Context, config Config, db *sql.DB, until time.Time) ([]teamScores, error) {
+	query := "SELECT scoreboard.team_id, teams.name, scoreboard.event, UNIX_TIMESTAMP(scoreboard.ts) FROM scoreboard JOIN teams ON teams.id = scoreboard.team_id WHERE scoreboard.team_id < ?"
+	args := []interface{}{store.TestTeamID}
+	if !until.IsZero() {
+		query += " AND scoreboard.ts < ?"
+		args = append(args, until.UTC())
+	}
+	// Combos depend on the order of solves.
+	query += " ORDER BY scoreboard.ts, scoreboard.event"
+	rows, err := dbQuery(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tally := newScoreTally(currentChallenges(), config.Combo)
+	for rows.Next() {
+		var teamID int
+		var teamName, event string
+		var ts int64
+		err := rows.Scan(&teamID, &teamName, &event, &ts)
+		if err != nil {
+			return nil, err
+		}
+		tally.add(teamID, teamName, event, time.Unix(ts, 0))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	err = addPlayingTeams(ctx, db, tally, until)
+	if err != nil {
+		return nil, err
+	}
+	err = addAwards(ctx, db, tally, until)
+	if err != nil {
+		return nil, err
+	}
+	err = addHandicaps(ctx, db, tally)
+	if err != nil {
+		return nil, err
+	}
+	err = addHints(ctx, config, db, tally, until)
+	if err != nil {
+		return nil, err
+	}
+	err = addStarts(ctx, config, db, tally)
+	if err != nil {
+		return nil, err
+	}
+	return tally.scores(), nil
+}
+
+// addPlayingTeams adds the teams which started or guessed before until (unless
+// it's zero) but haven't found a flag, with 0 flags.
+func addPlayingTeams(ctx context.Context, db *sql.DB, tally *scoreTally, until time.Time) error {
+	query := "SELECT DISTINCT logs.team_id, teams.name FROM logs JOIN teams ON teams.id = logs.team_id WHERE logs.team_id < ?"
+	args := []interface{}{store.TestTeamID}
+	if !until.IsZero() {
+		query += " AND logs.ts < ?"
+		args = append(args, until.UTC())
+	}
+	rows, err := dbQuery(ctx, db, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var teamID int
+		var teamName string
+		err = rows.Scan(&teamID, &teamName)
+		if err != nil {
+			return err
+		}
+		tally.team(teamID, teamName)
+	}
+	return rows.Err()
+}
+
+// addStarts sets when each team started: its first "start", or ctf_start if
+// the team started before the event.
+func addStarts(ctx context.Context, config Config, db *sql.DB, tally *scoreTally) error {
+	rows, err := dbQuery(ctx, db, "SELECT users.team, UNIX_TIMESTAMP(MIN(logs.ts)) FROM logs JOIN users ON users.user = logs.user WHERE logs.event='start' AND users.team < ? GROUP BY users.team", store.TestTeamID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var teamID int
+		var ts int64
+		err = rows.Scan(&teamID, &ts)
+		if err != nil {
+			return err
+		}
+		s, ok := tally.teams[teamID]
+		if !ok {
+			continue
+		}
+		s.started = time.Unix(ts, 0)
+		if s.started.Before(config.CtfStart) {
+			s.started = config.CtfStart
+		}
+	}
+	return rows.Err()
+}
+
+// scoreTally turns log events into sorted scores, one event at a time. Events
+// must be added in the order they happened.
+type scoreTally struct {
+	challenges []Challenge
+	combo      ComboConfig
+	teams      map[int]*teamScores
+	combos     map[int]*comboState
+}
+
+func newScoreTally(challenges []Challenge, combo ComboConfig) *scoreTally {
+	return &scoreTally{challenges: challenges, combo: combo, teams: map[int]*teamScores{}, combos: map[int]*comboState{}}
+}
+
+func (t *scoreTally) team(teamID int, teamName string) *teamScores {
+	s, ok := t.teams[teamID]
+	if !ok {
+		s = &teamScores{teamID: teamID, teamName: teamName, flags: map[int]bool{}}
+		t.teams[teamID] = s
+	}
+	return s
+}
+
+func (t *scoreTally) add(teamID int, teamName string, event string, at time.Time) {
+	s := t.team(teamID, teamName)
+	var id int
+	if _, err := fmt.Sscanf(event, "flag %d", &id); err != nil || s.flags[id] {
+		return
+	}
+	s.flags[id] = true
+	s.points += t.pointsFor(id)
+	s.lastSolve = at
+	if !t.combo.enabled() {
+		return
+	}
+	for _, c := range t.challenges {
+		if c.ID != id {
+			continue
+		}
+		state, ok := t.combos[teamID]
+		if !ok {
+			state = &comboState{}
+			t.combos[teamID] = state
+		}
+		if state.add(c.Level, at, t.combo.window()) > 0 {
+			s.bonus += t.combo.Bonus
+		}
+	}
+}
+
+// pointsFor returns the challenge's points. Flags of challenges which were
+// since removed still count, for 1 point.
+func (t *scoreTally) pointsFor(id int) int {
+	for _, c := range t.challenges {
+		if c.ID == id {
+			return c.Points
+		}
+	}
+	return 1
+}
+
+// scores returns a snapshot: adding events afterwards doesn't change it.
+// Teams which are tied keep the order of their IDs.
+func (t *scoreTally) scores() []teamScores {
+	scores := make([]teamScores, 0, len(t.teams))
+	for _, s := range t.teams {
+		snapshot := *s
+		snapshot.flags = make(map[int]bool, len(s.flags))
+		for id := range s.flags {
+			snapshot.flags[id] = true
+		}
+		scores = append(scores, snapshot)
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].teamID < scores[j].teamID })
+	sort.Stable(sort.Reverse(ScoreList(scores)))
+	return scores
+}
+
+func doTopScores(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, userToken string, channel string) {
+	if !featureEnabled(db, "scores") {
+		postError(ctx, ws, channel, "sorry, scores are turned off right now.", userToken)
+		return
+	}
+	if channel == getPublicChannel() && !featureEnabled(db, "scores-public") {
+		postError(ctx, ws, channel, "sorry, scores are only available in private messages right now.", userToken)
+		return
+	}
+
+	scores, err := computeScores(ctx, config, readDB(db))
+	if err != nil {
+		postInternalError(ctx, ws, channel, err, userToken)
+		return
+	}
+
+	if len(scores) == 0 {
+		postText(ws, channel, "No team has scored yet.")
+		return
+	}
+
+	pager := newScorePager(config, ws, channel, len(scores))
+	if plain, err := userPrefersPlain(ctx, config, db, userToken); err != nil {
+		logf(ctx, "doTopScores: %s", err)
+	} else if plain {
+		pager.renderer = plainRenderer{}
+	}
+	for _, s := range toStandings(config, scores) {
+		pager.add(s)
+	}
+	pager.flush()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// A challenge can depend on a service (a web app to attack, a server to
+// connect to). With health_check.url, the service-checks job GETs it every
+// minute; anything but a 2xx or 3xx answer marks the challenge degraded:
+// "challenges" says so and admins get a DM when it goes down and comes back.
+//
+// With health_check.pause_attempts, wrong guesses on the challenge's level
+// don't use up tries while it's down. The downtime is recorded in the
+// outages table, which rebuildProjections honors too (see outages.go).
+
+type HealthCheck struct {
+	URL           string `yaml:"url" json:"url"`
+	PauseAttempts bool   `yaml:"pause_attempts" json:"pause_attempts"`
+}
+
+var serviceChecks = struct {
+	lock sync.Mutex
+	// Challenge ID to when it went down.
+	down map[int]time.Time
+	// Challenges probed since startup.
+	probed map[int]bool
+}{down: map[int]time.Time{}, probed: map[int]bool{}}
+
+var healthCheckClient = &http.Client{Timeout: 10 * time.Second}
+
+// serviceDown returns since when the challenge's service is down.
+func serviceDown(id int) (time.Time, bool) {
+	serviceChecks.lock.Lock()
+	defer serviceChecks.lock.Unlock()
+	since, ok := serviceChecks.down[id]
+	return since, ok
+}
+
+func probe(url string) error {
+	resp, err := healthCheckClient.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkServices is a job.
+func checkServices(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	for _, c := range currentChallenges() {
+		if c.HealthCheck == nil || c.HealthCheck.URL == "" {
+			continue
+		}
+		err := probe(c.HealthCheck.URL)
+		since, wasDown := serviceDown(c.ID)
+		serviceChecks.lock.Lock()
+		first := !serviceChecks.probed[c.ID]
+		serviceChecks.probed[c.ID] = true
+		serviceChecks.lock.Unlock()
+		switch {
+		case err == nil && first && c.HealthCheck.PauseAttempts:
+			// Close an outage the bot didn't see the end of before it restarted.
+			if err := endOutage(ctx, db, c); err != nil {
+				logf(ctx, "checkServices: %s", err)
+			}
+		case err != nil && !wasDown:
+			logf(ctx, "checkServices: challenge %d is down: %s", c.ID, err)
+			serviceChecks.lock.Lock()
+			serviceChecks.down[c.ID] = time.Now()
+			serviceChecks.lock.Unlock()
+			if c.HealthCheck.PauseAttempts {
+				if err := startOutage(ctx, db, c, "health check failed"); err != nil {
+					logf(ctx, "checkServices: %s", err)
+				}
+			}
+			dmAdmins(ctx, config, db, ws, fmt.Sprintf(":warning: %s (challenge %d) is down: %s", c.Title, c.ID, err))
+		case err == nil && wasDown:
+			logf(ctx, "checkServices: challenge %d is back up", c.ID)
+			serviceChecks.lock.Lock()
+			delete(serviceChecks.down, c.ID)
+			serviceChecks.lock.Unlock()
+			if c.HealthCheck.PauseAttempts {
+				if err := endOutage(ctx, db, c); err != nil {
+					logf(ctx, "checkServices: %s", err)
+				}
+			}
+			dmAdmins(ctx, config, db, ws, fmt.Sprintf("%s (challenge %d) is back up after %s.", c.Title, c.ID, formatDuration(time.Since(since))))
+		}
+	}
+}
+
+// attemptsPaused is true when wrong guesses on level shouldn't use up tries.
+func attemptsPaused(level int) bool {
+	for _, c := range currentChallenges() {
+		if c.Level != level || c.HealthCheck == nil || !c.HealthCheck.PauseAttempts {
+			continue
+		}
+		if _, down := serviceDown(c.ID); down {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"gopkg.in/yaml.v2"
+)
+
+// "admin setup" walks a first-time organizer through the event in a DM: it
+// checks the database and Slack, then asks for the event window, how teams
+// are ranked and the challenges, one question at a time. While a setup is
+// going on, everything the organizer DMs the bot is an answer ("cancel"
+// stops). On "save", the challenges are appended to the challenges file, or
+// to puzzles in config.json when there is no challenges file, and the event
+// window is written to config.json. With AMIGO_CONFIG_KEY set, the files are
+// encrypted, so the result is sent to the organizer to paste instead.
+
+type setupStep int
+
+const (
+	setupStart setupStep = iota
+	setupEnd
+	setupScoring
+	setupTitle
+	setupLevel
+	setupFlag
+	setupPoints
+	setupConfirm
+)
+
+// Sessions idle for longer than this are forgotten.
+const setupTimeout = 30 * time.Minute
+
+type setupChallenge struct {
+	ID     int    `yaml:"id" json:"id"`
+	Level  int    `yaml:"level" json:"level"`
+	Title  string `yaml:"title" json:"title"`
+	Flag   string `yaml:"flag" json:"flag"`
+	Points int    `yaml:"points,omitempty" json:"points,omitempty"`
+}
+
+type setupSession struct {
+	channel    string
+	step       setupStep
+	start      time.Time
+	end        time.Time
+	byPoints   bool
+	current    setupChallenge
+	challenges []setupChallenge
+	nextID     int
+	lastActive time.Time
+}
+
+var setupSessions = map[string]*setupSession{}
+var setupSessionsLock sync.Mutex
+
+// admin setup
+func doAdminSetup(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if !isPrivate(m.Channel) {
+		postError(ctx, ws, m.Channel, "setup happens in a DM, send me \"admin setup\" there.", m.User)
+		return
+	}
+	nextID := 1
+	for _, c := range currentChallenges() {
+		if c.ID >= nextID {
+			nextID = c.ID + 1
+		}
+	}
+	s := &setupSession{channel: m.Channel, nextID: nextID, lastActive: time.Now()}
+	setupSessionsLock.Lock()
+	setupSessions[m.User] = s
+	setupSessionsLock.Unlock()
+	logf(ctx, "doAdminSetup: %s started setup", m.User)
+
+	postText(ws, m.Channel, "Let's set up the event. Answer each question here, or say \"cancel\" to stop.\n"+setupChecks(ctx, config, db))
+	postText(ws, m.Channel, s.prompt(config))
+}
+
+// setupChecks reports whether the database and Slack can be reached.
+func setupChecks(ctx context.Context, config Config, db *sql.DB) string {
+	lines := []string{}
+	start := time.Now()
+	err := db.PingContext(ctx)
+	if err != nil {
+		lines = append(lines, fmt.Sprintf(":x: database: %s", err))
+	} else {
+		lines = append(lines, fmt.Sprintf(":white_check_mark: database (%s)", time.Since(start).Round(time.Millisecond)))
+	}
+	var auth struct {
+		Team string `json:"team"`
+		User string `json:"user"`
+	}
+	err = traceSlack(ctx, "auth.test", func() error {
+		return callSlackAPI(config.SlackApiToken, "auth.test", nil, &auth)
+	})
+	if err != nil {
+		lines = append(lines, fmt.Sprintf(":x: Slack: %s", err))
+	} else {
+		lines = append(lines, fmt.Sprintf(":white_check_mark: Slack (%s in %s)", auth.User, auth.Team))
+	}
+	if getPublicChannel() == "" {
+		lines = append(lines, ":x: public channel not found")
+	} else {
+		lines = append(lines, fmt.Sprintf(":white_check_mark: public channel <#%s>", getPublicChannel()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *setupSession) prompt(config Config) string {
+	switch s.step {
+	case setupStart:
+		return fmt.Sprintf("When does the event start? e.g. 2016-07-08T17:00:00Z, or \"skip\" to keep %s.", describeTime(config.CtfStart))
+	case setupEnd:
+		return fmt.Sprintf("When does it end? Or \"skip\" to keep %s.", describeTime(config.CtfEnd))
+	case setupScoring:
+		return "How are teams ranked? \"flags\" (every challenge is worth a point) or \"points\" (you give each challenge its points)."
+	case setupTitle:
+		return fmt.Sprintf("Title of challenge %d? Or \"done\" if that's all (%d added so far).", s.nextID, len(s.challenges))
+	case setupLevel:
+		return "Its level, the number players pass to validate?"
+	case setupFlag:
+		return "Its flag?"
+	case setupPoints:
+		return "How many points is it worth?"
+	default:
+		return s.summary() + "\nSay \"save\" to write this down, or \"cancel\"."
+	}
+}
+
+func describeTime(t time.Time) string {
+	if t.IsZero() {
+		return "no limit"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (s *setupSession) summary() string {
+	lines := []string{"Here is the setup:"}
+	if !s.start.IsZero() {
+		lines = append(lines, "• starts "+s.start.Format(time.RFC3339))
+	}
+	if !s.end.IsZero() {
+		lines = append(lines, "• ends "+s.end.Format(time.RFC3339))
+	}
+	for _, c := range s.challenges {
+		line := fmt.Sprintf("• challenge %d, level %d: %s", c.ID, c.Level, escapeText(c.Title))
+		if c.Points != 0 {
+			line += fmt.Sprintf(" (%d points)", c.Points)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 1 {
+		lines = append(lines, "• no changes")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// continueSetup feeds m to the sender's setup, if they have one going on in
+// this DM. It returns false if m is a regular message.
+func continueSetup(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message) bool {
+	setupSessionsLock.Lock()
+	defer setupSessionsLock.Unlock()
+	s, ok := setupSessions[m.User]
+	if !ok || s.channel != m.Channel {
+		return false
+	}
+	if time.Since(s.lastActive) > setupTimeout {
+		delete(setupSessions, m.User)
+		return false
+	}
+	s.lastActive = time.Now()
+
+	answer := strings.TrimSpace(m.Text)
+	if strings.EqualFold(answer, "cancel") {
+		delete(setupSessions, m.User)
+		postText(ws, m.Channel, "Setup cancelled, nothing was changed.")
+		return true
+	}
+	if s.step == setupConfirm && strings.EqualFold(answer, "save") {
+		delete(setupSessions, m.User)
+		saveSetup(ctx, config, ws, m.Channel, s)
+		return true
+	}
+	err := s.answer(answer)
+	if err != nil {
+		postError(ctx, ws, m.Channel, err.Error(), m.User)
+	}
+	postText(ws, m.Channel, s.prompt(config))
+	return true
+}
+
+// answer records the answer to the current question and moves to the next.
+func (s *setupSession) answer(answer string) error {
+	switch s.step {
+	case setupStart, setupEnd:
+		if !strings.EqualFold(answer, "skip") {
+			t, err := time.Parse(time.RFC3339, answer)
+			if err != nil {
+				return fmt.Errorf("%s isn't a time like 2016-07-08T17:00:00Z", escapeText(answer))
+			}
+			if s.step == setupEnd && !s.start.IsZero() && !t.After(s.start) {
+				return fmt.Errorf("the event must end after it starts")
+			}
+			if s.step == setupStart {
+				s.start = t
+			} else {
+				s.end = t
+			}
+		}
+		s.step++
+	case setupScoring:
+		switch strings.ToLower(answer) {
+		case "flags":
+			s.byPoints = false
+		case "points":
+			s.byPoints = true
+		default:
+			return fmt.Errorf("please answer \"flags\" or \"points\"")
+		}
+		s.step = setupTitle
+	case setupTitle:
+		if strings.EqualFold(answer, "done") {
+			s.step = setupConfirm
+			return nil
+		}
+		s.current = setupChallenge{ID: s.nextID, Title: answer}
+		s.step = setupLevel
+	case setupLevel:
+		level, err := strconv.Atoi(answer)
+		if err != nil || level < 1 {
+			return fmt.Errorf("levels are numbered from 1")
+		}
+		s.current.Level = level
+		s.step = setupFlag
+	case setupFlag:
+		if answer == "" {
+			return fmt.Errorf("the flag can't be empty")
+		}
+		s.current.Flag = answer
+		if s.byPoints {
+			s.step = setupPoints
+			return nil
+		}
+		s.addCurrent()
+	case setupPoints:
+		points, err := strconv.Atoi(answer)
+		if err != nil || points < 1 {
+			return fmt.Errorf("points must be a positive number")
+		}
+		s.current.Points = points
+		s.addCurrent()
+	}
+	return nil
+}
+
+func (s *setupSession) addCurrent() {
+	s.challenges = append(s.challenges, s.current)
+	s.nextID++
+	s.step = setupTitle
+}
+
+func saveSetup(ctx context.Context, config Config, ws *websocket.Conn, channel string, s *setupSession) {
+	if os.Getenv(configKeyEnv) != "" {
+		postText(ws, channel, "The config files are encrypted, so I can't write to them. Add this and re-encrypt:\n"+setupSnippets(s))
+		return
+	}
+
+	_, err := os.Stat(challengesPath(config))
+	toFile := err == nil
+	if toFile && len(s.challenges) > 0 {
+		err = appendChallenges(challengesPath(config), s.challenges)
+		if err != nil {
+			postInternalError(ctx, ws, channel, err, "")
+			return
+		}
+	}
+	if !s.start.IsZero() || !s.end.IsZero() || (!toFile && len(s.challenges) > 0) {
+		puzzles := s.challenges
+		if toFile {
+			puzzles = nil
+		}
+		err = updateConfigFile("config.json", s.start, s.end, puzzles)
+		if err != nil {
+			postInternalError(ctx, ws, channel, err, "")
+			return
+		}
+	}
+	logf(ctx, "saveSetup: %d challenges added", len(s.challenges))
+
+	// Challenges apply right away, the event window on restart.
+	reloaded := config
+	if !toFile {
+		for _, c := range s.challenges {
+			reloaded.Puzzles = append(reloaded.Puzzles, Challenge{ID: c.ID, Level: c.Level, Title: c.Title, Flag: c.Flag, Points: c.Points})
+		}
+	}
+	err = loadChallenges(reloaded)
+	if err != nil {
+		postInternalError(ctx, ws, channel, err, "")
+		return
+	}
+	text := fmt.Sprintf("Saved. %d challenges are loaded.", len(currentChallenges()))
+	if !s.start.IsZero() || !s.end.IsZero() {
+		text += " Restart the bot for the new event window to apply."
+	}
+	postText(ws, channel, text)
+}
+
+// setupSnippets is what saveSetup would have written.
+func setupSnippets(s *setupSession) string {
+	text := ""
+	if !s.start.IsZero() {
+		text += fmt.Sprintf("config.json: \"ctf_start\": %q\n", s.start.Format(time.RFC3339))
+	}
+	if !s.end.IsZero() {
+		text += fmt.Sprintf("config.json: \"ctf_end\": %q\n", s.end.Format(time.RFC3339))
+	}
+	if len(s.challenges) > 0 {
+		entries, err := yaml.Marshal(s.challenges)
+		if err == nil {
+			text += "challenges:\n```" + string(entries) + "```"
+		}
+	}
+	return text
+}
+
+// appendChallenges adds entries at the end of the challenges list, leaving
+// the rest of the file (and its comments) alone.
+func appendChallenges(path string, challenges []setupChallenge) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err := yaml.Marshal(challenges)
+	if err != nil {
+		return err
+	}
+	text := string(data)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	for _, line := range strings.SplitAfter(string(entries), "\n") {
+		if line != "" {
+			text += "  " + line
+		}
+	}
+	return writeFileAtomically(path, []byte(text))
+}
+
+// updateConfigFile sets ctf_start and ctf_end (unless zero) and appends to
+// puzzles. Keys come out sorted.
+func updateConfigFile(path string, start time.Time, end time.Time, puzzles []setupChallenge) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	if !start.IsZero() {
+		fields["ctf_start"] = start.Format(time.RFC3339)
+	}
+	if !end.IsZero() {
+		fields["ctf_end"] = end.Format(time.RFC3339)
+	}
+	if len(puzzles) > 0 {
+		existing, _ := fields["puzzles"].([]interface{})
+		for _, p := range puzzles {
+			existing = append(existing, p)
+		}
+		fields["puzzles"] = existing
+	}
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, append(data, '\n'))
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
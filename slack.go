@@ -31,6 +31,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 //	"sync/atomic"
 
 	"golang.org/x/net/websocket"
@@ -53,7 +54,7 @@ type responseSelf struct {
 // slackStart does a rtm.start, and returns a websocket URL and user ID. The
 // websocket URL can be used to initiate an RTM session.
 func slackStart(token string) (wsurl, id string, err error) {
-	url := fmt.Sprintf("https://slack.com/api/rtm.start?token=%s", token)
+	url := fmt.Sprintf("%srtm.start?token=%s", slackAPIURL, token)
 	resp, err := http.Get(url)
 	if err != nil {
 		return
@@ -95,10 +96,43 @@ type Message struct {
 	Channel   string `json:"channel"`
 	User      string `json:"user"`
 	Text      string `json:"text"`
+	// Files shared with the message, see uploads.go.
+	Files []slackFile `json:"files,omitempty"`
+}
+
+// In channel_rename and group_rename events, channel is an object. getMessage
+// flattens it: Channel is the channel's ID and Text its new name.
+type channelEvent struct {
+	Channel struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
 }
 
 func getMessage(ws *websocket.Conn) (m Message, err error) {
-	err = websocket.JSON.Receive(ws, &m)
+	var data []byte
+	if wsTimeout > 0 {
+		ws.SetReadDeadline(time.Now().Add(wsTimeout))
+	}
+	err = websocket.Message.Receive(ws, &data)
+	if err != nil {
+		return
+	}
+	recordTraffic("rtm in", data)
+	var typ struct {
+		Type string `json:"type"`
+	}
+	err = json.Unmarshal(data, &typ)
+	if err != nil {
+		return
+	}
+	if typ.Type == "channel_rename" || typ.Type == "group_rename" {
+		var e channelEvent
+		err = json.Unmarshal(data, &e)
+		m = Message{Type: typ.Type, Channel: e.Channel.Id, Text: e.Channel.Name}
+		return
+	}
+	err = json.Unmarshal(data, &m)
 	return
 }
 
@@ -106,21 +140,40 @@ var counter uint64
 
 func postMessage(ws *websocket.Conn, m Message) error {
 //	m.Id = atomic.AddUint64(&counter, 1)
-	return websocket.JSON.Send(ws, m)
+	if ws == nil {
+		// Socket Mode, see socketmode.go.
+		return postMessageWebAPI(m)
+	}
+	// ws may be an old connection, see reconnect.go.
+	ws = currentRTMConn()
+	var err error
+	if ws != nil {
+		err = sendFrame(ws, m)
+		if err == nil {
+			return nil
+		}
+	}
+	if webAPIFallbackToken != "" {
+		log.Printf("postMessage: %v, using the Web API", err)
+		return postMessageWebAPI(m)
+	}
+	// Sent once the bot is reconnected.
+	bufferRTM(m)
+	return err
 }
 
 // Starts a websocket-based Real Time API session and return the websocket
 // and the ID of the (bot-)user whom the token belongs to.
-func slackConnect(token string) (*websocket.Conn, string) {
+func slackConnect(token string) (*websocket.Conn, string, error) {
 	wsurl, id, err := slackStart(token)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 
 	ws, err := websocket.Dial(wsurl, "", "https://api.slack.com/")
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
 	}
 
-	return ws, id
+	return ws, id, nil
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+
+	"github.com/slack-go/slack"
+)
+
+// slackClient is the part of the Slack Web API the bot calls through
+// slack-go/slack. Keeping it to an interface means a library upgrade (or a
+// fake, like cmd/loadtest) only has to match these methods. Methods the
+// library doesn't cover go through callSlackAPI instead.
+type slackClient interface {
+	GetUserInfoContext(ctx context.Context, user string) (*slack.User, error)
+	GetUsersContext(ctx context.Context, options ...slack.GetUsersOption) ([]slack.User, error)
+	OpenConversationContext(ctx context.Context, params *slack.OpenConversationParameters) (*slack.Channel, bool, bool, error)
+	GetConversationsContext(ctx context.Context, params *slack.GetConversationsParameters) ([]slack.Channel, string, error)
+}
+
+var newSlackClient = func(config Config) slackClient {
+	return slack.New(config.SlackApiToken, slack.OptionAPIURL(slackAPIURL))
+}
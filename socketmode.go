@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Slack no longer offers RTM to new apps. With config.SlackAppToken (an
+// app-level xapp- token with connections:write), the bot uses Socket Mode
+// instead: events and button clicks arrive over a websocket opened with
+// apps.connections.open, each acknowledged with its envelope_id, and
+// everything the bot says goes through the Web API. Slack authenticates the
+// connection, so there are no signatures to check. The connection is reopened
+// whenever Slack asks for it (it does every few hours) or it breaks.
+
+type socketEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeId string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+type socketAck struct {
+	EnvelopeId string `json:"envelope_id"`
+}
+
+type responseConnectionsOpen struct {
+	Url string `json:"url"`
+}
+
+type responseAuthTest struct {
+	UserId string `json:"user_id"`
+}
+
+// botUserID is what rtm.start would have returned as self.id.
+func botUserID(config Config) (string, error) {
+	var resp responseAuthTest
+	err := callSlackAPI(config.SlackApiToken, "auth.test", nil, &resp)
+	return resp.UserId, err
+}
+
+func openSocket(config Config) (*websocket.Conn, error) {
+	var resp responseConnectionsOpen
+	err := callSlackAPI(config.SlackAppToken, "apps.connections.open", url.Values{}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return websocket.Dial(resp.Url, "", "https://api.slack.com/")
+}
+
+// runSocketMode reads events forever, reconnecting with a backoff of up to
+// 30 seconds.
+func runSocketMode(config Config, db *sql.DB, botID string) {
+	backoff := time.Second
+	for {
+		conn, err := openSocket(config)
+		if err != nil {
+			log.Printf("runSocketMode: %s, retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+		slackConnectedAt = time.Now()
+		err = readSocket(config, db, conn, botID)
+		conn.Close()
+		log.Printf("runSocketMode: reconnecting: %s", err)
+	}
+}
+
+// readSocket handles envelopes until the connection breaks or Slack asks the
+// bot to reconnect.
+func readSocket(config Config, db *sql.DB, conn *websocket.Conn, botID string) error {
+	for {
+		var data []byte
+		err := websocket.Message.Receive(conn, &data)
+		if err != nil {
+			return err
+		}
+		noteRead()
+		recordTraffic("socket in", data)
+		var e socketEnvelope
+		err = json.Unmarshal(data, &e)
+		if err != nil {
+			log.Printf("readSocket: %s", err)
+			continue
+		}
+		if e.EnvelopeId != "" {
+			err = sendFrame(conn, socketAck{EnvelopeId: e.EnvelopeId})
+			if err != nil {
+				return err
+			}
+		}
+
+		switch e.Type {
+		case "hello":
+			log.Printf("readSocket: connected")
+		case "disconnect":
+			return fmt.Errorf("Slack asked to reconnect (%s)", e.Reason)
+		case "events_api":
+			var p eventsPayload
+			err = json.Unmarshal(e.Payload, &p)
+			if err != nil {
+				log.Printf("readSocket: %s", err)
+				continue
+			}
+			noteSlackActivity()
+			if p.Type == "event_callback" && (p.Event.Type == "message" || membershipEvents[p.Event.Type]) {
+				handleMessage(config, db, nil, p.Event, botID)
+			}
+		case "interactive":
+			var p interactivityPayload
+			err = json.Unmarshal(e.Payload, &p)
+			if err != nil || p.Type != "block_actions" || len(p.Actions) == 0 {
+				continue
+			}
+			go handleInteraction(config, db, nil, p.interaction())
+		default:
+			logf(context.Background(), "readSocket: ignoring %s", e.Type)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The bot's presence and status tell users whether submissions are open.
+// Presence uses the bot token. Bots can't set a status, so that requires
+// config.StatusToken, a user token (users.profile:write) for the bot's
+// account; without it only presence is updated.
+
+var lastStatus string
+var lastStatusLock sync.Mutex
+
+func eventStatus(config Config, db *sql.DB, now time.Time) (text string, emoji string, presence string) {
+	return renderStatus(config, currentEventState(config, db, now), now)
+}
+
+func updateBotStatus(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	text, emoji, presence := eventStatus(config, db, time.Now())
+
+	lastStatusLock.Lock()
+	defer lastStatusLock.Unlock()
+	if text == lastStatus {
+		return
+	}
+
+	err := traceSlack(ctx, "users.setPresence", func() error {
+		return callSlackAPI(config.SlackApiToken, "users.setPresence", url.Values{"presence": {presence}}, nil)
+	})
+	if err != nil {
+		logf(ctx, "updateBotStatus: %s", err)
+		return
+	}
+
+	if config.StatusToken != "" {
+		profile, err := json.Marshal(map[string]interface{}{
+			"status_text":       text,
+			"status_emoji":      emoji,
+			"status_expiration": 0,
+		})
+		if err != nil {
+			logf(ctx, "updateBotStatus: %s", err)
+			return
+		}
+		err = traceSlack(ctx, "users.profile.set", func() error {
+			return callSlackAPI(config.StatusToken, "users.profile.set", url.Values{"profile": {string(profile)}}, nil)
+		})
+		if err != nil {
+			logf(ctx, "updateBotStatus: %s", err)
+			return
+		}
+	}
+	logf(ctx, "updateBotStatus: %s", text)
+	lastStatus = text
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// A guess goes through the same steps whether it comes from "validate", a
+// companion server (botapi.go) or a team token (teamapi.go): submitFlag
+// checks it against the team's progress and the level's limits and records
+// it, in the caller's transaction, then afterSubmit delivers what was queued
+// and announces the solve. Callers check their input, rate limit and reply in
+// their own way.
+
+type submission struct {
+	teamID int
+	team   string
+	level  int
+	// Normalized, see normalizeFlag.
+	flag string
+	// Logged as the user.
+	who string
+	// Makes retries safe, "" if there's nothing to dedupe on.
+	msgTs string
+	// Rejections and the unlocked levels are in this user's language.
+	u user
+	// Servers only submit flags they checked, the level's limits don't
+	// apply to them.
+	trusted bool
+	// A file sent with "validate", see uploads.go. Only then does a
+	// "file:<sha256>" flag count.
+	upload bool
+	// reply queues the result, in the same transaction.
+	reply func(r submitResult) []outboxItem
+}
+
+type submitResult struct {
+	challenge Challenge
+	correct   bool
+	event     string
+	// Tries left after this one, negative if the level has no limit.
+	left int
+	// *UserError or *AttemptLimit, in which case nothing was recorded.
+	rejection    error
+	unlocked     []Challenge
+	unlockedText string
+	outbox       []outboxItem
+}
+
+// submitFlag checks and records a guess. It holds the lock on the team's
+// attempt counter, so two simultaneous guesses can't both use the last try.
+func submitFlag(ctx context.Context, config Config, tx *sql.Tx, s submission) (submitResult, error) {
+	r := submitResult{event: "incorrect:" + s.flag}
+	now := submittedAt(ctx)
+	r.challenge, r.correct = matchChallenge(s.level, s.flag, s.upload, now)
+	if r.correct {
+		r.event = r.challenge.event()
+	}
+	rules := levelRules(s.level)
+
+	count, err := lockAttempts(ctx, tx, s.teamID, s.level)
+	if err != nil {
+		return r, err
+	}
+	var solved map[string]bool
+	if r.correct || config.Progression {
+		solved, err = teamSolved(ctx, tx, s.teamID)
+		if err != nil {
+			return r, err
+		}
+	}
+	if !levelUnlocked(config, s.level, solved) {
+		r.rejection = &UserError{tr(config, s.u, "validate.level-locked", "level %d opens once your team solved a challenge of level %d.", s.level, previousLevel(s.level))}
+		return r, nil
+	}
+	if r.correct {
+		if solved[r.event] {
+			r.rejection = &UserError{tr(config, s.u, "validate.already-solved", "your team already found %s.", r.event)}
+			return r, nil
+		}
+		if missing := r.challenge.missingRequirements(solved); len(missing) > 0 {
+			r.rejection = &UserError{tr(config, s.u, "validate.locked", "that flag only counts once your team solved %s.", missing[0].Title)}
+			return r, nil
+		}
+	}
+
+	if !rules.unlimited() && !s.trusted {
+		// Make sure they haven't used all their tries and aren't
+		// cooling down
+		last, err := lastAttempt(ctx, tx, s.teamID, s.level)
+		if err != nil {
+			return r, err
+		}
+		if ok, until := rules.allowed(count, last, now); !ok {
+			limit := &AttemptLimit{Max: rules.max}
+			if !until.IsZero() {
+				limit.Wait = until.Sub(now)
+				limit.Cooldown = rules.left(count) > 0
+			}
+			r.rejection = limit
+			return r, nil
+		}
+	}
+	if rules.max > 0 && !r.correct {
+		dupCount, err := queries(tx).CountTeamEvents(ctx, s.teamID, s.level, r.event)
+		if err != nil {
+			return r, err
+		}
+		if dupCount > 0 {
+			r.rejection = &UserError{tr(config, s.u, "validate.duplicate", "you (or a teammate) already tried that guess")}
+			return r, nil
+		}
+	}
+
+	// Queue the announcements and the result
+	r.left = rules.left(count + 1)
+	if r.correct {
+		r.outbox = append(r.outbox, outboxItem{kind: outboxSolve, text: publicTeamLabel(config, s.teamID, s.team, now), event: r.event})
+		r.unlocked = unlockedBy(config, r.challenge, solved, now)
+		if len(r.unlocked) > 0 {
+			r.unlockedText = describeUnlocked(ctx, config, s.u, r.unlocked, s.teamID, s.team)
+		}
+	} else if r.left == 0 && rules.lockout == 0 {
+		r.outbox = append(r.outbox, outboxItem{kind: outboxAnnounce, text: fmt.Sprintf("Team %s ran out of tries! :(", publicTeamLabel(config, s.teamID, s.team, now))})
+	}
+	if s.reply != nil {
+		r.outbox = append(r.outbox, s.reply(r)...)
+	}
+
+	// Record log event
+	entry := store.InsertLogParams{
+		User:   s.who,
+		Event:  r.event,
+		Level:  sql.NullInt64{Int64: int64(s.level), Valid: true},
+		TeamID: sql.NullInt64{Int64: int64(s.teamID), Valid: true},
+		Ref:    correlationID(ctx),
+		MsgTs:  msgTsValue(s.msgTs),
+	}
+	err = recordEvent(ctx, tx, r.outbox, entry)
+	if err != nil {
+		return r, err
+	}
+	if at, ok := replayedAt(ctx); ok {
+		err = backdateEvent(ctx, tx, entry, at)
+		if err != nil {
+			return r, err
+		}
+	}
+	if !r.correct && attemptsPaused(s.level) {
+		return r, nil
+	}
+	return r, incrementAttempts(ctx, tx, s.teamID, s.level)
+}
+
+// afterSubmit runs once the guess is committed. notice tells the rest of the
+// team (all of it if except is "") about a solve.
+func afterSubmit(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, s submission, r submitResult, except string, notice string) {
+	if r.rejection != nil {
+		return
+	}
+	if r.correct {
+		solves, err := queries(db).CountSolves(ctx, r.event)
+		if err != nil {
+			logf(ctx, "afterSubmit: %s", err)
+		} else if solves == 1 {
+			noteMajorEvent(fmt.Sprintf("First blood on %s: Team %s", r.event, publicTeamLabel(config, s.teamID, s.team, time.Now())))
+		}
+	}
+	deliverOutbox(ctx, config, db, ws, r.outbox)
+	if !r.correct {
+		return
+	}
+	notifyTeam(ctx, config, db, ws, s.teamID, except, "teammate-solves", notice)
+	if r.unlockedText != "" {
+		notifyTeam(ctx, config, db, ws, s.teamID, except, "unlocks", r.unlockedText)
+	}
+	announceCombo(ctx, config, db, ws, s.teamID, s.team, r.event)
+	checkLeadChange(ctx, config, db, ws)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// "suggest" picks a challenge for the caller's team to try next, at random
+// among the released challenges it can solve, weighted by:
+// - how many of the teams which started solved it
+// - how much of the challenge's category the team solved already
+// - in the last hour, easy challenges weigh even more, there's no time left
+//   for the hard ones
+
+// lastHour is when suggest favours easy challenges.
+const lastHour = time.Hour
+
+type suggestion struct {
+	challenge Challenge
+	solves    int
+	strong    bool
+	weight    float64
+}
+
+// suggestionWeights returns the challenges team can try next, with their
+// weight. solves is per event, started the teams which started.
+func suggestionWeights(config Config, solved map[string]bool, solves map[string]int, started int, now time.Time) []suggestion {
+	// Per category, the share of its released challenges the team solved.
+	total, done := map[string]int{}, map[string]int{}
+	for _, c := range currentChallenges() {
+		if c.Category == "" || !c.released(now) {
+			continue
+		}
+		total[c.Category]++
+		if solved[c.event()] {
+			done[c.Category]++
+		}
+	}
+
+	endsSoon := !config.CtfEnd.IsZero() && config.CtfEnd.Sub(now) < lastHour
+	suggestions := []suggestion{}
+	for _, c := range currentChallenges() {
+		if solved[c.event()] || !c.released(now) || !levelUnlocked(config, c.Level, solved) || len(c.missingRequirements(solved)) > 0 {
+			continue
+		}
+		// Smoothed, so unsolved challenges still come up.
+		rate := float64(solves[c.event()]+1) / float64(started+2)
+		s := suggestion{challenge: c, solves: solves[c.event()], weight: rate}
+		if total[c.Category] > 0 {
+			strength := float64(done[c.Category]) / float64(total[c.Category])
+			s.weight *= 1 + strength
+			s.strong = strength >= 0.5
+		}
+		if endsSoon {
+			s.weight *= rate * 2
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions
+}
+
+// pickSuggestion draws one suggestion, the heavier the likelier.
+func pickSuggestion(suggestions []suggestion, r *rand.Rand) suggestion {
+	sum := 0.0
+	for _, s := range suggestions {
+		sum += s.weight
+	}
+	x := r.Float64() * sum
+	for _, s := range suggestions {
+		x -= s.weight
+		if x < 0 {
+			return s
+		}
+	}
+	return suggestions[len(suggestions)-1]
+}
+
+// challengeSolves returns the solves of each released challenge, test teams
+// excluded.
+func challengeSolves(ctx context.Context, db *sql.DB, now time.Time) (map[string]int, error) {
+	solves := map[string]int{}
+	for _, c := range currentChallenges() {
+		if !c.released(now) {
+			continue
+		}
+		n, err := queries(db).CountSolves(ctx, c.event())
+		if err != nil {
+			return nil, err
+		}
+		solves[c.event()] = n
+	}
+	return solves, nil
+}
+
+// suggest
+func doSuggest(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	row, err := userTeam(ctx, db, u.username)
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	now := time.Now()
+	if currentEventState(config, db, now) == eventUpcoming {
+		postText(ws, m.Channel, fmt.Sprintf("The CTF starts in %s, come back then!", formatDuration(config.CtfStart.Sub(now))))
+		return
+	}
+	stats := readDB(db)
+	solved, err := teamSolved(ctx, stats, row.ID)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	solves, err := challengeSolves(ctx, stats, now)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	started, err := startedTeams(ctx, stats)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+
+	suggestions := suggestionWeights(config, solved, solves, started, now)
+	if len(suggestions) == 0 {
+		postText(ws, m.Channel, tr(config, u, "suggest.none", "Your team solved everything it can for now, well done!"))
+		return
+	}
+	s := pickSuggestion(suggestions, rand.New(rand.NewSource(now.UnixNano())))
+	c := s.challenge
+	text := tr(config, u, "suggest.pick", "Try challenge %d, %s (level %d): %d/%d teams solved it.", c.ID, c.Title, c.Level, s.solves, started)
+	if s.strong {
+		text += " " + tr(config, u, "suggest.strong", "Your team is good at %s.", c.Category)
+	}
+	logf(ctx, "doSuggest: %s got challenge %d out of %d", u.username, c.ID, len(suggestions))
+	postText(ws, m.Channel, text)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// taunt <team> posts one of the organizers' pre-approved taunts (config.Taunts,
+// Go templates with .Team and .Target) in the public channel. Players can't
+// write their own. Each team can taunt once every taunt_cooldown_minutes; the
+// time of its last taunt is kept in bot_state ("taunt:<team id>") so a restart
+// doesn't reset it.
+
+var tauntLock sync.Mutex
+
+func tauntCooldown(config Config) time.Duration {
+	if config.TauntCooldownMinutes <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(config.TauntCooldownMinutes) * time.Minute
+}
+
+type tauntData struct {
+	Team   string
+	Target string
+}
+
+// taunt <team>
+func doTaunt(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if len(config.Taunts) == 0 {
+		postError(ctx, ws, m.Channel, "sorry, taunts are turned off.", m.User)
+		return
+	}
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	row, err := userTeam(ctx, db, u.username)
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+	target := strings.Join(args, " ")
+	targetID, targetName, err := findTeam(ctx, db, target)
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, I don't know team %s.", escapeText(target)), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if targetID == row.ID {
+		postError(ctx, ws, m.Channel, "sorry, you can't taunt your own team.", m.User)
+		return
+	}
+
+	tauntLock.Lock()
+	defer tauntLock.Unlock()
+	key := fmt.Sprintf("taunt:%d", row.ID)
+	last, err := getBotState(ctx, db, key)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if unix, err := strconv.ParseInt(last, 10, 64); err == nil {
+		wait := time.Unix(unix, 0).Add(tauntCooldown(config)).Sub(time.Now())
+		if wait > 0 {
+			postError(ctx, ws, m.Channel, fmt.Sprintf("sorry, your team can taunt again in %s.", formatDuration(wait)), m.User)
+			return
+		}
+	}
+
+	text, err := renderTemplate(config.Taunts[rand.Intn(len(config.Taunts))], tauntData{
+		Team:   publicTeamLabel(config, row.ID, row.Name, time.Now()),
+		Target: publicTeamLabel(config, targetID, targetName, time.Now()),
+	})
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	err = setBotState(ctx, db, key, strconv.FormatInt(time.Now().Unix(), 10))
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doTaunt: team %d taunted team %d", row.ID, targetID)
+	announce(config, db, ws, text)
+	if m.Channel != getPublicChannel() {
+		postText(ws, m.Channel, "Taunt sent!")
+	}
+}
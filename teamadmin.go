@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// Admin commands to manage players and levels from Slack during the event,
+// instead of editing the users table by hand. Users are given as a mention
+// (@alice) or a username.
+
+// userArg turns a mention (<@U123> or <@U123|alice>) or a username into a
+// username.
+func userArg(ctx context.Context, config Config, arg string) (string, error) {
+	if strings.HasPrefix(arg, "<@") && strings.HasSuffix(arg, ">") {
+		id := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(arg, "<@"), ">"), "|", 2)[0]
+		u, err := resolveUser(ctx, config, id)
+		return u.username, err
+	}
+	return strings.TrimPrefix(arg, "@"), nil
+}
+
+// admin add-user <user> [<team>]
+func doAdminAddUser(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, _ := resolveUser(ctx, config, m.User) // English if it fails
+	username, err := userArg(ctx, config, args[0])
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	team := sql.NullInt64{}
+	teamName := ""
+	if len(args) > 1 {
+		id, name, err := findTeam(ctx, db, strings.Join(args[1:], " "))
+		if err == sql.ErrNoRows {
+			postError(ctx, ws, m.Channel, tr(config, u, "admin.no-team", "sorry, there is no team %s.", escapeText(strings.Join(args[1:], " "))), m.User)
+			return
+		}
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		team = sql.NullInt64{Int64: int64(id), Valid: true}
+		teamName = name
+	}
+	_, err = dbExec(ctx, db, "INSERT INTO users (user, team) VALUES (?, ?)", username, team)
+	if isDuplicateKey(err) {
+		postError(ctx, ws, m.Channel, tr(config, u, "admin.already-player", "%s is already a player, use admin assign-team to move them.", escapeText(username)), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminAddUser: %s added %s (team %v)", m.User, username, team)
+	if teamName == "" {
+		postText(ws, m.Channel, tr(config, u, "admin.added", "Added %s, who can now start a team.", escapeText(username)))
+		return
+	}
+	postText(ws, m.Channel, tr(config, u, "admin.added-to-team", "Added %s to team %s.", escapeText(username), teamLabel(config, int(team.Int64), teamName)))
+}
+
+// admin assign-team <user> <team>
+func doAdminAssignTeam(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, _ := resolveUser(ctx, config, m.User) // English if it fails
+	username, err := userArg(ctx, config, args[0])
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	id, name, err := findTeam(ctx, db, strings.Join(args[1:], " "))
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, tr(config, u, "admin.no-team", "sorry, there is no team %s.", escapeText(strings.Join(args[1:], " "))), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	res, err := dbExec(ctx, db, "UPDATE users SET team=? WHERE user=?", id, username)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		postError(ctx, ws, m.Channel, tr(config, u, "admin.not-player", "%s isn't a player (or already on that team), see admin add-user.", escapeText(username)), m.User)
+		return
+	}
+	logf(ctx, "doAdminAssignTeam: %s moved %s to team %d", m.User, username, id)
+	postText(ws, m.Channel, tr(config, u, "admin.assigned", "%s is now on team %s.", escapeText(username), teamLabel(config, id, name)))
+}
+
+// Everything a team earned. Its members and name are kept.
+var resetTeamStatements = []string{
+	"DELETE FROM logs WHERE team_id=?",
+	"DELETE FROM scoreboard WHERE team_id=?",
+	"DELETE FROM attempts WHERE team_id=?",
+	"DELETE FROM awards WHERE team_id=?",
+	"DELETE FROM easter_eggs WHERE team_id=?",
+}
+
+// admin reset-team <team> confirm
+func doAdminResetTeam(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, _ := resolveUser(ctx, config, m.User) // English if it fails
+	confirmed := len(args) > 1 && args[len(args)-1] == "confirm"
+	if confirmed {
+		args = args[:len(args)-1]
+	}
+	id, name, err := findTeam(ctx, db, strings.Join(args, " "))
+	if err == sql.ErrNoRows {
+		postError(ctx, ws, m.Channel, tr(config, u, "admin.no-team", "sorry, there is no team %s.", escapeText(strings.Join(args, " "))), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if !confirmed {
+		postText(ws, m.Channel, tr(config, u, "admin.reset-confirm", "This deletes every flag, guess and award of team %s. Say \"admin reset-team %s confirm\" to go ahead.", teamLabel(config, id, name), escapeText(strings.Join(args, " "))))
+		return
+	}
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range resetTeamStatements {
+			_, err := dbExec(ctx, tx, stmt, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	logf(ctx, "doAdminResetTeam: %s reset team %d", m.User, id)
+	postText(ws, m.Channel, tr(config, u, "admin.reset-done", "Team %s starts from scratch.", teamLabel(config, id, name)))
+}
+
+// Levels opened early with "admin open-level" are released regardless of
+// their challenges' release times. They're kept in bot_state, as
+// "open-level:<n>".
+var openedLevels = map[int]bool{}
+var openedLevelsLock sync.RWMutex
+
+func levelOpened(level int) bool {
+	openedLevelsLock.RLock()
+	defer openedLevelsLock.RUnlock()
+	return openedLevels[level]
+}
+
+func loadOpenedLevels(ctx context.Context, db *sql.DB) error {
+	rows, err := dbQuery(ctx, db, "SELECT name FROM bot_state WHERE name LIKE 'open-level:%'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	levels := map[int]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		level, err := strconv.Atoi(strings.TrimPrefix(name, "open-level:"))
+		if err == nil {
+			levels[level] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	openedLevelsLock.Lock()
+	defer openedLevelsLock.Unlock()
+	openedLevels = levels
+	return nil
+}
+
+// admin open-level <n>
+func doAdminOpenLevel(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, _ := resolveUser(ctx, config, m.User) // English if it fails
+	level, err := strconv.Atoi(args[0])
+	if err != nil || level < 1 || level > maxLevel() {
+		postError(ctx, ws, m.Channel, tr(config, u, "admin.no-level", "there is no level %s.", escapeText(args[0])), m.User)
+		return
+	}
+	if levelOpened(level) {
+		postText(ws, m.Channel, tr(config, u, "admin.level-already-open", "Level %d is already open.", level))
+		return
+	}
+	err = setBotState(ctx, db, fmt.Sprintf("open-level:%d", level), "1")
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	openedLevelsLock.Lock()
+	openedLevels[level] = true
+	openedLevelsLock.Unlock()
+	logf(ctx, "doAdminOpenLevel: %s opened level %d", m.User, level)
+	postText(ws, m.Channel, tr(config, u, "admin.level-open", "Level %d is open.", level))
+	announce(config, db, ws, fmt.Sprintf("Level %d is open!", level))
+	go notifyPlayers(ctx, config, db, ws, "challenge-releases", fmt.Sprintf("Level %d is open! Say `challenges` to see it.", level))
+}
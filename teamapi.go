@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// With team_tokens, captains can create API tokens for their team's own
+// tools (dashboards, solvers) with "token create", on http_listen:
+//
+//	GET /api/team
+//	Authorization: Bearer <token>
+//
+// returns the team's points, solves and tries per level, and
+//
+//	POST /api/team/submit
+//	{"level": 3, "flag": "flag{...}", "id": "req-42"}
+//
+// submits a guess like "validate" would: it counts as a try, is logged (as
+// user "token:<id>") and obeys the level's limits and the rate limit. Levels
+// with a proof of work only take guesses in Slack. Only the SHA-256 of a
+// token is stored; "token revoke" revokes all the team's tokens. What a
+// token can read goes through store.TeamQueries, scoped to its team.
+
+const teamTokenPrefix = "amigo_"
+
+func newTeamToken() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return teamTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func hashTeamToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// token create|revoke
+func doToken(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if !config.TeamTokens || config.HTTPListen == "" {
+		postError(ctx, ws, m.Channel, tr(config, u, "token.disabled", "the organizers didn't enable team API tokens."), m.User)
+		return
+	}
+	if !isPrivate(m.Channel) {
+		postError(ctx, ws, m.Channel, tr(config, u, "token.private", "please ask for tokens in a private message."), m.User)
+		return
+	}
+	row, err := userTeam(ctx, db, u.username)
+	if err != nil {
+		reportError(ctx, config, ws, m.Channel, u, err, m.User)
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		token, err := newTeamToken()
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		err = queries(db).CreateTeamToken(ctx, row.ID, hashTeamToken(token), u.username)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		logf(ctx, "doToken: %s created a token for team %d", u.username, row.ID)
+		postText(ws, m.Channel, tr(config, u, "token.created", "Here is an API token for team %s, keep it secret: `%s`\nSend it as `Authorization: Bearer <token>` to GET /api/team for your team's status, or to POST /api/team/submit with `{\"level\": 1, \"flag\": \"...\"}` to submit a flag. `token revoke` revokes all your team's tokens.", row.Name, token))
+	case "revoke":
+		n, err := queries(db).RevokeTeamTokens(ctx, row.ID)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		logf(ctx, "doToken: %s revoked %d tokens of team %d", u.username, n, row.ID)
+		postText(ws, m.Channel, tr(config, u, "token.revoked", "Revoked %d tokens.", n))
+	default:
+		postError(ctx, ws, m.Channel, tr(config, u, "token.usage", "send `token create` or `token revoke`."), m.User)
+	}
+}
+
+// requestTeam returns the queries of the team whose token the request
+// carries, nil if there is none or it isn't valid.
+func requestTeam(ctx context.Context, db *sql.DB, r *http.Request) (*store.TeamQueries, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !strings.HasPrefix(token, teamTokenPrefix) {
+		return nil, nil
+	}
+	t, err := queries(db).ForToken(ctx, hashTeamToken(token))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// teamAPIHandler authenticates the request and runs f with its team.
+func teamAPIHandler(db *sql.DB, method string, f func(ctx context.Context, w http.ResponseWriter, r *http.Request, t *store.TeamQueries)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeAPIResult(w, http.StatusMethodNotAllowed, apiResult{Error: method + " only"})
+			return
+		}
+		ctx := withCorrelationID(r.Context(), newCorrelationID())
+		t, err := requestTeam(ctx, db, r)
+		if err != nil {
+			logf(ctx, "teamAPIHandler: %s", err)
+			writeAPIResult(w, http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)})
+			return
+		}
+		if t == nil {
+			writeAPIResult(w, http.StatusUnauthorized, apiResult{Error: "bad or missing token"})
+			return
+		}
+		f(ctx, w, r, t)
+	})
+}
+
+type teamStatus struct {
+	TeamID   int                  `json:"team_id"`
+	Name     string               `json:"name"`
+	Points   int                  `json:"points"`
+	Solves   []store.TeamSolve    `json:"solves"`
+	Attempts []store.TeamAttempts `json:"attempts"`
+}
+
+func collectTeamStatus(ctx context.Context, config Config, db *sql.DB, t *store.TeamQueries) (teamStatus, error) {
+	s := teamStatus{TeamID: t.TeamID()}
+	var err error
+	if s.Name, err = t.Name(ctx); err != nil {
+		return s, err
+	}
+	if s.Solves, err = t.Solves(ctx); err != nil {
+		return s, err
+	}
+	if s.Attempts, err = t.Attempts(ctx); err != nil {
+		return s, err
+	}
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		return s, err
+	}
+	for _, score := range scores {
+		if score.teamID == t.TeamID() {
+			s.Points = score.total()
+		}
+	}
+	return s, nil
+}
+
+type teamSubmission struct {
+	Level int    `json:"level"`
+	Flag  string `json:"flag"`
+	ID    string `json:"id"`
+}
+
+func startTeamAPI(mux *http.ServeMux, config Config, db *sql.DB, ws *websocket.Conn) {
+	mux.Handle("/api/team", teamAPIHandler(db, http.MethodGet, func(ctx context.Context, w http.ResponseWriter, r *http.Request, t *store.TeamQueries) {
+		s, err := collectTeamStatus(ctx, config, readDB(db), t)
+		if err == sql.ErrNoRows {
+			writeAPIResult(w, http.StatusNotFound, apiResult{Error: "the team hasn't started"})
+			return
+		}
+		if err != nil {
+			logf(ctx, "/api/team: %s", err)
+			writeAPIResult(w, http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	}))
+	mux.Handle("/api/team/submit", teamAPIHandler(db, http.MethodPost, func(ctx context.Context, w http.ResponseWriter, r *http.Request, t *store.TeamQueries) {
+		var s teamSubmission
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize)).Decode(&s)
+		if err != nil || s.Flag == "" || len(s.ID) > 20 {
+			writeAPIResult(w, http.StatusBadRequest, apiResult{Error: "expected level, flag and an optional id of at most 20 characters"})
+			return
+		}
+		status, result := teamSubmit(ctx, config, db, ws, t, s)
+		writeAPIResult(w, status, result)
+	}))
+}
+
+// teamSubmit checks and records a guess sent with a team token, returning
+// the HTTP status and body of the response.
+func teamSubmit(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, t *store.TeamQueries, s teamSubmission) (int, apiResult) {
+	username := fmt.Sprintf("token:%d", t.TokenID())
+	teamID := t.TeamID()
+	flag := normalizeFlag(s.Flag)
+	logf(ctx, "teamSubmit: %s for team %d, level %d: %s", username, teamID, s.Level, flag)
+	now := time.Now()
+	switch {
+	case currentEventState(config, db, now) != eventLive:
+		return http.StatusConflict, apiResult{Error: "the CTF isn't running"}
+	case s.Level < 1 || s.Level > maxLevel():
+		return http.StatusNotFound, apiResult{Error: fmt.Sprintf("there is no level %d", s.Level)}
+	case len(flag) > maxFlagLength:
+		return http.StatusBadRequest, apiResult{Error: fmt.Sprintf("flags are at most %d characters long", maxFlagLength)}
+	case levelRules(s.Level).pow > 0:
+		return http.StatusConflict, apiResult{Error: "this level needs a proof of work, send the guess in Slack"}
+	case !formatMatches(s.Level, flag, now):
+		return http.StatusUnprocessableEntity, apiResult{Error: "that doesn't look like a flag for this level"}
+	}
+	teamName, err := t.Name(ctx)
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, apiResult{Error: "the team hasn't started"}
+	}
+	if err != nil {
+		logf(ctx, "teamSubmit: %s", err)
+		return http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)}
+	}
+	if ok, wait, alert := takeGuessToken(config.RateLimit, teamID, s.Level, now); !ok {
+		if alert {
+			logf(ctx, "teamSubmit: suspicious burst from team %d on level %d (%s)", teamID, s.Level, username)
+			dmAdmins(ctx, config, db, ws, fmt.Sprintf(":rotating_light: Team %s had %d guesses in a row on level %d turned down by the rate limit, through its API token %d. Scripted guessing?", teamLabel(config, teamID, teamName), config.RateLimit.alertAfter(), s.Level, t.TokenID()))
+		}
+		return http.StatusTooManyRequests, apiResult{Error: fmt.Sprintf("slow down, wait %s", formatWait(wait))}
+	}
+
+	sub := submission{teamID: teamID, team: teamName, level: s.Level, flag: flag, who: username, msgTs: s.ID}
+	var r submitResult
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		r, err = submitFlag(ctx, config, tx, sub)
+		return err
+	})
+	if isDuplicateKey(err) {
+		logf(ctx, "teamSubmit: duplicate request %s", s.ID)
+		return handledResult(ctx, db, username, s.ID)
+	}
+	if err != nil {
+		logf(ctx, "teamSubmit: %s", err)
+		return http.StatusInternalServerError, apiResult{Error: "internal error, ref " + correlationID(ctx)}
+	}
+	if r.rejection != nil {
+		return http.StatusConflict, apiResult{Error: r.rejection.Error()}
+	}
+	afterSubmit(ctx, config, db, ws, sub, r, "", fmt.Sprintf("Your team's API token found %s!", r.event))
+	if !r.correct {
+		result := apiResult{Result: "incorrect"}
+		if r.left >= 0 {
+			result.Result = fmt.Sprintf("incorrect, %d tries left", r.left)
+		}
+		return http.StatusOK, result
+	}
+	return http.StatusOK, apiResult{Result: "correct", Event: r.event}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// renderTemplate executes a Go template from config.json (celebrations,
+// taunts) with data.
+func renderTemplate(text string, data interface{}) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
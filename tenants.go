@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// With -tenants <dir>, one deployment of the bot serves several
+// organizations. Each subdirectory of dir is a tenant with its own
+// config.json (its own Slack workspace and MySQL schema), challenges.yaml and
+// locales. The bot keeps a workspace's state (caches, the public channel,
+// pending announcements...) in package variables, so each tenant runs as a
+// child process of this one, started in its directory. A tenant which exits
+// is restarted after tenantRestartDelay; its output is prefixed with its
+// name. On SIGINT or SIGTERM the signal is passed on to the tenants and the
+// bot exits once they all did.
+
+const tenantRestartDelay = 10 * time.Second
+
+// tenantDirs returns the subdirectories of dir which have a config.json (or
+// config.json.age).
+func tenantDirs(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		for _, name := range []string{"config.json", "config.json.age"} {
+			if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+				dirs = append(dirs, path)
+				break
+			}
+		}
+	}
+	return dirs, nil
+}
+
+func runTenants(dir string) {
+	dirs, err := tenantDirs(dir)
+	if err != nil {
+		log.Panicf("Failed to list tenants: %s", err)
+	}
+	if len(dirs) == 0 {
+		log.Panicf("No tenants in %s (expected <name>/config.json)", dir)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		log.Panicf("Failed to find the bot's executable: %s", err)
+	}
+
+	var lock sync.Mutex
+	running := map[string]*exec.Cmd{}
+	stop := make(chan struct{})
+	var tenants sync.WaitGroup
+	for _, d := range dirs {
+		tenants.Add(1)
+		go func(d string) {
+			defer tenants.Done()
+			name := filepath.Base(d)
+			for {
+				lock.Lock()
+				select {
+				case <-stop:
+					lock.Unlock()
+					return
+				default:
+				}
+				cmd, output, err := startTenant(self, d, name)
+				if err == nil {
+					running[name] = cmd
+				}
+				lock.Unlock()
+				if err == nil {
+					log.Printf("tenant %s: started (pid %d)", name, cmd.Process.Pid)
+					// Wait must only be called once the output is read.
+					output.Wait()
+					err = cmd.Wait()
+					lock.Lock()
+					delete(running, name)
+					lock.Unlock()
+				}
+				select {
+				case <-stop:
+					log.Printf("tenant %s: exited: %v", name, err)
+					return
+				default:
+				}
+				log.Printf("tenant %s: exited: %v, restarting in %s", name, err, tenantRestartDelay)
+				select {
+				case <-stop:
+					return
+				case <-time.After(tenantRestartDelay):
+				}
+			}
+		}(d)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	lock.Lock()
+	close(stop)
+	for name, cmd := range running {
+		log.Printf("tenant %s: stopping", name)
+		cmd.Process.Signal(sig)
+	}
+	lock.Unlock()
+	tenants.Wait()
+}
+
+// startTenant starts the bot in the tenant's directory. output is done once
+// all of the tenant's output was logged.
+func startTenant(self string, dir string, name string) (*exec.Cmd, *sync.WaitGroup, error) {
+	cmd := exec.Command(self)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		prefixLines(name, stdout)
+	}()
+	go func() {
+		defer output.Done()
+		prefixLines(name, stderr)
+	}()
+	return cmd, &output, nil
+}
+
+// Longer lines (e.g. a dumped Slack payload) stop the prefixing, the rest of
+// the output is then dropped so the tenant doesn't block writing it.
+const maxTenantLine = 1 << 20
+
+func prefixLines(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxTenantLine)
+	for scanner.Scan() {
+		log.Printf("[%s] %s", name, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("[%s] dropping the rest of its output: %s", name, err)
+		io.Copy(ioutil.Discard, r)
+	}
+}
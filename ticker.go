@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"golang.org/x/net/websocket"
+)
+
+// With config.SolveTicker, every accepted flag updates a per-level solve
+// count in the public channel ("Level 2: 7/35 teams solved"), which gives a
+// sense of pace without posting the scoreboard:
+// - "post" posts the line of the solved level
+// - "pin" keeps one pinned message with every level up to date, like the
+//   countdown; its timestamp is kept in bot_state ("ticker_ts")
+// "admin feature off ticker" pauses it. A team solved a level when it solved
+// any of its challenges; teams count once they started.
+
+const (
+	tickerPost = "post"
+	tickerPin  = "pin"
+)
+
+var tickerLock sync.Mutex
+
+// levelSolves returns how many teams solved each level, and how many teams
+// started. Test teams don't count.
+func levelSolves(ctx context.Context, db *sql.DB) (map[int]int, int, error) {
+	var started int
+	err := dbQueryRow(ctx, db, "SELECT COUNT(DISTINCT users.team) FROM logs JOIN users ON users.user = logs.user WHERE logs.event='start' AND users.team < ?", store.TestTeamID).Scan(&started)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := dbQuery(ctx, db, "SELECT team_id, event FROM scoreboard WHERE team_id < ?", store.TestTeamID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	teams := map[int]map[int]bool{}
+	for rows.Next() {
+		var teamID int
+		var event string
+		err = rows.Scan(&teamID, &event)
+		if err != nil {
+			return nil, 0, err
+		}
+		c, ok := challengeByEvent(event)
+		if !ok {
+			continue
+		}
+		if teams[c.Level] == nil {
+			teams[c.Level] = map[int]bool{}
+		}
+		teams[c.Level][teamID] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	solves := map[int]int{}
+	for level, t := range teams {
+		solves[level] = len(t)
+	}
+	return solves, started, nil
+}
+
+func renderTickerLine(level int, solves int, started int) string {
+	return fmt.Sprintf("Level %d: %d/%d teams solved", level, solves, started)
+}
+
+// tickerText has a line per level which has been released or solved.
+func tickerText(solves map[int]int, started int, released map[int]bool) string {
+	levels := []int{}
+	for level := range released {
+		levels = append(levels, level)
+	}
+	for level := range solves {
+		if !released[level] {
+			levels = append(levels, level)
+		}
+	}
+	sort.Ints(levels)
+	lines := []string{}
+	for _, level := range levels {
+		lines = append(lines, renderTickerLine(level, solves[level], started))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// updateTicker runs after event (e.g. "flag 3") was accepted.
+func updateTicker(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, event string) {
+	if config.SolveTicker == "" || !featureEnabled(db, "ticker") {
+		return
+	}
+	c, ok := challengeByEvent(event)
+	if !ok {
+		return
+	}
+	tickerLock.Lock()
+	defer tickerLock.Unlock()
+	solves, started, err := levelSolves(ctx, db)
+	if err != nil {
+		logf(ctx, "updateTicker: %s", err)
+		return
+	}
+	if config.SolveTicker == tickerPost {
+		postText(ws, getPublicChannel(), renderTickerLine(c.Level, solves[c.Level], started))
+		return
+	}
+	released := map[int]bool{}
+	now := submittedAt(ctx)
+	for _, c := range currentChallenges() {
+		if c.released(now) {
+			released[c.Level] = true
+		}
+	}
+	updatePinned(ctx, config, db, "ticker_ts", tickerText(solves, started, released))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// With config.TopicRefreshMinutes, the public channel's topic follows the
+// event, e.g. "CTF live • 42 teams • leader: Team X • ends 18:00 UTC". Slack
+// posts a notice in the channel for every topic change and rate-limits them,
+// so the topic is only set when it changed, and at most once per interval.
+
+var lastTopic string
+var lastTopicAt time.Time
+var lastTopicLock sync.Mutex
+
+func topicText(ctx context.Context, config Config, db *sql.DB, now time.Time) (string, error) {
+	teams, err := queries(db).CountTeams(ctx)
+	if err != nil {
+		return "", err
+	}
+	scores, err := computeScores(ctx, config, db)
+	if err != nil {
+		return "", err
+	}
+	leader := ""
+	if len(scores) > 0 && scores[0].numFlags() > 0 {
+		leader = "Team " + publicTeamLabel(config, scores[0].teamID, scores[0].teamName, now)
+	}
+
+	state := currentEventState(config, db, now)
+	switch state {
+	case eventUpcoming:
+		return fmt.Sprintf("CTF starts %s UTC • %d teams", config.CtfStart.UTC().Format("Jan 2 15:04"), teams), nil
+	case eventFinished:
+		if leader == "" {
+			return "CTF over", nil
+		}
+		return "CTF over • winner: " + leader, nil
+	}
+	text := "CTF live"
+	if state == eventPaused {
+		text = "CTF paused"
+	}
+	text += fmt.Sprintf(" • %d teams", teams)
+	if leader != "" {
+		text += " • leader: " + leader
+	}
+	if !config.CtfEnd.IsZero() {
+		text += " • ends " + config.CtfEnd.UTC().Format("15:04") + " UTC"
+	}
+	return text, nil
+}
+
+// updateTopic runs every minute.
+func updateTopic(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	publicChannel := getPublicChannel()
+	if config.TopicRefreshMinutes <= 0 || publicChannel == "" {
+		return
+	}
+	lastTopicLock.Lock()
+	defer lastTopicLock.Unlock()
+	now := time.Now()
+	if now.Sub(lastTopicAt) < time.Duration(config.TopicRefreshMinutes)*time.Minute {
+		return
+	}
+
+	text, err := topicText(ctx, config, db, now)
+	if err != nil {
+		logf(ctx, "updateTopic: %s", err)
+		return
+	}
+	if text == lastTopic {
+		return
+	}
+	// Whatever happens, wait a full interval before trying again.
+	lastTopicAt = now
+	err = setChannelTopic(ctx, config, publicChannel, text)
+	if err != nil {
+		logf(ctx, "updateTopic: %s", err)
+		return
+	}
+	logf(ctx, "updateTopic: %s", text)
+	lastTopic = text
+}
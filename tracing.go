@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/alokmenghrajani/mybot/internal/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Every command runs in a span, with child spans for each Slack API call and
+// DB query. Spans are exported over OTLP/HTTP to config.OtelEndpoint. When no
+// endpoint is configured the global no-op tracer is used.
+
+func tracer() trace.Tracer {
+	return otel.Tracer("amigo_bot")
+}
+
+// initTracing returns a function which flushes pending spans.
+func initTracing(config Config) func() {
+	if config.OtelEndpoint == "" {
+		return func() {}
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.OtelEndpoint)}
+	if config.OtelInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		log.Panicf("Failed to create trace exporter: %s", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", config.BotName))),
+	)
+	otel.SetTracerProvider(tp)
+	return func() {
+		err := tp.Shutdown(context.Background())
+		if err != nil {
+			log.Printf("tp.Shutdown: %s", err)
+		}
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceSlack runs a Slack Web API call in a span.
+func traceSlack(ctx context.Context, method string, call func() error) error {
+	_, span := tracer().Start(ctx, "slack."+method)
+	err := call()
+	endSpan(span, err)
+	return err
+}
+
+// sqlConn is satisfied by both *sql.DB and *sql.Tx.
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Set from config.TablePrefix and config.DatabaseDriver on startup, see
+// internal/store/prefix.go and internal/store/dialect.go. Every query goes
+// through the db* functions below, which apply them.
+var tablePrefix string
+var dialect store.Dialect = store.MySQL{}
+
+// rewrite translates query for the dialect and prefixes its tables.
+func rewrite(query string) (string, error) {
+	query, err := dialect.Rewrite(query)
+	if err != nil {
+		return "", err
+	}
+	return store.Prefix(query, tablePrefix), nil
+}
+
+// errRow is the row of a query which couldn't run.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...interface{}) error { return r.err }
+
+// tracedRow ends the query's span once the row is scanned, so the span covers
+// the scan and records its error.
+type tracedRow struct {
+	row  *sql.Row
+	span trace.Span
+}
+
+func (r tracedRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	endSpan(r.span, err)
+	return err
+}
+
+func dbQueryRow(ctx context.Context, db sqlConn, query string, args ...interface{}) store.Row {
+	query, err := rewrite(query)
+	if err != nil {
+		return errRow{err}
+	}
+	ctx, span := tracer().Start(ctx, "db.QueryRow", trace.WithAttributes(attribute.String("db.statement", query)))
+	return tracedRow{db.QueryRowContext(ctx, query, args...), span}
+}
+
+func dbQuery(ctx context.Context, db sqlConn, query string, args ...interface{}) (*sql.Rows, error) {
+	query, err := rewrite(query)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := tracer().Start(ctx, "db.Query", trace.WithAttributes(attribute.String("db.statement", query)))
+	rows, err := db.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func dbExec(ctx context.Context, db sqlConn, query string, args ...interface{}) (sql.Result, error) {
+	query, err := rewrite(query)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := tracer().Start(ctx, "db.Exec", trace.WithAttributes(attribute.String("db.statement", query)))
+	res, err := db.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+// dbInsertID runs an INSERT into a table with an auto_increment id and returns
+// the new row's id.
+func dbInsertID(ctx context.Context, db sqlConn, query string, args ...interface{}) (int64, error) {
+	if dialect.Returning() {
+		var id int64
+		err := dbQueryRow(ctx, db, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	res, err := dbExec(ctx, db, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// tracedConn adds the spans above to the queries made through internal/store.
+type tracedConn struct {
+	db sqlConn
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return dbExec(ctx, c.db, query, args...)
+}
+
+func (c tracedConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return dbQuery(ctx, c.db, query, args...)
+}
+
+func (c tracedConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) store.Row {
+	return dbQueryRow(ctx, c.db, query, args...)
+}
+
+func (c tracedConn) InsertID(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return dbInsertID(ctx, c.db, query, args...)
+}
+
+// queries returns the typed queries, traced, on db or a transaction.
+func queries(db sqlConn) *store.Queries {
+	return store.New(tracedConn{db})
+}
+
+// withTx runs f in a transaction, which is committed if f returns nil.
+func withTx(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) error) error {
+	ctx, span := tracer().Start(ctx, "db.Tx")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		endSpan(span, err)
+		return err
+	}
+	err = tx.Commit()
+	endSpan(span, err)
+	return err
+}
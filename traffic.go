@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// For "the bot ignored me" reports: with config.DebugTrafficFrames, the last
+// raw frames exchanged with Slack (RTM in and out, Events API requests, Web
+// API calls) are kept in memory, and "admin debug dump" uploads them. Tokens
+// and submitted flags are redacted before anything is stored, and long frames
+// are cut, so the buffer stays small.
+
+const maxFrameBytes = 4096
+
+type trafficFrame struct {
+	at        time.Time
+	direction string
+	data      string
+}
+
+type trafficRing struct {
+	lock   sync.Mutex
+	frames []trafficFrame
+	next   int
+	full   bool
+}
+
+// traffic is nil unless debug_traffic_frames is set.
+var traffic *trafficRing
+
+func setDebugTraffic(config Config) {
+	if config.DebugTrafficFrames <= 0 {
+		return
+	}
+	traffic = &trafficRing{frames: make([]trafficFrame, config.DebugTrafficFrames)}
+}
+
+var (
+	slackTokenPattern = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+|xapp-[A-Za-z0-9-]+`)
+	flagPattern       = regexp.MustCompile(`(?i)(validate\s+\S+\s+)[^"\s]+`)
+)
+
+func redactFrame(data string) string {
+	data = slackTokenPattern.ReplaceAllString(data, "[token]")
+	return flagPattern.ReplaceAllString(data, "${1}[flag]")
+}
+
+// recordTraffic stores a frame, e.g. recordTraffic("rtm in", data).
+func recordTraffic(direction string, data []byte) {
+	if traffic == nil {
+		return
+	}
+	text := redactFrame(string(data))
+	if len(text) > maxFrameBytes {
+		text = text[:maxFrameBytes] + "..."
+	}
+	traffic.lock.Lock()
+	defer traffic.lock.Unlock()
+	traffic.frames[traffic.next] = trafficFrame{at: time.Now(), direction: direction, data: text}
+	traffic.next = (traffic.next + 1) % len(traffic.frames)
+	if traffic.next == 0 {
+		traffic.full = true
+	}
+}
+
+// recordTrafficJSON stores an outbound frame before it's encoded by the
+// websocket package.
+func recordTrafficJSON(direction string, v interface{}) {
+	if traffic == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	recordTraffic(direction, data)
+}
+
+// recordAPICall stores a Web API call, without the token.
+func recordAPICall(method string, params url.Values) {
+	if traffic == nil {
+		return
+	}
+	redacted := url.Values{}
+	for k, v := range params {
+		if k != "token" {
+			redacted[k] = v
+		}
+	}
+	recordTraffic("api out", []byte(method+" "+redacted.Encode()))
+}
+
+// dumpTraffic returns the buffered frames, oldest first.
+func dumpTraffic() string {
+	traffic.lock.Lock()
+	defer traffic.lock.Unlock()
+	frames := traffic.frames[:traffic.next]
+	if traffic.full {
+		frames = append(append([]trafficFrame{}, traffic.frames[traffic.next:]...), frames...)
+	}
+	var b strings.Builder
+	for _, f := range frames {
+		fmt.Fprintf(&b, "%s %-10s %s\n", f.at.UTC().Format("15:04:05.000"), f.direction, f.data)
+	}
+	return b.String()
+}
+
+// admin debug dump
+func doAdminDebug(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	if args[0] != "dump" {
+		postError(ctx, ws, m.Channel, "usage: admin debug dump", m.User)
+		return
+	}
+	if traffic == nil {
+		postError(ctx, ws, m.Channel, "traffic isn't being recorded, set debug_traffic_frames.", m.User)
+		return
+	}
+	dump := dumpTraffic()
+	if dump == "" {
+		postText(ws, m.Channel, "Nothing recorded yet.")
+		return
+	}
+	err := uploadFile(config, m.Channel, "slack-traffic.txt", "text", dump)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+	}
+}
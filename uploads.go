@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Some answers are too long to paste (keys, transcripts). A challenge with an
+// upload validator accepts a file instead: the player DMs it to the bot with
+// "validate <level>" as the message. The bot downloads it (files:read scope),
+// runs the validator of each released upload challenge of the level with the
+// file on stdin, and then treats the guess like a typed flag, with
+// "file:<sha256 of the file>" as the flag: it counts as a try, is logged,
+// and sending the same wrong file twice is a duplicate. Only guesses which
+// come with the file are checked against the validators' verdicts, a typed
+// (or API) "file:..." flag is turned down.
+
+type UploadValidator struct {
+	// Run with the file on stdin, from the bot's directory; exit status 0
+	// accepts it.
+	Command []string `yaml:"command" json:"command"`
+	// Largest file accepted, 0 for 64 KiB.
+	MaxBytes int `yaml:"max_bytes" json:"max_bytes"`
+}
+
+const (
+	defaultUploadMaxBytes = 64 << 10
+	uploadFlagPrefix      = "file:"
+	uploadTimeout         = 10 * time.Second
+)
+
+func (v UploadValidator) maxBytes() int {
+	if v.MaxBytes <= 0 {
+		return defaultUploadMaxBytes
+	}
+	return v.MaxBytes
+}
+
+// slackFile is a file shared in a message. Only some fields are included.
+type slackFile struct {
+	Id                 string `json:"id"`
+	Name               string `json:"name"`
+	Size               int    `json:"size"`
+	UrlPrivateDownload string `json:"url_private_download"`
+}
+
+type uploadKey struct {
+	challengeID int
+	hash        string
+}
+
+// uploadVerdicts remembers which files the validators accepted, for
+// Challenge.matches. It is cleared when it gets big: a file is only looked
+// up right after it was validated, or when the guess is replayed after a
+// database outage.
+var uploadVerdicts = map[uploadKey]bool{}
+var uploadVerdictsLock sync.Mutex
+
+const maxUploadVerdicts = 1000
+
+func setUploadVerdict(challengeID int, hash string, ok bool) {
+	uploadVerdictsLock.Lock()
+	defer uploadVerdictsLock.Unlock()
+	if len(uploadVerdicts) >= maxUploadVerdicts {
+		uploadVerdicts = map[uploadKey]bool{}
+	}
+	uploadVerdicts[uploadKey{challengeID, hash}] = ok
+}
+
+// uploadAccepted tells if flag is a file the challenge's validator accepted.
+func (c Challenge) uploadAccepted(flag string) bool {
+	if c.Upload == nil || !strings.HasPrefix(flag, uploadFlagPrefix) {
+		return false
+	}
+	uploadVerdictsLock.Lock()
+	defer uploadVerdictsLock.Unlock()
+	return uploadVerdicts[uploadKey{c.ID, strings.TrimPrefix(flag, uploadFlagPrefix)}]
+}
+
+// runValidator runs the challenge's validator on data.
+func runValidator(ctx context.Context, c Challenge, data []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Upload.Command[0], c.Upload.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+		logf(ctx, "runValidator: challenge %d rejected the file: %s %s", c.ID, err, strings.TrimSpace(stderr.String()))
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("validator of challenge %d: %s", c.ID, err)
+	}
+	return true, nil
+}
+
+// downloadFile fetches a shared file, failing if it has more than max bytes.
+func downloadFile(ctx context.Context, config Config, f slackFile, max int) ([]byte, error) {
+	var data []byte
+	err := traceSlack(ctx, "files.download", func() error {
+		req, err := http.NewRequest("GET", f.UrlPrivateDownload, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+config.SlackApiToken)
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("downloading %s failed with code %d", f.Id, resp.StatusCode)
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+		return err
+	})
+	if err == nil && len(data) > max {
+		err = errFileTooBig
+	}
+	return data, err
+}
+
+var errFileTooBig = errors.New("file too big")
+
+// validate <level> [pow:<n>], with a file attached
+func doValidateUpload(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn, m Message, args []string) {
+	u, err := resolveUser(ctx, config, m.User)
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	if len(m.Files) > 1 {
+		postError(ctx, ws, m.Channel, tr(config, u, "upload.one-file", "please attach a single file."), m.User)
+		return
+	}
+	// Before downloading anything.
+	if m.Channel == getPublicChannel() {
+		postError(ctx, ws, m.Channel, tr(config, u, "validate.public", "please send flags in a private message."), m.User)
+		return
+	}
+	level, err := parseLevel(args[0])
+	if err != nil {
+		postError(ctx, ws, m.Channel, tr(config, u, "validate.bad-level", "%s is not a valid puzzle number", escapeText(args[0])), m.User)
+		return
+	}
+	validators := []Challenge{}
+	max := 0
+	for _, c := range currentChallenges() {
+		if c.Level == level && c.Upload != nil && c.released(time.Now()) {
+			validators = append(validators, c)
+			if c.Upload.maxBytes() > max {
+				max = c.Upload.maxBytes()
+			}
+		}
+	}
+	if len(validators) == 0 {
+		postError(ctx, ws, m.Channel, tr(config, u, "upload.not-accepted", "level %d doesn't take files, send the flag as text.", level), m.User)
+		return
+	}
+	f := m.Files[0]
+	if f.Size > max {
+		postError(ctx, ws, m.Channel, tr(config, u, "upload.too-big", "files are at most %d bytes.", max), m.User)
+		return
+	}
+	data, err := downloadFile(ctx, config, f, max)
+	if err == errFileTooBig {
+		postError(ctx, ws, m.Channel, tr(config, u, "upload.too-big", "files are at most %d bytes.", max), m.User)
+		return
+	}
+	if err != nil {
+		postInternalError(ctx, ws, m.Channel, err, m.User)
+		return
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	logf(ctx, "doValidateUpload: %s sent %s (%d bytes, %s) for level %d", u.username, f.Name, len(data), hash, level)
+	for _, c := range validators {
+		if len(data) > c.Upload.maxBytes() {
+			continue
+		}
+		ok, err := runValidator(ctx, c, data)
+		if err != nil {
+			postInternalError(ctx, ws, m.Channel, err, m.User)
+			return
+		}
+		setUploadVerdict(c.ID, hash, ok)
+		if ok {
+			break
+		}
+	}
+	flag := uploadFlagPrefix + hash
+	if len(args) > 1 && strings.HasPrefix(args[len(args)-1], "pow:") {
+		flag += " " + args[len(args)-1]
+	}
+	doValidate(ctx, config, db, ws, m.User, m.Channel, m.Timestamp, args[0], flag, true)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// With config.TeamUserGroups, start creates a Slack user group for the team
+// (e.g. @team-llamas), so teams get their own handle and announcements about
+// a team can mention all its members. Needs the usergroups:write scope. The
+// group's ID is kept in bot_state.
+
+type responseUserGroup struct {
+	UserGroup struct {
+		ID string `json:"id"`
+	} `json:"usergroup"`
+}
+
+var handleUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func teamHandle(teamName string) string {
+	slug := strings.Trim(handleUnsafe.ReplaceAllString(strings.ToLower(teamName), "-"), "-")
+	if len(slug) > 40 {
+		slug = slug[:40]
+	}
+	return "team-" + slug
+}
+
+func userGroupKey(teamID int) string {
+	return "usergroup:" + strconv.Itoa(teamID)
+}
+
+func createTeamUserGroup(ctx context.Context, config Config, db *sql.DB, teamID int, teamName string) error {
+	if !config.TeamUserGroups {
+		return nil
+	}
+	usernames, err := teamMembers(ctx, db, teamID)
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for _, username := range usernames {
+		id, err := resolveUsername(ctx, config, username)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	var resp responseUserGroup
+	err = traceSlack(ctx, "usergroups.create", func() error {
+		return callSlackAPI(config.SlackApiToken, "usergroups.create", url.Values{
+			"name":        {"Team " + teamName},
+			"handle":      {teamHandle(teamName)},
+			"description": {fmt.Sprintf("Members of team %s", teamName)},
+		}, &resp)
+	})
+	if err != nil {
+		return err
+	}
+	err = traceSlack(ctx, "usergroups.users.update", func() error {
+		return callSlackAPI(config.SlackApiToken, "usergroups.users.update", url.Values{
+			"usergroup": {resp.UserGroup.ID},
+			"users":     {strings.Join(ids, ",")},
+		}, nil)
+	})
+	if err != nil {
+		return err
+	}
+	return setBotState(ctx, db, userGroupKey(teamID), resp.UserGroup.ID)
+}
+
+// teamMention returns the mention of the team's user group, or "".
+func teamMention(ctx context.Context, db *sql.DB, teamID int) string {
+	id, err := getBotState(ctx, db, userGroupKey(teamID))
+	if err != nil {
+		logf(ctx, "teamMention: %s", err)
+	}
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf("<!subteam^%s>", id)
+}
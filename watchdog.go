@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// The websocket can stall without erroring: the process is up, a supervisor
+// sees nothing wrong, but no message is ever read again. With
+// config.WatchdogMinutes, a job checks that the main loop read something
+// recently. A quiet loop is fine if Slack is quiet too, so the watchdog only
+// acts when Slack shows activity since the last read: a message in the public
+// channel, or an event over the Events API (see events.go). It then tells the
+// admins and restarts the bot in place, which reconnects from scratch.
+
+var lastRead int64
+var lastSlackActivity int64
+
+func noteRead() {
+	atomic.StoreInt64(&lastRead, time.Now().UnixNano())
+}
+
+func noteSlackActivity() {
+	atomic.StoreInt64(&lastSlackActivity, time.Now().UnixNano())
+}
+
+type responseConversationHistory struct {
+	Messages []struct {
+		Ts string `json:"ts"`
+	} `json:"messages"`
+}
+
+// latestPublicMessage returns when the last message was posted in the public
+// channel, or the zero time if there is none.
+func latestPublicMessage(ctx context.Context, config Config) (time.Time, error) {
+	var resp responseConversationHistory
+	err := traceSlack(ctx, "conversations.history", func() error {
+		return callSlackAPI(config.SlackApiToken, "conversations.history", url.Values{"channel": {getPublicChannel()}, "limit": {"1"}}, &resp)
+	})
+	if err != nil || len(resp.Messages) == 0 {
+		return time.Time{}, err
+	}
+	ts, err := strconv.ParseFloat(resp.Messages[0].Ts, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(ts), 0), nil
+}
+
+// checkWatchdog runs every minute.
+func checkWatchdog(ctx context.Context, config Config, db *sql.DB, ws *websocket.Conn) {
+	if config.WatchdogMinutes <= 0 {
+		return
+	}
+	read := time.Unix(0, atomic.LoadInt64(&lastRead))
+	if time.Since(read) < time.Duration(config.WatchdogMinutes)*time.Minute {
+		return
+	}
+	activity := time.Unix(0, atomic.LoadInt64(&lastSlackActivity))
+	if !activity.After(read) {
+		latest, err := latestPublicMessage(ctx, config)
+		if err != nil {
+			logf(ctx, "checkWatchdog: %s", err)
+			return
+		}
+		activity = latest
+	}
+	if !activity.After(read) {
+		return
+	}
+
+	logf(ctx, "checkWatchdog: nothing read since %s but Slack was active at %s, restarting", read.Format(time.RFC3339), activity.Format(time.RFC3339))
+	dmAdmins(ctx, config, db, ws, fmt.Sprintf("Watchdog: I haven't read anything from Slack since %s although there was activity at %s. Restarting to reconnect.", read.Format(time.RFC3339), activity.Format(time.RFC3339)))
+	restart(ctx)
+}
+
+// restart replaces the process with a fresh copy of itself, same arguments
+// and environment.
+func restart(ctx context.Context) {
+	executable, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(executable, os.Args, os.Environ())
+	}
+	// Exec only returns on failure; exiting lets a supervisor take over.
+	logf(ctx, "restart: %s", err)
+	os.Exit(1)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// slackAPIURL is the base URL of the Slack Web API. It can be pointed
+// somewhere else (e.g. cmd/loadtest) with slack_api_url.
+var slackAPIURL = "https://slack.com/api/"
+
+func setSlackAPIURL(config Config) {
+	if config.SlackApiURL == "" {
+		return
+	}
+	slackAPIURL = config.SlackApiURL
+}
+
+type responseWebAPI struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// callSlackAPI calls a Web API method which slackClient doesn't cover.
+// The JSON response is decoded into result, unless result is nil.
+func callSlackAPI(token string, method string, params url.Values, result interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	recordAPICall(method, params)
+	params.Set("token", token)
+	resp, err := http.PostForm(slackAPIURL+method, params)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s failed with code %d", method, resp.StatusCode)
+	}
+
+	var respObj responseWebAPI
+	err = json.Unmarshal(body, &respObj)
+	if err != nil {
+		return err
+	}
+	if !respObj.Ok {
+		return fmt.Errorf("%s: Slack error: %s", method, respObj.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
+
+// postBlocks posts a Block Kit message, which the RTM websocket can't do.
+// text is the fallback shown in notifications.
+func postBlocks(config Config, channel string, text string, blocks []interface{}) error {
+	b, err := json.Marshal(blocks)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("channel", channel)
+	params.Set("text", text)
+	params.Set("blocks", string(b))
+	return callSlackAPI(config.SlackApiToken, "chat.postMessage", params, nil)
+}
+
+// uploadFile shares a text file (e.g. a CSV export) in channel. Needs the
+// files:write scope.
+func uploadFile(config Config, channel string, filename string, filetype string, content string) error {
+	params := url.Values{}
+	params.Set("channels", channel)
+	params.Set("content", content)
+	params.Set("filename", filename)
+	params.Set("filetype", filetype)
+	return callSlackAPI(config.SlackApiToken, "files.upload", params, nil)
+}